@@ -0,0 +1,175 @@
+package calendarbot
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lestrrat/slack"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"google.golang.org/api/calendar/v3"
+)
+
+// aclEntry is who a calendar is shared with, and how
+type aclEntry struct {
+	Scope string // "<type>:<value>", e.g. "user:bob@example.com"
+	Role  string
+}
+
+// aclSnapshotTTL is how long the sharing rules seen by NotifyACLChanges
+// are remembered. Each call remembers them again
+const aclSnapshotTTL = 30 * 24 * time.Hour
+
+// NotifyACLChanges posts who CalendarName was shared with, or is no
+// longer shared with, since the previous call. The first call only
+// records the sharing rules, which are kept in the cache under
+// "acl:<calendar>"
+func (b *Bot) NotifyACLChanges(ctx context.Context) error {
+	if b.Paused() {
+		return errPaused
+	}
+
+	s, err := b.CalendarService(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to create calendar service")
+	}
+
+	id := calendarID(b.CalendarName)
+	current := make(map[string]string)
+	err = s.Acl.List(id).Pages(ctx, func(page *calendar.Acl) error {
+		for _, rule := range page.Items {
+			current[aclScope(rule.Scope)] = rule.Role
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to list access control rules")
+	}
+
+	key := "acl:" + id
+	previous, err := b.aclSnapshot(ctx, key)
+	if err != nil {
+		return err
+	}
+	if previous == nil {
+		b.Logger.Debugf(ctx, "first time listing access control rules of %s, remembering %d rules", id, len(current))
+		return b.rememberACL(ctx, key, current)
+	}
+
+	added, removed := diffACL(previous, current)
+	if len(added) > 0 || len(removed) > 0 {
+		params := b.slackParams(id)
+		if len(added) > 0 {
+			params.Attachments = append(params.Attachments, aclAttachment("Shared with", "good", added))
+		}
+		if len(removed) > 0 {
+			params.Attachments = append(params.Attachments, aclAttachment("No longer shared with", "danger", removed))
+		}
+		if err := b.postSlack(ctx, "Calendar sharing changed", &params); err != nil {
+			return errors.Wrap(err, "failed to post message to slack")
+		}
+	}
+	return b.rememberACL(ctx, key, current)
+}
+
+// aclSnapshot returns the roles by scope stored under key, or nil if
+// there are none
+func (b *Bot) aclSnapshot(ctx context.Context, key string) (map[string]string, error) {
+	v, err := b.Cache.Get(ctx, key)
+	if err != nil {
+		if IsCacheMiss(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "failed to get access control rules from cache")
+	}
+	buf, ok := cacheBytes(v)
+	if !ok {
+		return nil, nil
+	}
+	var snapshot map[string]string
+	if err := json.Unmarshal(buf, &snapshot); err != nil {
+		return nil, errors.Wrap(err, "failed to decode access control rules")
+	}
+	return snapshot, nil
+}
+
+// rememberACL replaces the roles by scope stored under key
+func (b *Bot) rememberACL(ctx context.Context, key string, current map[string]string) error {
+	buf, err := json.Marshal(current)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode access control rules")
+	}
+	b.Cache.Remove(ctx, key)
+	if err := b.Cache.Add(ctx, key, buf, aclSnapshotTTL); err != nil {
+		return errors.Wrap(err, "failed to remember access control rules")
+	}
+	return nil
+}
+
+// aclScope returns the key of a rule's scope in an ACL snapshot
+func aclScope(scope *calendar.AclRuleScope) string {
+	if scope == nil {
+		return ""
+	}
+	if scope.Value == "" {
+		return scope.Type
+	}
+	return scope.Type + ":" + scope.Value
+}
+
+// diffACL returns the rules in `current` that are not in `previous`, and
+// the other way around, sorted by scope. A changed role shows up as
+// both a removed and an added rule
+func diffACL(previous, current map[string]string) (added, removed []aclEntry) {
+	for scope, role := range current {
+		if previous[scope] != role {
+			added = append(added, aclEntry{Scope: scope, Role: role})
+		}
+	}
+	for scope, role := range previous {
+		if r, ok := current[scope]; !ok || r != role {
+			removed = append(removed, aclEntry{Scope: scope, Role: role})
+		}
+	}
+	sortACL(added)
+	sortACL(removed)
+	return added, removed
+}
+
+func sortACL(entries []aclEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Scope < entries[j].Scope
+	})
+}
+
+func aclAttachment(title, color string, entries []aclEntry) slack.Attachment {
+	lines := make([]string, len(entries))
+	for i, entry := range entries {
+		lines[i] = aclGrantee(entry.Scope) + " (" + entry.Role + ")"
+	}
+	txt := strings.Join(lines, "\n")
+	return slack.Attachment{
+		Color:    color,
+		Fallback: title + " " + strings.Join(lines, ", "),
+		Text:     txt,
+		Title:    title,
+	}
+}
+
+// aclGrantee describes the scope of a rule, e.g. "bob@example.com" or
+// "everyone at example.com"
+func aclGrantee(scope string) string {
+	i := strings.IndexByte(scope, ':')
+	if i < 0 {
+		if scope == "default" {
+			return "everyone"
+		}
+		return scope
+	}
+	if scope[:i] == "domain" {
+		return "everyone at " + scope[i+1:]
+	}
+	return scope[i+1:]
+}
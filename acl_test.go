@@ -0,0 +1,127 @@
+package calendarbot
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/lestrrat/slack"
+	"google.golang.org/api/calendar/v3"
+)
+
+func TestDiffACL(t *testing.T) {
+	previous := map[string]string{
+		"user:owner@example.com": "owner",
+		"user:bob@example.com":   "reader",
+		"domain:example.com":     "freeBusyReader",
+	}
+
+	tests := []struct {
+		name    string
+		current map[string]string
+		added   []aclEntry
+		removed []aclEntry
+	}{
+		{"unchanged", previous, nil, nil},
+		{"added", map[string]string{
+			"user:owner@example.com": "owner",
+			"user:bob@example.com":   "reader",
+			"domain:example.com":     "freeBusyReader",
+			"user:carol@example.com": "writer",
+			"default":                "reader",
+		}, []aclEntry{{"default", "reader"}, {"user:carol@example.com", "writer"}}, nil},
+		{"removed", map[string]string{
+			"user:owner@example.com": "owner",
+		}, nil, []aclEntry{{"domain:example.com", "freeBusyReader"}, {"user:bob@example.com", "reader"}}},
+		{"role changed", map[string]string{
+			"user:owner@example.com": "owner",
+			"user:bob@example.com":   "writer",
+			"domain:example.com":     "freeBusyReader",
+		}, []aclEntry{{"user:bob@example.com", "writer"}}, []aclEntry{{"user:bob@example.com", "reader"}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			added, removed := diffACL(previous, test.current)
+			if !reflect.DeepEqual(added, test.added) {
+				t.Errorf("expected added %v, got %v", test.added, added)
+			}
+			if !reflect.DeepEqual(removed, test.removed) {
+				t.Errorf("expected removed %v, got %v", test.removed, removed)
+			}
+		})
+	}
+}
+
+func TestNotifyACLChanges(t *testing.T) {
+	rule := func(typ, value, role string) *calendar.AclRule {
+		return &calendar.AclRule{Role: role, Scope: &calendar.AclRuleScope{Type: typ, Value: value}}
+	}
+	cal := &fakeCalendar{acl: &calendar.Acl{Items: []*calendar.AclRule{
+		rule("user", "owner@example.com", "owner"),
+		rule("user", "bob@example.com", "reader"),
+	}}}
+	slackAPI := &fakeSlack{}
+	b := newTestBot()
+	b.SlackTransport = slackAPI.transport
+
+	run := func(name string) []slack.Attachment {
+		before := len(slackAPI.posts())
+		if err := b.NotifyACLChanges(cal.context()); err != nil {
+			t.Fatalf("%s: NotifyACLChanges failed: %s", name, err)
+		}
+		posts := slackAPI.posts()[before:]
+		if len(posts) == 0 {
+			return nil
+		}
+		if len(posts) > 1 {
+			t.Fatalf("%s: expected at most 1 post, got %d", name, len(posts))
+		}
+		var attachments []slack.Attachment
+		if err := json.Unmarshal([]byte(posts[0].Form.Get("attachments")), &attachments); err != nil {
+			t.Fatalf("%s: failed to decode attachments: %s", name, err)
+		}
+		return attachments
+	}
+
+	if attachments := run("first run"); attachments != nil {
+		t.Errorf("expected the first run to only record the rules, got %#v", attachments)
+	}
+	if attachments := run("unchanged"); attachments != nil {
+		t.Errorf("expected nothing to be posted, got %#v", attachments)
+	}
+
+	cal.acl.Items = []*calendar.AclRule{
+		rule("user", "owner@example.com", "owner"),
+		rule("domain", "example.com", "freeBusyReader"),
+		rule("default", "", "reader"),
+	}
+	type summary struct{ Title, Color, Text string }
+	var got []summary
+	for _, a := range run("changed") {
+		got = append(got, summary{a.Title, a.Color, a.Text})
+	}
+	expect := []summary{
+		{"Shared with", "good", "everyone (reader)\neveryone at example.com (freeBusyReader)"},
+		{"No longer shared with", "danger", "bob@example.com (reader)"},
+	}
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+
+	if attachments := run("unchanged after changing"); attachments != nil {
+		t.Errorf("expected nothing to be posted, got %#v", attachments)
+	}
+
+	// The rules are kept in the cache, so a restart doesn't miss changes
+	cache := b.Cache
+	b = newTestBot()
+	b.Cache = cache
+	b.SlackTransport = slackAPI.transport
+	cal.acl.Items = cal.acl.Items[:1]
+	if attachments := run("changed after restart"); len(attachments) != 1 || attachments[0].Title != "No longer shared with" {
+		t.Errorf("expected the removed rules to be posted, got %#v", attachments)
+	}
+	if _, ok := cache.(*mapCache).data["acl:primary"]; !ok {
+		t.Errorf("expected the rules to be stored under acl:primary")
+	}
+}
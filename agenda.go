@@ -0,0 +1,335 @@
+package calendarbot
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/lestrrat/slack"
+	"github.com/pkg/errors"
+	"google.golang.org/api/calendar/v3"
+)
+
+// uncategorized is the header for events without a category when the
+// agenda is grouped
+const uncategorized = "Other"
+
+// agendaFields creates the attachment fields for the agenda posted by
+// NotifyUpcomingEvents, one line per event. Events are expected to be
+// sorted by their start time.
+//
+// When CategoryExtractor is set, events are grouped by category, with
+// the category as the title of the first field in each group. Groups
+// are sorted by name, and uncategorized events come last. Free time
+// only makes sense across all events, so it then follows the groups
+// under a heading of its own.
+func (b *Bot) agendaFields(events []*calendar.Event) ([]slack.AttachmentField, error) {
+	if b.CategoryExtractor == nil {
+		return b.agendaLines(events, b.ShowFreeTime)
+	}
+
+	groups := make(map[string][]*calendar.Event)
+	var categories []string
+	for _, event := range events {
+		category := b.CategoryExtractor(event)
+		if _, ok := groups[category]; !ok && category != "" {
+			categories = append(categories, category)
+		}
+		groups[category] = append(groups[category], event)
+	}
+	if len(categories) == 0 {
+		return b.agendaLines(events, b.ShowFreeTime)
+	}
+	sort.Strings(categories)
+	if _, ok := groups[""]; ok {
+		categories = append(categories, "")
+	}
+
+	fields := make([]slack.AttachmentField, 0, len(events)+len(categories))
+	for _, category := range categories {
+		lines, err := b.agendaLines(groups[category], false)
+		if err != nil {
+			return nil, err
+		}
+		if category == "" {
+			category = uncategorized
+		}
+		lines[0].Title = category
+		fields = append(fields, lines...)
+	}
+
+	if b.ShowFreeTime {
+		var free []slack.AttachmentField
+		var lastEnd time.Time
+		for _, event := range events {
+			t1, t2, allDay, err := eventTimes(event)
+			if err != nil {
+				return nil, err
+			}
+			if allDay {
+				continue
+			}
+			if f, ok := b.freeTimeField(lastEnd, t1); ok {
+				free = append(free, f)
+			}
+			if t2.After(lastEnd) {
+				lastEnd = t2
+			}
+		}
+		if len(free) > 0 {
+			free[0].Title = freeTimeTitle
+			fields = append(fields, free...)
+		}
+	}
+	return fields, nil
+}
+
+// freeTimeTitle is the header for free time when the agenda is grouped
+const freeTimeTitle = "Free time"
+
+// freeTimeField returns the line for the free time between lastEnd,
+// when the events before end, and start, if there is any to show
+func (b *Bot) freeTimeField(lastEnd, start time.Time) (slack.AttachmentField, bool) {
+	// Gaps under a minute can't be told apart in a "15:04" range, so
+	// they are never shown, even when MinFreeTime is 0
+	gap := start.Sub(lastEnd)
+	if lastEnd.IsZero() || gap < time.Minute || gap < b.MinFreeTime {
+		return slack.AttachmentField{}, false
+	}
+	return slack.AttachmentField{
+		Value: freeTimeLine(b.displayTime(nil, lastEnd), b.displayTime(nil, start)),
+	}, true
+}
+
+// agendaLines creates one field per event, with free time between
+// them if `free` is set
+func (b *Bot) agendaLines(events []*calendar.Event, free bool) ([]slack.AttachmentField, error) {
+	var buf bytes.Buffer
+	var lastEnd time.Time
+	fields := make([]slack.AttachmentField, 0, len(events))
+	for _, event := range events {
+		t1, t2, allDay, err := eventTimes(event)
+		if err != nil {
+			return nil, err
+		}
+
+		// All-day events don't take up any time of their own
+		if !allDay && free {
+			if f, ok := b.freeTimeField(lastEnd, t1); ok {
+				fields = append(fields, f)
+			}
+		}
+		// Overlapping events may end before the previous one did
+		if !allDay && t2.After(lastEnd) {
+			lastEnd = t2
+		}
+
+		buf.Reset()
+		if emoji, ok := b.ColorEmoji[event.ColorId]; ok && emoji != "" {
+			buf.WriteString(emoji)
+			buf.WriteByte(' ')
+		}
+		if allDay {
+			buf.WriteString("All day")
+		} else {
+			buf.WriteString(b.timeRange(event, t1, t2))
+		}
+		if isWorkingLocation(event) {
+			fmt.Fprintf(&buf, ": _%s_", workingLocationText(event))
+		} else {
+			fmt.Fprintf(&buf, ": <%s|%s>", b.eventLink(event), event.Summary)
+			if event.Location != "" {
+				buf.WriteString(" at " + locationText(event.Location))
+			}
+		}
+		if isOutOfOffice(event) {
+			buf.WriteString(" _(out of office)_")
+		}
+		if isFocusTime(event) {
+			buf.WriteString(" _(focus time)_")
+		}
+		if b.ShowHiddenInvitations && pendingResponse(event) {
+			buf.WriteString(" _(pending response)_")
+		}
+		if b.ShowEventID {
+			fmt.Fprintf(&buf, " `%s`", event.Id)
+		}
+		link, err := b.icsLink(event)
+		if err != nil {
+			return nil, err
+		}
+		if link != "" {
+			fmt.Fprintf(&buf, " <%s|Add to calendar>", link)
+		}
+
+		fields = append(fields, slack.AttachmentField{
+			Value: buf.String(),
+		})
+	}
+	return fields, nil
+}
+
+// TitlePrefixCategory is a CategoryExtractor that uses a bracketed
+// prefix of the event title, e.g. "proj" for "[proj] Planning"
+func TitlePrefixCategory(event *calendar.Event) string {
+	if !strings.HasPrefix(event.Summary, "[") {
+		return ""
+	}
+	i := strings.IndexByte(event.Summary, ']')
+	if i < 0 {
+		return ""
+	}
+	return strings.TrimSpace(event.Summary[1:i])
+}
+
+// ExtendedPropertyCategory returns a CategoryExtractor that uses the
+// value of the named extended property, private properties first
+func ExtendedPropertyCategory(name string) func(*calendar.Event) string {
+	return func(event *calendar.Event) string {
+		props := event.ExtendedProperties
+		if props == nil {
+			return ""
+		}
+		if v, ok := props.Private[name]; ok {
+			return v
+		}
+		return props.Shared[name]
+	}
+}
+
+// DefaultAgendaHeader is the default value of Bot.AgendaHeader
+const DefaultAgendaHeader = `Upcoming events between {{.Start.Format "2006 Jan 02 15:04"}} to {{.End.Format "2006 Jan 02 15:04"}}`
+
+// AgendaHeaderData is passed to the Bot.AgendaHeader template. The
+// "duration" function formats a time.Duration as e.g. "1h30m"
+type AgendaHeaderData struct {
+	Start    time.Time     // Beginning of the agenda
+	End      time.Time     // End of the agenda
+	Count    int           // Number of events, including all-day events
+	Duration time.Duration // Time covered by events, not counting all-day events and overlaps
+}
+
+var agendaHeaderFuncs = template.FuncMap{
+	"duration": formatDuration,
+}
+
+// agendaHeader renders the agenda title for events between start and end
+func (b *Bot) agendaHeader(start, end time.Time, events []*calendar.Event) (string, error) {
+	text := b.AgendaHeader
+	if text == "" {
+		text = DefaultAgendaHeader
+	}
+	tmpl, err := template.New("header").Funcs(agendaHeaderFuncs).Parse(text)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to parse agenda header")
+	}
+
+	busy, err := busyDuration(events, start, end)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	data := AgendaHeaderData{
+		Start:    start,
+		End:      end,
+		Count:    len(events),
+		Duration: busy,
+	}
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", errors.Wrap(err, "failed to render agenda header")
+	}
+	return buf.String(), nil
+}
+
+// busyDuration returns how much of the time between start and end is
+// covered by events. Overlapping events are only counted once, and
+// all-day events, which only carry a date, are not counted at all
+func busyDuration(events []*calendar.Event, start, end time.Time) (time.Duration, error) {
+	type span struct{ from, to time.Time }
+	spans := make([]span, 0, len(events))
+	for _, event := range events {
+		t1, t2, allDay, err := eventTimes(event)
+		if err != nil {
+			return 0, err
+		}
+		if allDay {
+			continue
+		}
+
+		// Only count the part inside the agenda
+		if t1.Before(start) {
+			t1 = start
+		}
+		if t2.After(end) {
+			t2 = end
+		}
+		if t2.After(t1) {
+			spans = append(spans, span{t1, t2})
+		}
+	}
+	sort.Slice(spans, func(i, j int) bool {
+		return spans[i].from.Before(spans[j].from)
+	})
+
+	var total time.Duration
+	var last time.Time
+	for _, s := range spans {
+		if s.from.Before(last) {
+			s.from = last
+		}
+		if s.to.After(s.from) {
+			total += s.to.Sub(s.from)
+			last = s.to
+		}
+	}
+	return total, nil
+}
+
+// freeTimeLine renders a gap between two events, e.g. "2h free 11:00-13:00"
+func freeTimeLine(from, to time.Time) string {
+	return fmt.Sprintf("_%s free %s-%s_", formatDuration(to.Sub(from)), from.Format("15:04"), to.Format("15:04"))
+}
+
+// timeRange renders the times of event, which isn't an all-day event,
+// e.g. "09:00-10:00", or "09:00 (1h)" with EndAsDuration
+func (b *Bot) timeRange(event *calendar.Event, start, end time.Time) string {
+	from := b.displayTime(event, start).Format("15:04")
+	if b.EndAsDuration {
+		return fmt.Sprintf("%s (%s)", from, formatDuration(end.Sub(start)))
+	}
+	return from + "-" + b.displayTime(event, end).Format("15:04")
+}
+
+// formatDuration renders d in a compact form such as "2h", "1h30m" or "45m"
+func formatDuration(d time.Duration) string {
+	h := int(d / time.Hour)
+	m := int((d % time.Hour) / time.Minute)
+	switch {
+	case h > 0 && m > 0:
+		return fmt.Sprintf("%dh%dm", h, m)
+	case h > 0:
+		return fmt.Sprintf("%dh", h)
+	default:
+		return fmt.Sprintf("%dm", m)
+	}
+}
+
+// agendaHash returns a digest of an agenda as it would be posted to
+// channel, so that identical agendas can be detected
+func agendaHash(channel string, attachments []slack.Attachment) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n", channel)
+	for _, a := range attachments {
+		fmt.Fprintf(h, "%s\n", a.Title)
+		for _, f := range a.Fields {
+			fmt.Fprintf(h, "%s\t%s\n", f.Title, f.Value)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
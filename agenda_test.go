@@ -0,0 +1,830 @@
+package calendarbot
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lestrrat/slack"
+	"google.golang.org/api/calendar/v3"
+)
+
+func testEvent(summary, start, end string) *calendar.Event {
+	return &calendar.Event{
+		Id:       summary,
+		Summary:  summary,
+		HtmlLink: "https://calendar.google.com/event?eid=" + summary,
+		Start:    &calendar.EventDateTime{DateTime: start},
+		End:      &calendar.EventDateTime{DateTime: end},
+	}
+}
+
+func fieldValues(t *testing.T, b *Bot, events []*calendar.Event) []string {
+	t.Helper()
+	fields, err := b.agendaFields(events)
+	if err != nil {
+		t.Fatalf("agendaFields failed: %s", err)
+	}
+	values := make([]string, len(fields))
+	for i, f := range fields {
+		values[i] = f.Value
+	}
+	return values
+}
+
+func TestAgendaFreeTime(t *testing.T) {
+	events := []*calendar.Event{
+		testEvent("a", "2017-01-10T09:00:00Z", "2017-01-10T10:00:00Z"),
+		testEvent("b", "2017-01-10T10:30:00Z", "2017-01-10T11:00:00Z"),
+		testEvent("c", "2017-01-10T13:00:00Z", "2017-01-10T14:00:00Z"),
+		testEvent("d", "2017-01-10T15:15:00Z", "2017-01-10T16:00:00Z"),
+		testEvent("e", "2017-01-10T16:00:30Z", "2017-01-10T17:00:00Z"),
+	}
+
+	tests := []struct {
+		name    string
+		show    bool
+		minimum time.Duration
+		expect  []string
+	}{
+		{
+			name:    "disabled",
+			minimum: time.Hour,
+			expect: []string{
+				"09:00-10:00: <https://calendar.google.com/event?eid=a|a>",
+				"10:30-11:00: <https://calendar.google.com/event?eid=b|b>",
+				"13:00-14:00: <https://calendar.google.com/event?eid=c|c>",
+				"15:15-16:00: <https://calendar.google.com/event?eid=d|d>",
+				"16:00-17:00: <https://calendar.google.com/event?eid=e|e>",
+			},
+		},
+		{
+			name:    "no minimum",
+			show:    true,
+			minimum: 0,
+			expect: []string{
+				"09:00-10:00: <https://calendar.google.com/event?eid=a|a>",
+				"_30m free 10:00-10:30_",
+				"10:30-11:00: <https://calendar.google.com/event?eid=b|b>",
+				"_2h free 11:00-13:00_",
+				"13:00-14:00: <https://calendar.google.com/event?eid=c|c>",
+				"_1h15m free 14:00-15:15_",
+				"15:15-16:00: <https://calendar.google.com/event?eid=d|d>",
+				"16:00-17:00: <https://calendar.google.com/event?eid=e|e>",
+			},
+		},
+		{
+			name:    "30 minute minimum",
+			show:    true,
+			minimum: 30 * time.Minute,
+			expect: []string{
+				"09:00-10:00: <https://calendar.google.com/event?eid=a|a>",
+				"_30m free 10:00-10:30_",
+				"10:30-11:00: <https://calendar.google.com/event?eid=b|b>",
+				"_2h free 11:00-13:00_",
+				"13:00-14:00: <https://calendar.google.com/event?eid=c|c>",
+				"_1h15m free 14:00-15:15_",
+				"15:15-16:00: <https://calendar.google.com/event?eid=d|d>",
+				"16:00-17:00: <https://calendar.google.com/event?eid=e|e>",
+			},
+		},
+		{
+			name:    "1 hour minimum",
+			show:    true,
+			minimum: time.Hour,
+			expect: []string{
+				"09:00-10:00: <https://calendar.google.com/event?eid=a|a>",
+				"10:30-11:00: <https://calendar.google.com/event?eid=b|b>",
+				"_2h free 11:00-13:00_",
+				"13:00-14:00: <https://calendar.google.com/event?eid=c|c>",
+				"_1h15m free 14:00-15:15_",
+				"15:15-16:00: <https://calendar.google.com/event?eid=d|d>",
+				"16:00-17:00: <https://calendar.google.com/event?eid=e|e>",
+			},
+		},
+		{
+			name:    "3 hour minimum",
+			show:    true,
+			minimum: 3 * time.Hour,
+			expect: []string{
+				"09:00-10:00: <https://calendar.google.com/event?eid=a|a>",
+				"10:30-11:00: <https://calendar.google.com/event?eid=b|b>",
+				"13:00-14:00: <https://calendar.google.com/event?eid=c|c>",
+				"15:15-16:00: <https://calendar.google.com/event?eid=d|d>",
+				"16:00-17:00: <https://calendar.google.com/event?eid=e|e>",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := New()
+			b.ShowFreeTime = test.show
+			b.MinFreeTime = test.minimum
+
+			values := fieldValues(t, b, events)
+			if !reflect.DeepEqual(values, test.expect) {
+				t.Errorf("expected %q, got %q", test.expect, values)
+			}
+		})
+	}
+}
+
+func TestAgendaFreeTimeOverlap(t *testing.T) {
+	b := New()
+	b.ShowFreeTime = true
+	b.MinFreeTime = time.Hour
+
+	// "b" is contained in "a", so the gap starts when "a" ends
+	events := []*calendar.Event{
+		testEvent("a", "2017-01-10T09:00:00Z", "2017-01-10T12:00:00Z"),
+		testEvent("b", "2017-01-10T10:00:00Z", "2017-01-10T11:00:00Z"),
+		testEvent("c", "2017-01-10T13:00:00Z", "2017-01-10T14:00:00Z"),
+	}
+	expect := []string{
+		"09:00-12:00: <https://calendar.google.com/event?eid=a|a>",
+		"10:00-11:00: <https://calendar.google.com/event?eid=b|b>",
+		"_1h free 12:00-13:00_",
+		"13:00-14:00: <https://calendar.google.com/event?eid=c|c>",
+	}
+	values := fieldValues(t, b, events)
+	if !reflect.DeepEqual(values, expect) {
+		t.Errorf("expected %q, got %q", expect, values)
+	}
+}
+
+// testAllDayEvent creates an instance of a yearly recurring all-day
+// event, as returned with SingleEvents(true)
+func testAllDayEvent(summary, date, next string) *calendar.Event {
+	event := testEvent(summary, "", "")
+	event.Id = summary + "_" + strings.Replace(date, "-", "", -1)
+	event.HtmlLink = "https://calendar.google.com/event?eid=" + summary
+	event.Start.Date = date
+	event.End.Date = next
+	event.RecurringEventId = summary
+	event.OriginalStartTime = &calendar.EventDateTime{Date: date}
+	return event
+}
+
+func TestAgendaAllDay(t *testing.T) {
+	b := New()
+	b.ShowFreeTime = true
+
+	// All-day events don't affect free time
+	events := []*calendar.Event{
+		testAllDayEvent("birthday", "2017-01-10", "2017-01-11"),
+		testEvent("a", "2017-01-10T09:00:00Z", "2017-01-10T10:00:00Z"),
+		testEvent("b", "2017-01-10T12:00:00Z", "2017-01-10T13:00:00Z"),
+	}
+	expect := []string{
+		"All day: <https://calendar.google.com/event?date=20170110&eid=birthday|birthday>",
+		"09:00-10:00: <https://calendar.google.com/event?eid=a|a>",
+		"_2h free 10:00-12:00_",
+		"12:00-13:00: <https://calendar.google.com/event?eid=b|b>",
+	}
+	values := fieldValues(t, b, events)
+	if !reflect.DeepEqual(values, expect) {
+		t.Errorf("expected %q, got %q", expect, values)
+	}
+}
+
+func TestEventTimes(t *testing.T) {
+	tests := []struct {
+		name   string
+		event  *calendar.Event
+		start  string
+		end    string
+		allDay bool
+	}{
+		{
+			name:  "timed",
+			event: testEvent("a", "2017-01-10T09:00:00+09:00", "2017-01-10T10:00:00+09:00"),
+			start: "2017-01-10T00:00:00Z",
+			end:   "2017-01-10T01:00:00Z",
+		},
+		{
+			name:   "all day",
+			event:  testAllDayEvent("holiday", "2017-01-10", "2017-01-11"),
+			start:  "2017-01-10T00:00:00Z",
+			end:    "2017-01-11T00:00:00Z",
+			allDay: true,
+		},
+		{
+			name:   "several days",
+			event:  testAllDayEvent("vacation", "2017-01-10", "2017-01-14"),
+			start:  "2017-01-10T00:00:00Z",
+			end:    "2017-01-14T00:00:00Z",
+			allDay: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			start, end, allDay, err := eventTimes(test.event)
+			if err != nil {
+				t.Fatalf("eventTimes failed: %s", err)
+			}
+			if !start.Equal(mustParseTime(t, test.start)) || !end.Equal(mustParseTime(t, test.end)) {
+				t.Errorf("expected %s - %s, got %s - %s", test.start, test.end, start, end)
+			}
+			if allDay != test.allDay {
+				t.Errorf("expected all-day to be %t", test.allDay)
+			}
+		})
+	}
+}
+
+func TestAgendaColorEmoji(t *testing.T) {
+	b := New()
+	b.ColorEmoji = map[string]string{
+		"5":  ":star:",
+		"11": ":red_circle:",
+		"3":  "",
+	}
+
+	mapped := testEvent("a", "2017-01-10T09:00:00Z", "2017-01-10T10:00:00Z")
+	mapped.ColorId = "11"
+	unmapped := testEvent("b", "2017-01-10T10:00:00Z", "2017-01-10T11:00:00Z")
+	unmapped.ColorId = "7"
+	empty := testEvent("c", "2017-01-10T11:00:00Z", "2017-01-10T12:00:00Z")
+	empty.ColorId = "3"
+	uncolored := testEvent("d", "2017-01-10T12:00:00Z", "2017-01-10T13:00:00Z")
+
+	expect := []string{
+		":red_circle: 09:00-10:00: <https://calendar.google.com/event?eid=a|a>",
+		"10:00-11:00: <https://calendar.google.com/event?eid=b|b>",
+		"11:00-12:00: <https://calendar.google.com/event?eid=c|c>",
+		"12:00-13:00: <https://calendar.google.com/event?eid=d|d>",
+	}
+	values := fieldValues(t, b, []*calendar.Event{mapped, unmapped, empty, uncolored})
+	if !reflect.DeepEqual(values, expect) {
+		t.Errorf("expected %q, got %q", expect, values)
+	}
+}
+
+func mustParseTime(t *testing.T, s string) time.Time {
+	t.Helper()
+	v, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		t.Fatalf("failed to parse %s: %s", s, err)
+	}
+	return v
+}
+
+func TestAgendaHash(t *testing.T) {
+	agenda := func(values ...string) []slack.Attachment {
+		fields := make([]slack.AttachmentField, len(values))
+		for i, v := range values {
+			fields[i] = slack.AttachmentField{Value: v}
+		}
+		return []slack.Attachment{{Title: "Upcoming events", Fields: fields}}
+	}
+
+	base := agendaHash("general", agenda("09:00-10:00: a", "10:00-11:00: b"))
+	if h := agendaHash("general", agenda("09:00-10:00: a", "10:00-11:00: b")); h != base {
+		t.Errorf("identical agendas should have the same hash")
+	}
+
+	changed := map[string]string{
+		"moved":   agendaHash("general", agenda("09:00-10:00: a", "10:30-11:30: b")),
+		"added":   agendaHash("general", agenda("09:00-10:00: a", "10:00-11:00: b", "12:00-13:00: c")),
+		"removed": agendaHash("general", agenda("09:00-10:00: a")),
+		"channel": agendaHash("random", agenda("09:00-10:00: a", "10:00-11:00: b")),
+	}
+	for name, h := range changed {
+		if h == base {
+			t.Errorf("%s: changed agenda should have a different hash", name)
+		}
+	}
+}
+
+func TestAgendaEventID(t *testing.T) {
+	events := []*calendar.Event{
+		testEvent("a", "2017-01-10T09:00:00Z", "2017-01-10T10:00:00Z"),
+	}
+
+	b := New()
+	expect := []string{"09:00-10:00: <https://calendar.google.com/event?eid=a|a>"}
+	if values := fieldValues(t, b, events); !reflect.DeepEqual(values, expect) {
+		t.Errorf("expected %q, got %q", expect, values)
+	}
+
+	b.ShowEventID = true
+	expect = []string{"09:00-10:00: <https://calendar.google.com/event?eid=a|a> `a`"}
+	if values := fieldValues(t, b, events); !reflect.DeepEqual(values, expect) {
+		t.Errorf("expected %q, got %q", expect, values)
+	}
+}
+
+func TestAgendaCategories(t *testing.T) {
+	events := []*calendar.Event{
+		testEvent("[web] deploy", "2017-01-10T09:00:00Z", "2017-01-10T10:00:00Z"),
+		testEvent("lunch", "2017-01-10T12:00:00Z", "2017-01-10T13:00:00Z"),
+		testEvent("[api] review", "2017-01-10T13:00:00Z", "2017-01-10T14:00:00Z"),
+		testEvent("[web] retro", "2017-01-10T15:00:00Z", "2017-01-10T16:00:00Z"),
+	}
+	link := func(s string) string {
+		return "<https://calendar.google.com/event?eid=" + s + "|" + s + ">"
+	}
+
+	t.Run("grouped", func(t *testing.T) {
+		b := New()
+		b.ShowFreeTime = true
+		b.CategoryExtractor = TitlePrefixCategory
+
+		// Free time is computed across groups, and listed after them
+		expect := []slack.AttachmentField{
+			{Title: "api", Value: "13:00-14:00: " + link("[api] review")},
+			{Title: "web", Value: "09:00-10:00: " + link("[web] deploy")},
+			{Value: "15:00-16:00: " + link("[web] retro")},
+			{Title: "Other", Value: "12:00-13:00: " + link("lunch")},
+			{Title: "Free time", Value: "_2h free 10:00-12:00_"},
+			{Value: "_1h free 14:00-15:00_"},
+		}
+		fields, err := b.agendaFields(events)
+		if err != nil {
+			t.Fatalf("agendaFields failed: %s", err)
+		}
+		if !reflect.DeepEqual(fields, expect) {
+			t.Errorf("expected %#v, got %#v", expect, fields)
+		}
+	})
+
+	t.Run("uncategorized only", func(t *testing.T) {
+		b := New()
+		b.CategoryExtractor = TitlePrefixCategory
+
+		plain := []*calendar.Event{events[1]}
+		expect := []slack.AttachmentField{
+			{Value: "12:00-13:00: " + link("lunch")},
+		}
+		fields, err := b.agendaFields(plain)
+		if err != nil {
+			t.Fatalf("agendaFields failed: %s", err)
+		}
+		if !reflect.DeepEqual(fields, expect) {
+			t.Errorf("expected %#v, got %#v", expect, fields)
+		}
+	})
+}
+
+func TestCategoryExtractors(t *testing.T) {
+	tests := []struct {
+		name    string
+		extract func(*calendar.Event) string
+		event   *calendar.Event
+		expect  string
+	}{
+		{"prefix", TitlePrefixCategory, &calendar.Event{Summary: "[proj] Planning"}, "proj"},
+		{"prefix with spaces", TitlePrefixCategory, &calendar.Event{Summary: "[ proj ] Planning"}, "proj"},
+		{"no prefix", TitlePrefixCategory, &calendar.Event{Summary: "Planning [proj]"}, ""},
+		{"unterminated prefix", TitlePrefixCategory, &calendar.Event{Summary: "[proj Planning"}, ""},
+		{
+			"private property",
+			ExtendedPropertyCategory("category"),
+			&calendar.Event{ExtendedProperties: &calendar.EventExtendedProperties{
+				Private: map[string]string{"category": "ops"},
+				Shared:  map[string]string{"category": "shared"},
+			}},
+			"ops",
+		},
+		{
+			"shared property",
+			ExtendedPropertyCategory("category"),
+			&calendar.Event{ExtendedProperties: &calendar.EventExtendedProperties{
+				Shared: map[string]string{"category": "shared"},
+			}},
+			"shared",
+		},
+		{"no properties", ExtendedPropertyCategory("category"), &calendar.Event{}, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.extract(test.event); got != test.expect {
+				t.Errorf("expected %q, got %q", test.expect, got)
+			}
+		})
+	}
+}
+
+func TestAgendaHeader(t *testing.T) {
+	start := mustParseTime(t, "2017-01-10T08:00:00Z")
+	end := mustParseTime(t, "2017-01-10T18:00:00Z")
+
+	allDay := testEvent("holiday", "", "")
+	allDay.Start.Date = "2017-01-10"
+	allDay.End.Date = "2017-01-11"
+	events := []*calendar.Event{
+		allDay,
+		// Only the hour after start is counted
+		testEvent("early", "2017-01-10T07:00:00Z", "2017-01-10T09:00:00Z"),
+		// "b" overlaps "a" by 30 minutes
+		testEvent("a", "2017-01-10T10:00:00Z", "2017-01-10T11:00:00Z"),
+		testEvent("b", "2017-01-10T10:30:00Z", "2017-01-10T11:30:00Z"),
+		// "c" is contained in "d"
+		testEvent("d", "2017-01-10T13:00:00Z", "2017-01-10T14:00:00Z"),
+		testEvent("c", "2017-01-10T13:15:00Z", "2017-01-10T13:45:00Z"),
+	}
+
+	tests := []struct {
+		name   string
+		header string
+		events []*calendar.Event
+		expect string
+	}{
+		{
+			name:   "default",
+			header: DefaultAgendaHeader,
+			events: events,
+			expect: "Upcoming events between 2017 Jan 10 08:00 to 2017 Jan 10 18:00",
+		},
+		{
+			name:   "empty",
+			events: events,
+			expect: "Upcoming events between 2017 Jan 10 08:00 to 2017 Jan 10 18:00",
+		},
+		{
+			name:   "count and duration",
+			header: "You have {{.Count}} meetings today ({{duration .Duration}} total)",
+			events: events,
+			expect: "You have 6 meetings today (3h30m total)",
+		},
+		{
+			name:   "all-day only",
+			header: "{{.Count}} {{duration .Duration}}",
+			events: []*calendar.Event{allDay},
+			expect: "1 0m",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := New()
+			b.AgendaHeader = test.header
+			got, err := b.agendaHeader(start, end, test.events)
+			if err != nil {
+				t.Fatalf("agendaHeader failed: %s", err)
+			}
+			if got != test.expect {
+				t.Errorf("expected %q, got %q", test.expect, got)
+			}
+		})
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		b := New()
+		b.AgendaHeader = "{{.Count"
+		if _, err := b.agendaHeader(start, end, events); err == nil {
+			t.Errorf("expected an error for an invalid template")
+		}
+	})
+}
+
+func TestEventStart(t *testing.T) {
+	tests := []struct {
+		name   string
+		event  *calendar.Event
+		start  string
+		allDay bool
+	}{
+		{"timed", testEvent("a", "2017-01-10T09:00:00+09:00", "2017-01-10T10:00:00+09:00"), "2017-01-10T00:00:00Z", false},
+		{"all day", testAllDayEvent("holiday", "2017-01-10", "2017-01-11"), "2017-01-10T00:00:00Z", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			start, allDay, err := eventStart(test.event)
+			if err != nil {
+				t.Fatalf("eventStart failed: %s", err)
+			}
+			if !start.Equal(mustParseTime(t, test.start)) {
+				t.Errorf("expected %s, got %s", test.start, start)
+			}
+			if allDay != test.allDay {
+				t.Errorf("expected all-day to be %t", test.allDay)
+			}
+		})
+	}
+}
+
+func TestMalformedEventTimes(t *testing.T) {
+	event := func(start, end *calendar.EventDateTime) *calendar.Event {
+		return &calendar.Event{Id: "a", Start: start, End: end}
+	}
+	tests := []struct {
+		name       string
+		event      *calendar.Event
+		startFails bool // eventStart fails too, not just eventTimes
+	}{
+		{"no start", event(nil, &calendar.EventDateTime{DateTime: "2017-01-10T10:00:00Z"}), true},
+		{"no end", event(&calendar.EventDateTime{DateTime: "2017-01-10T09:00:00Z"}, nil), false},
+		{"empty start", event(&calendar.EventDateTime{}, &calendar.EventDateTime{DateTime: "2017-01-10T10:00:00Z"}), true},
+		{"bad date/time", event(&calendar.EventDateTime{DateTime: "10 Jan 2017 09:00"}, &calendar.EventDateTime{DateTime: "2017-01-10T10:00:00Z"}), true},
+		{"bad date", event(&calendar.EventDateTime{Date: "2017/01/10"}, &calendar.EventDateTime{Date: "2017-01-11"}), true},
+		{"bad end", event(&calendar.EventDateTime{Date: "2017-01-10"}, &calendar.EventDateTime{Date: "tomorrow"}), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, _, _, err := eventTimes(test.event); err == nil {
+				t.Errorf("expected eventTimes to fail")
+			}
+			if _, _, err := eventStart(test.event); (err != nil) != test.startFails {
+				t.Errorf("expected eventStart to fail: %t, got %v", test.startFails, err)
+			}
+		})
+	}
+}
+
+func TestWorkingLocation(t *testing.T) {
+	location := func(id string, p *calendar.EventWorkingLocationProperties) *calendar.Event {
+		event := testEvent(id, "2017-01-10T09:00:00Z", "2017-01-10T17:00:00Z")
+		event.EventType = "workingLocation"
+		event.WorkingLocationProperties = p
+		return event
+	}
+	home := location("home", &calendar.EventWorkingLocationProperties{Type: "homeOffice", HomeOffice: map[string]interface{}{}})
+	office := location("office", &calendar.EventWorkingLocationProperties{
+		Type:           "officeLocation",
+		OfficeLocation: &calendar.EventWorkingLocationPropertiesOfficeLocation{Label: "Tokyo office"},
+	})
+	custom := location("custom", &calendar.EventWorkingLocationProperties{
+		Type:           "customLocation",
+		CustomLocation: &calendar.EventWorkingLocationPropertiesCustomLocation{Label: "Cafe"},
+	})
+	unknown := location("Somewhere", nil)
+	meeting := testEvent("a", "2017-01-10T10:00:00Z", "2017-01-10T11:00:00Z")
+
+	tests := []struct {
+		name   string
+		show   bool
+		expect []string
+	}{
+		{"skipped", false, []string{
+			"10:00-11:00: <https://calendar.google.com/event?eid=a|a>",
+		}},
+		{"shown", true, []string{
+			"09:00-17:00: _Working from home_",
+			"09:00-17:00: _Working at Tokyo office_",
+			"09:00-17:00: _Working at Cafe_",
+			"09:00-17:00: _Working location: Somewhere_",
+			"10:00-11:00: <https://calendar.google.com/event?eid=a|a>",
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := New()
+			b.ShowWorkingLocation = test.show
+
+			events := b.filterEvents([]*calendar.Event{home, office, custom, unknown, meeting})
+			if values := fieldValues(t, b, events); !reflect.DeepEqual(values, test.expect) {
+				t.Errorf("expected %q, got %q", test.expect, values)
+			}
+		})
+	}
+}
+
+func TestWorkingLocationReminder(t *testing.T) {
+	start := time.Now().Add(10 * time.Minute).UTC()
+	wfh := testEvent("wfh", start.Format(time.RFC3339), start.Add(8*time.Hour).Format(time.RFC3339))
+	wfh.EventType = "workingLocation"
+	meeting := testEvent("a", start.Format(time.RFC3339), start.Add(time.Hour).Format(time.RFC3339))
+	meeting.EventType = "default"
+
+	for _, show := range []bool{false, true} {
+		cal := &fakeCalendar{events: []*calendar.Event{wfh, meeting}}
+		rec := &recordingNotifier{}
+		b := newTestBot()
+		b.Notifier = rec
+		b.ShowWorkingLocation = show
+
+		if err := b.NotifyIndividualEvents(cal.context(), time.Now(), time.Hour); err != nil {
+			t.Fatalf("NotifyIndividualEvents failed: %s", err)
+		}
+		if ids, expect := rec.eventIDs(), [][]string{{"a"}}; !reflect.DeepEqual(ids, expect) {
+			t.Errorf("show %t: expected %q, got %q", show, expect, ids)
+		}
+	}
+}
+
+func TestOutOfOffice(t *testing.T) {
+	ooo := testEvent("vacation", "2017-01-10T09:00:00Z", "2017-01-10T17:00:00Z")
+	ooo.EventType = "outOfOffice"
+	meeting := testEvent("a", "2017-01-10T10:00:00Z", "2017-01-10T11:00:00Z")
+	meeting.EventType = "default"
+
+	tests := []struct {
+		name   string
+		skip   bool
+		expect []string
+	}{
+		{"marked", false, []string{
+			"09:00-17:00: <https://calendar.google.com/event?eid=vacation|vacation> _(out of office)_",
+			"10:00-11:00: <https://calendar.google.com/event?eid=a|a>",
+		}},
+		{"skipped", true, []string{
+			"10:00-11:00: <https://calendar.google.com/event?eid=a|a>",
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := New()
+			b.SkipOutOfOffice = test.skip
+
+			events := b.filterEvents([]*calendar.Event{ooo, meeting})
+			if values := fieldValues(t, b, events); !reflect.DeepEqual(values, test.expect) {
+				t.Errorf("expected %q, got %q", test.expect, values)
+			}
+		})
+	}
+}
+
+func TestFocusTime(t *testing.T) {
+	focus := testEvent("focus", "2017-01-10T09:00:00Z", "2017-01-10T11:00:00Z")
+	focus.EventType = "focusTime"
+	focus.Summary = "Deep work"
+	meeting := testEvent("a", "2017-01-10T11:00:00Z", "2017-01-10T12:00:00Z")
+
+	tests := []struct {
+		name   string
+		skip   bool
+		expect []string
+	}{
+		{"marked", false, []string{
+			"09:00-11:00: <https://calendar.google.com/event?eid=focus|Deep work> _(focus time)_",
+			"11:00-12:00: <https://calendar.google.com/event?eid=a|a>",
+		}},
+		{"skipped", true, []string{
+			"11:00-12:00: <https://calendar.google.com/event?eid=a|a>",
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := New()
+			b.SkipFocusTime = test.skip
+
+			events := b.filterEvents([]*calendar.Event{focus, meeting})
+			if values := fieldValues(t, b, events); !reflect.DeepEqual(values, test.expect) {
+				t.Errorf("expected %q, got %q", test.expect, values)
+			}
+		})
+	}
+
+	// Skipping focus time leaves other event types be
+	ooo := testEvent("vacation", "2017-01-10T13:00:00Z", "2017-01-10T17:00:00Z")
+	ooo.EventType = "outOfOffice"
+	b := New()
+	b.SkipFocusTime = true
+	if events := b.filterEvents([]*calendar.Event{focus, ooo}); len(events) != 1 || events[0] != ooo {
+		t.Errorf("expected only the out-of-office event to be kept, got %d events", len(events))
+	}
+}
+
+func TestExcludeOngoing(t *testing.T) {
+	start := mustParseTime(t, "2017-01-10T08:00:00Z")
+	tests := []struct {
+		name    string
+		exclude bool
+		expect  []string
+	}{
+		{"default", false, []string{"holiday_20170110", "standup", "later"}},
+		{"excluded", true, []string{"holiday_20170110", "later"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cal := &fakeCalendar{events: []*calendar.Event{
+				testAllDayEvent("holiday", "2017-01-10", "2017-01-11"),
+				testEvent("standup", "2017-01-10T07:45:00Z", "2017-01-10T08:15:00Z"),
+				testEvent("later", "2017-01-10T08:00:00Z", "2017-01-10T09:00:00Z"),
+			}}
+			b := newTestBot()
+			b.ExcludeOngoing = test.exclude
+
+			n, err := b.upcomingAgenda(cal.context(), start, 12*time.Hour)
+			if err != nil {
+				t.Fatalf("upcomingAgenda failed: %s", err)
+			}
+			var ids []string
+			for _, event := range n.Events {
+				ids = append(ids, event.Id)
+			}
+			if !reflect.DeepEqual(ids, test.expect) {
+				t.Errorf("expected %q, got %q", test.expect, ids)
+			}
+		})
+	}
+}
+
+func TestDisplayLocation(t *testing.T) {
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Skipf("time zone data not available: %s", err)
+	}
+
+	zoned := testEvent("zoned", "2017-01-10T11:00:00Z", "2017-01-10T12:00:00Z")
+	zoned.Start.TimeZone = "America/New_York"
+	events := []*calendar.Event{
+		testEvent("a", "2017-01-10T09:00:00Z", "2017-01-10T10:00:00+00:00"),
+		zoned,
+		testAllDayEvent("holiday", "2017-01-10", "2017-01-11"),
+	}
+	tests := []struct {
+		name     string
+		location *time.Location
+		expect   []string
+	}{
+		{"utc", time.UTC, []string{
+			"09:00-10:00: <https://calendar.google.com/event?eid=a|a>",
+			"11:00-12:00: <https://calendar.google.com/event?eid=zoned|zoned>",
+			"All day: <https://calendar.google.com/event?date=20170110&eid=holiday|holiday>",
+		}},
+		{"tokyo", tokyo, []string{
+			"18:00-19:00: <https://calendar.google.com/event?eid=a|a>",
+			"20:00-21:00: <https://calendar.google.com/event?eid=zoned|zoned>",
+			"All day: <https://calendar.google.com/event?date=20170110&eid=holiday|holiday>",
+		}},
+		{"event time zone", nil, []string{
+			"09:00-10:00: <https://calendar.google.com/event?eid=a|a>",
+			"06:00-07:00: <https://calendar.google.com/event?eid=zoned|zoned>",
+			"All day: <https://calendar.google.com/event?date=20170110&eid=holiday|holiday>",
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := New()
+			b.DisplayLocation = test.location
+			if values := fieldValues(t, b, events); !reflect.DeepEqual(values, test.expect) {
+				t.Errorf("expected %q, got %q", test.expect, values)
+			}
+
+			// Reminders too
+			start := mustParseTime(t, events[0].Start.DateTime)
+			attachment := b.reminderAttachment(events[0], start)
+			if expect := test.expect[0][:5]; attachment.Fields[0].Value != expect {
+				t.Errorf("expected reminder at %s, got %s", expect, attachment.Fields[0].Value)
+			}
+		})
+	}
+
+	// The default ignores the time zone of events
+	b := New()
+	if b.DisplayLocation != time.Local {
+		t.Errorf("expected times to be shown in the local time zone by default, got %s", b.DisplayLocation)
+	}
+	if values := fieldValues(t, b, events); !reflect.DeepEqual(values, tests[0].expect) {
+		t.Errorf("expected %q by default, got %q", tests[0].expect, values)
+	}
+}
+
+func TestEndAsDuration(t *testing.T) {
+	events := []*calendar.Event{
+		testEvent("a", "2017-01-10T09:00:00Z", "2017-01-10T09:25:00Z"),
+		testEvent("b", "2017-01-10T10:00:00Z", "2017-01-10T11:00:00Z"),
+		testEvent("c", "2017-01-10T11:00:00Z", "2017-01-10T12:30:00Z"),
+		testEvent("d", "2017-01-10T13:00:00Z", "2017-01-10T16:00:00Z"),
+		testEvent("e", "2017-01-10T20:00:00Z", "2017-01-11T02:15:00Z"),
+	}
+	tests := []struct {
+		name     string
+		duration bool
+		expect   []string
+	}{
+		{"range", false, []string{
+			"09:00-09:25: <https://calendar.google.com/event?eid=a|a>",
+			"10:00-11:00: <https://calendar.google.com/event?eid=b|b>",
+			"11:00-12:30: <https://calendar.google.com/event?eid=c|c>",
+			"13:00-16:00: <https://calendar.google.com/event?eid=d|d>",
+			"20:00-02:15: <https://calendar.google.com/event?eid=e|e>",
+		}},
+		{"duration", true, []string{
+			"09:00 (25m): <https://calendar.google.com/event?eid=a|a>",
+			"10:00 (1h): <https://calendar.google.com/event?eid=b|b>",
+			"11:00 (1h30m): <https://calendar.google.com/event?eid=c|c>",
+			"13:00 (3h): <https://calendar.google.com/event?eid=d|d>",
+			"20:00 (6h15m): <https://calendar.google.com/event?eid=e|e>",
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := New()
+			b.EndAsDuration = test.duration
+			if values := fieldValues(t, b, events); !reflect.DeepEqual(values, test.expect) {
+				t.Errorf("expected %q, got %q", test.expect, values)
+			}
+		})
+	}
+}
+
+func TestAgendaLocation(t *testing.T) {
+	room := testEvent("a", "2017-01-10T09:00:00Z", "2017-01-10T10:00:00Z")
+	room.Location = "Room 101"
+	video := testEvent("b", "2017-01-10T10:00:00Z", "2017-01-10T11:00:00Z")
+	video.Location = "https://meet.google.com/abc-defg-hij"
+	none := testEvent("c", "2017-01-10T11:00:00Z", "2017-01-10T12:00:00Z")
+
+	expect := []string{
+		"09:00-10:00: <https://calendar.google.com/event?eid=a|a> at Room 101",
+		"10:00-11:00: <https://calendar.google.com/event?eid=b|b> at <https://meet.google.com/abc-defg-hij>",
+		"11:00-12:00: <https://calendar.google.com/event?eid=c|c>",
+	}
+	if values := fieldValues(t, New(), []*calendar.Event{room, video, none}); !reflect.DeepEqual(values, expect) {
+		t.Errorf("expected %q, got %q", expect, values)
+	}
+}
@@ -1,18 +1,26 @@
 package auth
 
 import (
+	"encoding/base64"
 	"encoding/json"
+	"io"
 	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 
 	"github.com/pkg/errors"
 	"golang.org/x/net/context"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
+	"golang.org/x/oauth2/jwt"
 	"google.golang.org/api/calendar/v3"
 )
 
 type FileConfigProvider struct {
-	file string
+	file     string
+	redirect RedirectURL
 }
 
 type FileTokenProvider struct {
@@ -25,37 +33,383 @@ func NewFileConfigProvider(file string) *FileConfigProvider {
 	}
 }
 
+// NewFileConfigProviderWithRedirect is like NewFileConfigProvider,
+// using the redirect URI picked by redirect, e.g. the one matching the
+// port of a local server receiving the authorization code
+func NewFileConfigProviderWithRedirect(file string, redirect RedirectURL) *FileConfigProvider {
+	return &FileConfigProvider{
+		file:     file,
+		redirect: redirect,
+	}
+}
+
 func NewFileTokenProvider(file string) *FileTokenProvider {
 	return &FileTokenProvider{
 		file: file,
 	}
 }
 
+// ReaderConfigProvider reads the config from a reader such as os.Stdin
+// the first time it is needed, and keeps it after that
+type ReaderConfigProvider struct {
+	once   sync.Once
+	r      io.Reader
+	config *oauth2.Config
+	err    error
+}
+
+// ReaderTokenProvider is like ReaderConfigProvider, for the token
+type ReaderTokenProvider struct {
+	once  sync.Once
+	r     io.Reader
+	token *oauth2.Token
+	err   error
+}
+
+func NewReaderConfigProvider(r io.Reader) *ReaderConfigProvider {
+	return &ReaderConfigProvider{
+		r: r,
+	}
+}
+
+func NewReaderTokenProvider(r io.Reader) *ReaderTokenProvider {
+	return &ReaderTokenProvider{
+		r: r,
+	}
+}
+
+func (p *ReaderConfigProvider) OAuth2Config(_ context.Context) (*oauth2.Config, error) {
+	p.once.Do(func() {
+		p.config, p.err = ConfigFromReader(p.r)
+	})
+	return p.config, p.err
+}
+
+func (p *ReaderTokenProvider) OAuth2Token(_ context.Context) (*oauth2.Token, error) {
+	p.once.Do(func() {
+		p.token, p.err = TokenFromReader(p.r)
+	})
+	if p.err != nil {
+		return nil, p.err
+	}
+	// Callers may modify the token, e.g. when refreshing it
+	token := *p.token
+	return &token, nil
+}
+
 func (p *FileConfigProvider) OAuth2Config(_ context.Context) (*oauth2.Config, error) {
-	return ConfigFromFile(p.file)
+	return ConfigFromFileWithRedirect(p.file, p.redirect)
 }
 
 func (p *FileTokenProvider) OAuth2Token(_ context.Context) (*oauth2.Token, error) {
 	return TokenFromFile(p.file)
 }
 
+// EnvConfigProvider reads the config from the environment variable
+// `name`, which holds the same JSON as the file ConfigFromFile reads
+type EnvConfigProvider struct {
+	name string
+}
+
+// EnvTokenProvider is like EnvConfigProvider, for the token
+type EnvTokenProvider struct {
+	name string
+}
+
+func NewEnvConfigProvider(name string) *EnvConfigProvider {
+	return &EnvConfigProvider{
+		name: name,
+	}
+}
+
+func NewEnvTokenProvider(name string) *EnvTokenProvider {
+	return &EnvTokenProvider{
+		name: name,
+	}
+}
+
+func (p *EnvConfigProvider) OAuth2Config(_ context.Context) (*oauth2.Config, error) {
+	r, err := envReader(p.name)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read oauth config")
+	}
+	return ConfigFromReader(r)
+}
+
+func (p *EnvTokenProvider) OAuth2Token(_ context.Context) (*oauth2.Token, error) {
+	r, err := envReader(p.name)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read token")
+	}
+	return TokenFromReader(r)
+}
+
+// Base64EnvTokenProvider is like EnvTokenProvider, for a token whose
+// JSON is base64-encoded, as some platforms don't allow multiline
+// environment variables
+type Base64EnvTokenProvider struct {
+	name string
+}
+
+func NewBase64EnvTokenProvider(name string) *Base64EnvTokenProvider {
+	return &Base64EnvTokenProvider{
+		name: name,
+	}
+}
+
+func (p *Base64EnvTokenProvider) OAuth2Token(_ context.Context) (*oauth2.Token, error) {
+	r, err := envReader(p.name)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read token")
+	}
+	return TokenFromReader(base64.NewDecoder(base64.StdEncoding, r))
+}
+
+// envReader returns a reader for the value of the environment variable
+// `name`, which must not be empty
+func envReader(name string) (io.Reader, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return nil, errors.Errorf("environment variable %s is not set", name)
+	}
+	return strings.NewReader(v), nil
+}
+
+// RefreshingTokenProvider reads the token from a file like
+// FileTokenProvider, but refreshes it once it has expired and writes
+// the new token back to the file
+type RefreshingTokenProvider struct {
+	config      *oauth2.Config
+	file        string
+	mutex       sync.Mutex // Serializes reading and writing the file
+	tokenSource func(context.Context, *oauth2.Token) oauth2.TokenSource
+}
+
+func NewRefreshingTokenProvider(config *oauth2.Config, file string) *RefreshingTokenProvider {
+	return &RefreshingTokenProvider{
+		config:      config,
+		file:        file,
+		tokenSource: config.TokenSource,
+	}
+}
+
+func (p *RefreshingTokenProvider) OAuth2Token(ctx context.Context) (*oauth2.Token, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	stored, err := TokenFromFile(p.file)
+	if err != nil {
+		return nil, err
+	}
+
+	// The token source only goes to Google if the token has expired
+	token, err := p.tokenSource(ctx, stored).Token()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to refresh token")
+	}
+	if token.AccessToken != stored.AccessToken {
+		if err := writeTokenFile(p.file, token); err != nil {
+			return nil, err
+		}
+	}
+	return token, nil
+}
+
+// writeTokenFile replaces file with token, so that readers see either
+// the old or the new token but never a partial one
+func writeTokenFile(file string, token *oauth2.Token) error {
+	var stored oauth2.Token
+	copyToken(&stored, token)
+	buf, err := json.Marshal(&stored)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal token")
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(file), filepath.Base(file)+".")
+	if err != nil {
+		return errors.Wrap(err, "failed to create temporary token file")
+	}
+	defer os.Remove(f.Name()) // Fails harmlessly once renamed
+
+	if _, err := f.Write(buf); err != nil {
+		f.Close()
+		return errors.Wrap(err, "failed to write token file")
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return errors.Wrap(err, "failed to write token file")
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrap(err, "failed to write token file")
+	}
+	if err := os.Rename(f.Name(), file); err != nil {
+		return errors.Wrap(err, "failed to replace token file")
+	}
+	return nil
+}
+
+// ServiceAccountProvider authenticates as a service account, to run
+// without anyone going through the OAuth2 flow. It is both the
+// OAuth2ConfigProvider and the OAuth2TokenProvider of the bot
+type ServiceAccountProvider struct {
+	jwt   *jwt.Config
+	once  sync.Once
+	token oauth2.TokenSource
+}
+
+// NewServiceAccountProvider creates a ServiceAccountProvider from the
+// JSON key of a service account. If subject is not empty, the service
+// account impersonates the user with that email address, which requires
+// domain-wide delegation. Otherwise only calendars shared with the
+// service account itself can be read
+func NewServiceAccountProvider(jsonKey []byte, subject string) (*ServiceAccountProvider, error) {
+	config, err := google.JWTConfigFromJSON(jsonKey, calendar.CalendarReadonlyScope)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get service account config from JSON")
+	}
+	config.Subject = subject
+	return &ServiceAccountProvider{
+		jwt: config,
+	}, nil
+}
+
+// OAuth2Config returns a config with no client, as the service account
+// gets its tokens without one. Tokens handed out by OAuth2Token are
+// fresh, so the config never has to refresh them
+func (p *ServiceAccountProvider) OAuth2Config(_ context.Context) (*oauth2.Config, error) {
+	return &oauth2.Config{
+		Endpoint: google.Endpoint,
+		Scopes:   p.jwt.Scopes,
+	}, nil
+}
+
+func (p *ServiceAccountProvider) OAuth2Token(_ context.Context) (*oauth2.Token, error) {
+	p.once.Do(func() {
+		// Tokens are reused until they expire. The context is only
+		// used to get them, so it must outlive this call
+		p.token = p.jwt.TokenSource(context.Background())
+	})
+	token, err := p.token.Token()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get service account token")
+	}
+	return token, nil
+}
+
+// DefaultScopes are the scopes requested by ConfigFromFile and
+// ConfigFromReader: reading calendars, and the address of the user
+var DefaultScopes = []string{
+	calendar.CalendarReadonlyScope,
+	"https://www.googleapis.com/auth/userinfo.email",
+}
+
 func ConfigFromFile(file string) (*oauth2.Config, error) {
-	body, err := ioutil.ReadFile(file)
+	return ConfigFromFileWithRedirect(file, RedirectURL{})
+}
+
+// ConfigFromFileWithScopes is like ConfigFromFile, requesting exactly
+// `scopes` instead of DefaultScopes
+func ConfigFromFileWithScopes(file string, scopes ...string) (*oauth2.Config, error) {
+	f, err := os.Open(file)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to read oauth config file")
 	}
+	defer f.Close()
+
+	return configFromReader(f, RedirectURL{}, scopes)
+}
+
+// ConfigFromReaderWithScopes is like ConfigFromReader, requesting
+// exactly `scopes` instead of DefaultScopes
+func ConfigFromReaderWithScopes(r io.Reader, scopes ...string) (*oauth2.Config, error) {
+	return configFromReader(r, RedirectURL{}, scopes)
+}
+
+// ConfigFromReader reads the OAuth2 client credentials JSON downloaded
+// from the Google API console from r
+func ConfigFromReader(r io.Reader) (*oauth2.Config, error) {
+	return ConfigFromReaderWithRedirect(r, RedirectURL{})
+}
+
+// RedirectURL picks one of the redirect URIs of the client credentials,
+// by value or by position. The zero value picks the first one
+type RedirectURL struct {
+	Index int
+	URL   string // Used instead of Index if set
+}
+
+// ConfigFromFileWithRedirect is like ConfigFromFile, using the redirect
+// URI picked by redirect
+func ConfigFromFileWithRedirect(file string, redirect RedirectURL) (*oauth2.Config, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read oauth config file")
+	}
+	defer f.Close()
+
+	return ConfigFromReaderWithRedirect(f, redirect)
+}
+
+// ConfigFromReaderWithRedirect is like ConfigFromReader, using the
+// redirect URI picked by redirect
+func ConfigFromReaderWithRedirect(r io.Reader, redirect RedirectURL) (*oauth2.Config, error) {
+	return configFromReader(r, redirect, DefaultScopes)
+}
+
+func configFromReader(r io.Reader, redirect RedirectURL, scopes []string) (*oauth2.Config, error) {
+	body, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read oauth config")
+	}
 
 	config, err := google.ConfigFromJSON(body)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to get oauth config from file")
+		return nil, errors.Wrap(err, "failed to get oauth config from JSON")
 	}
-	config.Scopes = []string{
-		calendar.CalendarReadonlyScope,
-		"https://www.googleapis.com/auth/userinfo.email",
+	if config.RedirectURL, err = redirectURL(body, redirect); err != nil {
+		return nil, err
 	}
+	// Copied so that changing the config leaves the caller's slice be
+	config.Scopes = append([]string(nil), scopes...)
 	return config, nil
 }
 
+// redirectURL returns the redirect URI picked by redirect from the
+// client credentials JSON in body
+func redirectURL(body []byte, redirect RedirectURL) (string, error) {
+	type credentials struct {
+		RedirectURIs []string `json:"redirect_uris"`
+	}
+	var j struct {
+		Web       *credentials `json:"web"`
+		Installed *credentials `json:"installed"`
+	}
+	if err := json.Unmarshal(body, &j); err != nil {
+		return "", errors.Wrap(err, "failed to unmarshal JSON")
+	}
+	c := j.Web
+	if c == nil {
+		c = j.Installed
+	}
+	var uris []string
+	if c != nil {
+		uris = c.RedirectURIs
+	}
+
+	if redirect.URL != "" {
+		for _, uri := range uris {
+			if uri == redirect.URL {
+				return uri, nil
+			}
+		}
+		return "", errors.Errorf("redirect URI %s is not in the oauth config", redirect.URL)
+	}
+	if redirect.Index < 0 || redirect.Index >= len(uris) {
+		return "", errors.Errorf("redirect URI #%d requested, but the oauth config has %d", redirect.Index, len(uris))
+	}
+	return uris[redirect.Index], nil
+}
+
 func copyToken(token, stored *oauth2.Token) {
   token.AccessToken = stored.AccessToken
   token.RefreshToken = stored.RefreshToken
@@ -64,12 +418,23 @@ func copyToken(token, stored *oauth2.Token) {
 }
 
 func TokenFromFile(file string) (*oauth2.Token, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read token file")
+	}
+	defer f.Close()
+
+	return TokenFromReader(f)
+}
+
+// TokenFromReader reads a token stored as JSON from r
+func TokenFromReader(r io.Reader) (*oauth2.Token, error) {
 	var token oauth2.Token
 	var stored oauth2.Token
 
-	body, err := ioutil.ReadFile(file)
+	body, err := ioutil.ReadAll(r)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to read token file")
+		return nil, errors.Wrap(err, "failed to read token")
 	}
 
 	if err := json.Unmarshal(body, &stored); err != nil {
@@ -0,0 +1,478 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/calendar/v3"
+)
+
+const testConfig = `{"installed":{"client_id":"id.apps.googleusercontent.com","client_secret":"secret","redirect_uris":["urn:ietf:wg:oauth:2.0:oob"],"auth_uri":"https://accounts.google.com/o/oauth2/auth","token_uri":"https://oauth2.googleapis.com/token"}}`
+
+const testToken = `{"access_token":"access","token_type":"Bearer","refresh_token":"refresh","expiry":"2017-01-10T09:00:00Z"}`
+
+func expectedToken(t *testing.T) *oauth2.Token {
+	expiry, err := time.Parse(time.RFC3339, "2017-01-10T09:00:00Z")
+	if err != nil {
+		t.Fatalf("failed to parse expiry: %s", err)
+	}
+	return &oauth2.Token{AccessToken: "access", TokenType: "Bearer", RefreshToken: "refresh", Expiry: expiry}
+}
+
+func TestConfigFromReader(t *testing.T) {
+	config, err := ConfigFromReader(bytes.NewReader([]byte(testConfig)))
+	if err != nil {
+		t.Fatalf("ConfigFromReader failed: %s", err)
+	}
+	if config.ClientID != "id.apps.googleusercontent.com" || config.ClientSecret != "secret" {
+		t.Errorf("unexpected client %s/%s", config.ClientID, config.ClientSecret)
+	}
+	if len(config.Scopes) != 2 {
+		t.Errorf("expected 2 scopes, got %q", config.Scopes)
+	}
+
+	if _, err := ConfigFromReader(bytes.NewReader([]byte("{"))); err == nil {
+		t.Errorf("expected invalid JSON to fail")
+	}
+}
+
+func TestTokenFromReader(t *testing.T) {
+	token, err := TokenFromReader(bytes.NewReader([]byte(testToken)))
+	if err != nil {
+		t.Fatalf("TokenFromReader failed: %s", err)
+	}
+	if expect := expectedToken(t); !reflect.DeepEqual(token, expect) {
+		t.Errorf("expected %#v, got %#v", expect, token)
+	}
+
+	if _, err := TokenFromReader(bytes.NewReader([]byte("{"))); err == nil {
+		t.Errorf("expected invalid JSON to fail")
+	}
+}
+
+func TestReaderProviders(t *testing.T) {
+	ctx := context.Background()
+	configs := NewReaderConfigProvider(bytes.NewReader([]byte(testConfig)))
+	tokens := NewReaderTokenProvider(bytes.NewReader([]byte(testToken)))
+
+	// The reader is drained on the first call, later ones reuse the result
+	for i := 0; i < 2; i++ {
+		config, err := configs.OAuth2Config(ctx)
+		if err != nil {
+			t.Fatalf("call %d: OAuth2Config failed: %s", i, err)
+		}
+		if config.ClientID != "id.apps.googleusercontent.com" {
+			t.Errorf("call %d: unexpected client %s", i, config.ClientID)
+		}
+
+		token, err := tokens.OAuth2Token(ctx)
+		if err != nil {
+			t.Fatalf("call %d: OAuth2Token failed: %s", i, err)
+		}
+		if expect := expectedToken(t); !reflect.DeepEqual(token, expect) {
+			t.Errorf("call %d: expected %#v, got %#v", i, expect, token)
+		}
+		token.AccessToken = "modified"
+	}
+}
+
+func TestFromFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auth")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	configFile := filepath.Join(dir, "config.json")
+	tokenFile := filepath.Join(dir, "token.json")
+	if err := ioutil.WriteFile(configFile, []byte(testConfig), 0600); err != nil {
+		t.Fatalf("failed to write config: %s", err)
+	}
+	if err := ioutil.WriteFile(tokenFile, []byte(testToken), 0600); err != nil {
+		t.Fatalf("failed to write token: %s", err)
+	}
+
+	if _, err := ConfigFromFile(configFile); err != nil {
+		t.Errorf("ConfigFromFile failed: %s", err)
+	}
+	if _, err := TokenFromFile(tokenFile); err != nil {
+		t.Errorf("TokenFromFile failed: %s", err)
+	}
+	if _, err := TokenFromFile(filepath.Join(dir, "missing.json")); err == nil {
+		t.Errorf("expected a missing file to fail")
+	}
+}
+
+func TestEnvProviders(t *testing.T) {
+	const configVar = "CALENDARBOT_TEST_CONFIG"
+	const tokenVar = "CALENDARBOT_TEST_TOKEN"
+	defer os.Unsetenv(configVar)
+	defer os.Unsetenv(tokenVar)
+
+	ctx := context.Background()
+	configs := NewEnvConfigProvider(configVar)
+	tokens := NewEnvTokenProvider(tokenVar)
+
+	for _, v := range []string{"unset", ""} {
+		if v == "unset" {
+			os.Unsetenv(configVar)
+			os.Unsetenv(tokenVar)
+		} else {
+			os.Setenv(configVar, v)
+			os.Setenv(tokenVar, v)
+		}
+		if _, err := configs.OAuth2Config(ctx); err == nil || !strings.Contains(err.Error(), configVar+" is not set") {
+			t.Errorf("%q: expected the config variable to be reported, got %v", v, err)
+		}
+		if _, err := tokens.OAuth2Token(ctx); err == nil || !strings.Contains(err.Error(), tokenVar+" is not set") {
+			t.Errorf("%q: expected the token variable to be reported, got %v", v, err)
+		}
+	}
+
+	os.Setenv(configVar, testConfig)
+	os.Setenv(tokenVar, testToken)
+	config, err := configs.OAuth2Config(ctx)
+	if err != nil {
+		t.Fatalf("OAuth2Config failed: %s", err)
+	}
+	if config.ClientID != "id.apps.googleusercontent.com" {
+		t.Errorf("unexpected client %s", config.ClientID)
+	}
+	token, err := tokens.OAuth2Token(ctx)
+	if err != nil {
+		t.Fatalf("OAuth2Token failed: %s", err)
+	}
+	if expect := expectedToken(t); !reflect.DeepEqual(token, expect) {
+		t.Errorf("expected %#v, got %#v", expect, token)
+	}
+
+	os.Setenv(tokenVar, "{")
+	if _, err := tokens.OAuth2Token(ctx); err == nil {
+		t.Errorf("expected invalid JSON to fail")
+	}
+}
+
+func TestBase64EnvTokenProvider(t *testing.T) {
+	const tokenVar = "CALENDARBOT_TEST_TOKEN_BASE64"
+	defer os.Unsetenv(tokenVar)
+
+	ctx := context.Background()
+	tokens := NewBase64EnvTokenProvider(tokenVar)
+
+	os.Unsetenv(tokenVar)
+	if _, err := tokens.OAuth2Token(ctx); err == nil || !strings.Contains(err.Error(), tokenVar+" is not set") {
+		t.Errorf("expected the token variable to be reported, got %v", err)
+	}
+
+	os.Setenv(tokenVar, base64.StdEncoding.EncodeToString([]byte(testToken)))
+	token, err := tokens.OAuth2Token(ctx)
+	if err != nil {
+		t.Fatalf("OAuth2Token failed: %s", err)
+	}
+	if expect := expectedToken(t); !reflect.DeepEqual(token, expect) {
+		t.Errorf("expected %#v, got %#v", expect, token)
+	}
+
+	// Plain JSON is not base64
+	os.Setenv(tokenVar, testToken)
+	if _, err := tokens.OAuth2Token(ctx); err == nil || !strings.Contains(err.Error(), "illegal base64 data") {
+		t.Errorf("expected invalid base64 to fail, got %v", err)
+	}
+}
+
+// staticTokenSource hands out copies of token
+type staticTokenSource struct {
+	token *oauth2.Token
+}
+
+func (s staticTokenSource) Token() (*oauth2.Token, error) {
+	token := *s.token
+	return &token, nil
+}
+
+func TestRefreshingTokenProvider(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auth")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "token.json")
+	if err := ioutil.WriteFile(file, []byte(testToken), 0600); err != nil {
+		t.Fatalf("failed to write token: %s", err)
+	}
+
+	expiry := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	fresh := &oauth2.Token{AccessToken: "fresh", TokenType: "Bearer", RefreshToken: "refresh", Expiry: expiry}
+	var given []*oauth2.Token
+	var mutex sync.Mutex
+	p := NewRefreshingTokenProvider(&oauth2.Config{}, file)
+	p.tokenSource = func(_ context.Context, token *oauth2.Token) oauth2.TokenSource {
+		mutex.Lock()
+		given = append(given, token)
+		mutex.Unlock()
+		if token.AccessToken == "access" {
+			return staticTokenSource{token: fresh}
+		}
+		return staticTokenSource{token: token}
+	}
+
+	// Concurrent callers all get the fresh token
+	var wg sync.WaitGroup
+	errs := make(chan error, 5)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			token, err := p.OAuth2Token(context.Background())
+			if err == nil && !reflect.DeepEqual(token, fresh) {
+				err = errors.New("unexpected token " + token.AccessToken)
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("OAuth2Token failed: %s", err)
+		}
+	}
+
+	// Only the first caller saw the expired token
+	if len(given) != 5 || given[0].AccessToken != "access" {
+		t.Fatalf("expected the stored token to be refreshed once, got %d calls", len(given))
+	}
+	for _, token := range given[1:] {
+		if token.AccessToken != "fresh" {
+			t.Errorf("expected the rewritten token to be read, got %s", token.AccessToken)
+		}
+	}
+
+	stored, err := TokenFromFile(file)
+	if err != nil {
+		t.Fatalf("TokenFromFile failed: %s", err)
+	}
+	if !reflect.DeepEqual(stored, fresh) {
+		t.Errorf("expected the file to hold %#v, got %#v", fresh, stored)
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("failed to list temp dir: %s", err)
+	}
+	if len(files) != 1 {
+		t.Errorf("expected temporary files to be cleaned up, got %d files", len(files))
+	}
+}
+
+// testServiceAccountKey returns the JSON key of a service account whose
+// tokens are issued by tokenURL
+func testServiceAccountKey(t *testing.T, tokenURL string) []byte {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %s", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %s", err)
+	}
+	buf, err := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"client_email":   "bot@project.iam.gserviceaccount.com",
+		"private_key_id": "key",
+		"private_key":    string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"token_uri":      tokenURL,
+	})
+	if err != nil {
+		t.Fatalf("failed to marshal service account key: %s", err)
+	}
+	return buf
+}
+
+func TestServiceAccountProvider(t *testing.T) {
+	var claims map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The assertion is a JWT, whose claims are the middle part
+		parts := strings.Split(r.FormValue("assertion"), ".")
+		if len(parts) != 3 {
+			http.Error(w, "invalid assertion", http.StatusBadRequest)
+			return
+		}
+		buf, err := base64.RawURLEncoding.DecodeString(parts[1])
+		if err == nil {
+			err = json.Unmarshal(buf, &claims)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"service","token_type":"Bearer","expires_in":3600}`))
+	}))
+	defer srv.Close()
+
+	p, err := NewServiceAccountProvider(testServiceAccountKey(t, srv.URL), "alice@example.com")
+	if err != nil {
+		t.Fatalf("NewServiceAccountProvider failed: %s", err)
+	}
+	if expect := []string{calendar.CalendarReadonlyScope}; !reflect.DeepEqual(p.jwt.Scopes, expect) {
+		t.Errorf("expected scopes %q, got %q", expect, p.jwt.Scopes)
+	}
+	if p.jwt.Subject != "alice@example.com" {
+		t.Errorf("expected to impersonate alice@example.com, got %q", p.jwt.Subject)
+	}
+
+	ctx := context.Background()
+	config, err := p.OAuth2Config(ctx)
+	if err != nil {
+		t.Fatalf("OAuth2Config failed: %s", err)
+	}
+	if !reflect.DeepEqual(config.Scopes, p.jwt.Scopes) {
+		t.Errorf("expected config scopes %q, got %q", p.jwt.Scopes, config.Scopes)
+	}
+
+	for i := 0; i < 2; i++ {
+		token, err := p.OAuth2Token(ctx)
+		if err != nil {
+			t.Fatalf("OAuth2Token failed: %s", err)
+		}
+		if token.AccessToken != "service" || token.Expiry.Before(time.Now()) {
+			t.Errorf("unexpected token %#v", token)
+		}
+	}
+	expect := map[string]interface{}{
+		"iss":   "bot@project.iam.gserviceaccount.com",
+		"sub":   "alice@example.com",
+		"scope": calendar.CalendarReadonlyScope,
+	}
+	for name, value := range expect {
+		if claims[name] != value {
+			t.Errorf("expected claim %s to be %v, got %v", name, value, claims[name])
+		}
+	}
+
+	if _, err := NewServiceAccountProvider([]byte("{"), ""); err == nil {
+		t.Errorf("expected an invalid key to fail")
+	}
+}
+
+func TestConfigRedirectURL(t *testing.T) {
+	const multiRedirect = `{"installed":{"client_id":"id.apps.googleusercontent.com","client_secret":"secret","redirect_uris":["urn:ietf:wg:oauth:2.0:oob","http://localhost:8080","http://localhost:9090"],"auth_uri":"https://accounts.google.com/o/oauth2/auth","token_uri":"https://oauth2.googleapis.com/token"}}`
+
+	tests := []struct {
+		name     string
+		json     string
+		redirect RedirectURL
+		expect   string // Empty if an error is expected
+	}{
+		{"first", multiRedirect, RedirectURL{}, "urn:ietf:wg:oauth:2.0:oob"},
+		{"index", multiRedirect, RedirectURL{Index: 2}, "http://localhost:9090"},
+		{"value", multiRedirect, RedirectURL{Index: 2, URL: "http://localhost:8080"}, "http://localhost:8080"},
+		{"index out of range", multiRedirect, RedirectURL{Index: 3}, ""},
+		{"negative index", multiRedirect, RedirectURL{Index: -1}, ""},
+		{"unknown value", multiRedirect, RedirectURL{URL: "http://localhost:7070"}, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config, err := ConfigFromReaderWithRedirect(strings.NewReader(test.json), test.redirect)
+			if test.expect == "" {
+				if err == nil {
+					t.Errorf("expected an error, got %q", config.RedirectURL)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ConfigFromReaderWithRedirect failed: %s", err)
+			}
+			if config.RedirectURL != test.expect {
+				t.Errorf("expected %q, got %q", test.expect, config.RedirectURL)
+			}
+		})
+	}
+
+	dir, err := ioutil.TempDir("", "auth")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "config.json")
+	if err := ioutil.WriteFile(file, []byte(multiRedirect), 0600); err != nil {
+		t.Fatalf("failed to write config: %s", err)
+	}
+	p := NewFileConfigProviderWithRedirect(file, RedirectURL{URL: "http://localhost:9090"})
+	if config, err := p.OAuth2Config(context.Background()); err != nil || config.RedirectURL != "http://localhost:9090" {
+		t.Errorf("expected the provider to pick the redirect URI, got %v", err)
+	}
+}
+
+func TestConfigScopes(t *testing.T) {
+	const config = `{"installed":{"client_id":"id.apps.googleusercontent.com","client_secret":"secret","redirect_uris":["urn:ietf:wg:oauth:2.0:oob"],"auth_uri":"https://accounts.google.com/o/oauth2/auth","token_uri":"https://oauth2.googleapis.com/token"}}`
+
+	dir, err := ioutil.TempDir("", "auth")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "config.json")
+	if err := ioutil.WriteFile(file, []byte(config), 0600); err != nil {
+		t.Fatalf("failed to write config: %s", err)
+	}
+
+	tests := []struct {
+		name   string
+		load   func() (*oauth2.Config, error)
+		expect []string
+	}{
+		{"default", func() (*oauth2.Config, error) {
+			return ConfigFromFile(file)
+		}, []string{calendar.CalendarReadonlyScope, "https://www.googleapis.com/auth/userinfo.email"}},
+		{"read-write", func() (*oauth2.Config, error) {
+			return ConfigFromFileWithScopes(file, calendar.CalendarScope)
+		}, []string{calendar.CalendarScope}},
+		{"several", func() (*oauth2.Config, error) {
+			return ConfigFromFileWithScopes(file, calendar.CalendarEventsScope, calendar.CalendarReadonlyScope)
+		}, []string{calendar.CalendarEventsScope, calendar.CalendarReadonlyScope}},
+		{"reader", func() (*oauth2.Config, error) {
+			return ConfigFromReaderWithScopes(strings.NewReader(config), calendar.CalendarScope)
+		}, []string{calendar.CalendarScope}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config, err := test.load()
+			if err != nil {
+				t.Fatalf("failed to load config: %s", err)
+			}
+			if !reflect.DeepEqual(config.Scopes, test.expect) {
+				t.Errorf("expected scopes %q, got %q", test.expect, config.Scopes)
+			}
+		})
+	}
+
+	// Configs don't share the default scopes
+	first, err := ConfigFromFile(file)
+	if err != nil {
+		t.Fatalf("failed to load config: %s", err)
+	}
+	first.Scopes[0] = calendar.CalendarScope
+	if second, err := ConfigFromFile(file); err != nil || second.Scopes[0] != calendar.CalendarReadonlyScope {
+		t.Errorf("expected the default scopes to be left alone, got %q", second.Scopes)
+	}
+}
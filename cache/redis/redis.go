@@ -0,0 +1,67 @@
+// Package redis provides an EventCache backed by Redis, so that the bot
+// remembers which events it has notified about across restarts
+package redis
+
+import (
+	"time"
+
+	goredis "github.com/go-redis/redis"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// RedisCache is an EventCache storing entries in Redis. Expiry is left
+// to Redis
+type RedisCache struct {
+	client *goredis.Client
+}
+
+// missError is returned by Get for absent keys. calendarbot.IsCacheMiss
+// recognizes it by its CacheMiss method
+type missError struct{}
+
+func (_ missError) CacheMiss() bool {
+	return true
+}
+func (_ missError) Error() string {
+	return "cache miss"
+}
+
+func New(client *goredis.Client) *RedisCache {
+	return &RedisCache{
+		client: client,
+	}
+}
+
+// Add stores val under key for `expires`, unless key is already present
+func (c *RedisCache) Add(_ context.Context, key string, val []byte, expires time.Duration) error {
+	// SET key val EX seconds NX
+	added, err := c.client.SetNX(key, val, expires).Result()
+	if err != nil {
+		return errors.Wrap(err, "failed to set key in redis")
+	}
+	if !added {
+		return errors.New("entry exists")
+	}
+	return nil
+}
+
+// Get returns the value stored under key as a []byte
+func (c *RedisCache) Get(_ context.Context, key string) (interface{}, error) {
+	val, err := c.client.Get(key).Bytes()
+	if err == goredis.Nil {
+		return nil, missError{}
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get key from redis")
+	}
+	return val, nil
+}
+
+// Remove deletes key. Removing a key that doesn't exist is not an error
+func (c *RedisCache) Remove(_ context.Context, key string) error {
+	if err := c.client.Del(key).Err(); err != nil {
+		return errors.Wrap(err, "failed to delete key from redis")
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+//go:build redis
+// +build redis
+
+package redis
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	goredis "github.com/go-redis/redis"
+	"github.com/lestrrat/google-calendarbot"
+	"golang.org/x/net/context"
+)
+
+var _ calendarbot.EventCache = (*RedisCache)(nil)
+
+// newTestCache connects to the Redis at REDIS_ADDR (localhost:6379 by
+// default) and empties its database
+func newTestCache(t *testing.T) (*RedisCache, *goredis.Client) {
+	addr := os.Getenv("REDIS_ADDR")
+	if addr == "" {
+		addr = "localhost:6379"
+	}
+	client := goredis.NewClient(&goredis.Options{Addr: addr})
+	if err := client.Ping().Err(); err != nil {
+		t.Skipf("redis is not available at %s: %s", addr, err)
+	}
+	if err := client.FlushDB().Err(); err != nil {
+		t.Fatalf("failed to flush redis: %s", err)
+	}
+	return New(client), client
+}
+
+func TestRedisCache(t *testing.T) {
+	c, client := newTestCache(t)
+	defer client.Close()
+	ctx := context.Background()
+
+	if err := c.Add(ctx, "event1", []byte{0x1}, time.Minute); err != nil {
+		t.Fatalf("Add failed: %s", err)
+	}
+	if err := c.Add(ctx, "event1", []byte{0x2}, time.Minute); err == nil {
+		t.Errorf("Add of an existing key should fail")
+	}
+
+	v, err := c.Get(ctx, "event1")
+	if err != nil {
+		t.Fatalf("Get failed: %s", err)
+	}
+	if got, ok := v.([]byte); !ok || !bytes.Equal(got, []byte{0x1}) {
+		t.Errorf("Get returned %#v, want the first value added", v)
+	}
+
+	if _, err := c.Get(ctx, "event2"); !calendarbot.IsCacheMiss(err) {
+		t.Errorf("Get of a missing key returned %v, want a cache miss", err)
+	}
+}
+
+func TestRedisCacheRemove(t *testing.T) {
+	c, client := newTestCache(t)
+	defer client.Close()
+	ctx := context.Background()
+
+	if err := c.Add(ctx, "event1", []byte{0x1}, time.Minute); err != nil {
+		t.Fatalf("Add failed: %s", err)
+	}
+	if err := c.Remove(ctx, "event1"); err != nil {
+		t.Fatalf("Remove failed: %s", err)
+	}
+	if _, err := c.Get(ctx, "event1"); !calendarbot.IsCacheMiss(err) {
+		t.Errorf("Get of a removed key returned %v, want a cache miss", err)
+	}
+	if err := c.Remove(ctx, "event2"); err != nil {
+		t.Errorf("Remove of a missing key failed: %s", err)
+	}
+}
+
+func TestRedisCacheExpires(t *testing.T) {
+	c, client := newTestCache(t)
+	defer client.Close()
+	ctx := context.Background()
+
+	if err := c.Add(ctx, "event1", []byte{0x1}, time.Second); err != nil {
+		t.Fatalf("Add failed: %s", err)
+	}
+	time.Sleep(1500 * time.Millisecond)
+
+	if _, err := c.Get(ctx, "event1"); !calendarbot.IsCacheMiss(err) {
+		t.Errorf("Get of an expired key returned %v, want a cache miss", err)
+	}
+	if err := c.Add(ctx, "event1", []byte{0x1}, time.Minute); err != nil {
+		t.Errorf("Add after expiry failed: %s", err)
+	}
+}
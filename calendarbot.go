@@ -1,14 +1,20 @@
 package calendarbot
 
 import (
-	"bytes"
+	"container/list"
 	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/net/context"
 	"golang.org/x/oauth2"
 	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/googleapi"
 
 	"github.com/lestrrat/slack"
 	"github.com/pkg/errors"
@@ -22,14 +28,31 @@ type OAuth2TokenProvider interface {
 	OAuth2Token(context.Context) (*oauth2.Token, error)
 }
 
+// ErrTokenExpiredNoRefresh is returned when the OAuth2 token has expired
+// and cannot be refreshed. The only way out is to authorize again
+var ErrTokenExpiredNoRefresh = errors.New("oauth2 token has expired and has no refresh token, please re-authorize")
+
 type EventCache interface {
 	Add(context.Context, string, []byte, time.Duration) error
 	Get(context.Context, string) (interface{}, error)
+	// Remove deletes the key, if it exists, so that it can be added again
+	Remove(context.Context, string) error
+}
+
+// CacheInspector is implemented by an EventCache that can list its
+// contents, see Bot.DebugCache
+type CacheInspector interface {
+	// Entries returns the expiry of each unexpired key
+	Entries(context.Context) (map[string]time.Time, error)
 }
 
+// ErrCacheNotInspectable is returned by Bot.DebugCache when the cache
+// does not implement CacheInspector
+var ErrCacheNotInspectable = errors.New("cache does not support listing entries")
+
 type cacheMissError struct{}
 
-func (_ cacheMissError) IsCacheMiss() bool {
+func (_ cacheMissError) CacheMiss() bool {
 	return true
 }
 func (_ cacheMissError) Error() string {
@@ -41,31 +64,114 @@ type cacheEntry struct {
 	Expires time.Time
 }
 
+// memoryCache is an in-memory EventCache. When maxEntries is set, the
+// least recently used entry is evicted to make room for new ones
 type memoryCache struct {
-	data  map[string]cacheEntry
-	mutex sync.Mutex
+	closeOnce  sync.Once
+	data       map[string]*list.Element
+	done       chan struct{} // Closed to stop the sweeper
+	lru        *list.List    // Front is the most recently used
+	maxEntries int
+	mutex      sync.Mutex
+	sweeper    sync.WaitGroup // Done once the sweeper has stopped
+}
+
+type memoryCacheItem struct {
+	key   string
+	entry cacheEntry
+}
+
+// DefaultSweepInterval is how often NewMemoryCache removes expired
+// entries that are not looked up again
+const DefaultSweepInterval = 5 * time.Minute
+
+// NewMemoryCache creates an in-memory EventCache holding at most
+// maxEntries entries. If maxEntries is 0, the cache is unbounded.
+// Expired entries are removed every DefaultSweepInterval, until the
+// cache is closed through its io.Closer
+func NewMemoryCache(maxEntries int) EventCache {
+	return newMemoryCache(maxEntries, DefaultSweepInterval)
+}
+
+// NewMemoryCacheWithSweep is like NewMemoryCache, removing expired
+// entries every sweepInterval instead. If sweepInterval is 0, expired
+// entries are only removed when they are looked up
+func NewMemoryCacheWithSweep(maxEntries int, sweepInterval time.Duration) EventCache {
+	return newMemoryCache(maxEntries, sweepInterval)
 }
 
-func newMemoryCache() *memoryCache {
-	return &memoryCache{
-		data: make(map[string]cacheEntry),
+func newMemoryCache(maxEntries int, sweepInterval time.Duration) *memoryCache {
+	c := &memoryCache{
+		data:       make(map[string]*list.Element),
+		done:       make(chan struct{}),
+		lru:        list.New(),
+		maxEntries: maxEntries,
+	}
+	if sweepInterval > 0 {
+		c.sweeper.Add(1)
+		go c.sweep(sweepInterval)
+	}
+	return c
+}
+
+// sweep removes expired entries every interval until the cache is
+// closed. Otherwise entries for events that are never looked up again
+// would stay around forever
+func (c *memoryCache) sweep(interval time.Duration) {
+	defer c.sweeper.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case now := <-ticker.C:
+			c.removeExpired(now)
+		}
+	}
+}
+
+func (c *memoryCache) removeExpired(now time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, elem := range c.data {
+		if elem.Value.(*memoryCacheItem).entry.Expires.Before(now) {
+			c.remove(elem)
+		}
 	}
 }
 
+// Close stops removing expired entries in the background. The cache
+// can still be used afterwards
+func (c *memoryCache) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
+	c.sweeper.Wait()
+	return nil
+}
+
 func (c *memoryCache) Add(_ context.Context, key string, val []byte, expires time.Duration) error {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
-	e, ok := c.data[key]
-	if ok {
-		if e.Expires.Before(time.Now()) {
-			delete(c.data, key)
+	if elem, ok := c.data[key]; ok {
+		// An expired entry is replaced as if it wasn't there
+		if !elem.Value.(*memoryCacheItem).entry.Expires.Before(time.Now()) {
+			return errors.New("entry exists")
 		}
-		return errors.New("entry exists")
+		c.remove(elem)
 	}
-	c.data[key] = cacheEntry{
-		Value:   val,
-		Expires: time.Now().Add(expires),
+	c.data[key] = c.lru.PushFront(&memoryCacheItem{
+		key: key,
+		entry: cacheEntry{
+			Value:   val,
+			Expires: time.Now().Add(expires),
+		},
+	})
+	if c.maxEntries > 0 && c.lru.Len() > c.maxEntries {
+		c.remove(c.lru.Back())
 	}
 	return nil
 }
@@ -74,199 +180,806 @@ func (c *memoryCache) Get(_ context.Context, key string) (interface{}, error) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
-	e, ok := c.data[key]
+	elem, ok := c.data[key]
 	if !ok {
 		return nil, cacheMissError{}
 	}
 
+	e := elem.Value.(*memoryCacheItem).entry
 	if e.Expires.Before(time.Now()) {
-		delete(c.data, key)
+		c.remove(elem)
 		return nil, cacheMissError{}
 	}
+	c.lru.MoveToFront(elem)
 	return e, nil
 }
 
+func (c *memoryCache) Remove(_ context.Context, key string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if elem, ok := c.data[key]; ok {
+		c.remove(elem)
+	}
+	return nil
+}
+
+func (c *memoryCache) Entries(_ context.Context) (map[string]time.Time, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+	entries := make(map[string]time.Time, len(c.data))
+	for key, elem := range c.data {
+		if e := elem.Value.(*memoryCacheItem).entry; !e.Expires.Before(now) {
+			entries[key] = e.Expires
+		}
+	}
+	return entries, nil
+}
+
+// remove deletes elem from the cache. The caller must hold the lock
+func (c *memoryCache) remove(elem *list.Element) {
+	c.lru.Remove(elem)
+	delete(c.data, elem.Value.(*memoryCacheItem).key)
+}
+
 type Bot struct {
-	Cache         EventCache
-	CalendarName  string // "primary" by default
-	Email         string // Identity
-	OAuth2Config  OAuth2ConfigProvider
-	OAuth2Token   OAuth2TokenProvider
-	SlackChannel  string // Channel name to post
-	SlackThumbURL string // Thumbnail URL to use when posting to Slack
-	SlackToken    string // Access token for slack
-	SlackUsername string // Username of the bot
+	AgendaDedupWindow      time.Duration // Skip agendas identical to one posted this recently (0 disables)
+	AgendaHeader           string        // text/template for the agenda title, see AgendaHeaderData
+	AgendaReaction         string        // Reaction the bot adds to its agendas once posted, e.g. "white_check_mark" (none if empty)
+	AttachmentColor        string        // Color of the bar next to agendas and reminders without a color of their own ("good" by default)
+	AnnounceAllDay         bool          // Send a "Today: <title>" reminder for all-day events once a day
+	BatchWindow            time.Duration // Reminders for events starting within this of each other are sent together (0 sends one per event). Slack posts them one by one with RouteToOrganizer or ThreadReminders
+	Cache                  EventCache
+	CalendarName           string                                           // "primary" by default
+	CalendarRetries        int                                              // Times listing events is tried again after a 429 or 5xx response (0 disables)
+	CalendarRetryBase      time.Duration                                    // Wait before the first retry of listing events, doubling with each further one, with jitter (1s by default)
+	CalendarServiceFactory func(context.Context) (*calendar.Service, error) // Creates the calendar service instead of OAuth2Config and OAuth2Token when set
+	Calendars              []string                                         // Calendars merged into the agenda, instead of CalendarName
+	CategoryExtractor      func(*calendar.Event) string                     // Groups the agenda by category when set
+	ChangeNotifyInterval   time.Duration                                    // Minimum time between change notifications for the same event
+	ChannelCacheTTL        time.Duration                                    // How long channel IDs looked up by name are reused (0 keeps them until ClearChannelCache)
+	CollapseRecurring      bool                                             // List recurring events in the agenda once rather than each instance, see OrderBy
+	ColorEmoji             map[string]string                                // Emoji prepended to agenda lines, keyed by event ColorId
+	DedupWindow            time.Duration                                    // How long an event is not reminded about again (15m by default)
+	DescriptionAsCodeBlock bool                                             // Render event descriptions in reminders as code blocks
+	DisplayLocation        *time.Location                                   // Time zone times are shown in (time.Local by default, so events' own time zones are only used once this is set to nil)
+	Email                  string                                           // Identity
+	EndAsDuration          bool                                             // Show how long events last instead of when they end, e.g. "09:00 (1h)" rather than "09:00-10:00"
+	EventColors            map[string]string                                // Reminder colors by event ColorId, e.g. "#0b8043", instead of the ones Google shows
+	EventFields            string                                           // Partial response mask for listing events (DefaultEventFields by default, "" requests everything)
+	ExcludeOngoing         bool                                             // Leave events that started before the agenda's window but are still under way out of it
+	ExcludePatterns        []*regexp.Regexp                                 // Events whose title matches any of these are not notified about, even if IncludePatterns match
+	FallbackOnArchived     bool                                             // Also post to FallbackSlackChannel when SlackChannel has been archived
+	FallbackSlackChannel   string                                           // Channel to post to when SlackChannel can't be found
+	FetchOmittedAttendees  bool                                             // Get the whole event when Google leaves out attendees, so that filters see all of them
+	Filters                []EventFilter                                    // Only events that all filters keep are notified about, except for room conflicts
+	FreeBusyCalendars      []string                                         // Calendars whose busy times are looked up with the FreeBusy API, listing the ones during an event in its reminder
+	ICSURL                 string                                           // Where ICSHandler is served; when set, agenda lines link to each event as an iCalendar file
+	IgnoreCacheErrors      bool                                             // Carry on without deduplication when the cache keeps failing
+	IncludePatterns        []*regexp.Regexp                                 // When set, only events whose title matches one of these are notified about
+	InstanceLinks          InstanceLinkMode                                 // How links to instances of recurring events are made distinct (InstanceLinkEID by default)
+	Locale                 Locale                                           // Language of reminder text (English by default)
+	LogRedactor            func(string) string                              // Applied to event content before logging (RedactLength by default)
+	Logger                 Logger                                           // Receives diagnostic messages, discarded by default
+	MarkSoleAttendee       bool                                             // Mark reminders for events you are the only required attendee of besides the organizer, such as 1:1s, with "you're expected"
+	MaxAttendeeNames       int                                              // Attendees listed in reminders before "+N more" (5 by default, 0 lists all)
+	MaxAttendees           int64                                            // Attendees returned by Google per event (0 returns all)
+	MaxFields              int                                              // Fields in reminders before the rest are left out (0 for no limit)
+	MaxFieldsSize          int                                              // Characters of field titles and values in reminders before they are cut (0 for no limit)
+	MaxLookahead           time.Duration                                    // Longest window notifications look ahead, longer ones are cut short (0 for no limit)
+	MinEventsToPost        int                                              // Agendas with fewer events are not posted, see IsSuppressed
+	MinFreeTime            time.Duration                                    // Smallest gap shown as free time (1h by default, 0 means any gap)
+	MinPostInterval        time.Duration                                    // Posts sooner than this after the previous one are dropped, see IsSuppressed (0 disables)
+	ModifyParams           func(*slack.PostMessageParameters)               // Called with every message right before it is posted
+	MuteEventIDs           []string                                         // Events never notified about, by ID, or by recurringEventId for every instance of a recurring event
+	Notifier               Notifier                                         // Delivers agendas and reminders, posting to Slack if not set
+	Notifiers              map[string]Notifier                              // Named notifiers to send to instead of Notifier, keeping track of reminders sent to each so that only failed ones are retried
+	OAuth2Config           OAuth2ConfigProvider
+	OAuth2Token            OAuth2TokenProvider
+	OrderBy                string                                    // Order of agenda events in the API response: "startTime" (the default unless CollapseRecurring is set) or "updated"
+	PageSize               int                                       // Events requested from Google at a time (0 uses Google's default)
+	PresenceCandidates     []string                                  // Slack user IDs, the first active one is mentioned in reminders
+	ProximityColors        bool                                      // Color reminders by how soon events start instead of by event, see ProximitySoon
+	ProximitySoon          time.Duration                             // With ProximityColors, reminders for events starting sooner than this are yellow (15m by default)
+	ProximityUrgent        time.Duration                             // With ProximityColors, reminders for events starting sooner than this are red (5m by default)
+	ReminderLead           time.Duration                             // How early to remind about events (the calendar's default reminder if not set)
+	RoundLeadTo            time.Duration                             // Round the time until an event starts in reminders, e.g. to 5m (0 disables)
+	RouteToOrganizer       bool                                      // Send reminders to the organizer as a direct message, falling back to SlackChannel
+	ShowEventID            bool                                      // Include event IDs in notifications
+	ShowFreeTime           bool                                      // Show free time between events in the agenda
+	ShowHiddenInvitations  bool                                      // Include invitations that are hidden until responded to, marked as pending response
+	ShowWorkingLocation    bool                                      // List working-location events in agendas, e.g. "Working from home", instead of leaving them out
+	SkipAllDay             bool                                      // Leave all-day events out of the agenda
+	SkipCacheTTL           time.Duration                             // How long an event that already started is skipped without looking at it again (15m by default, 0 looks every time)
+	SkipFocusTime          bool                                      // Leave focus-time events out of notifications, instead of marking them as such
+	SkipOutOfOffice        bool                                      // Leave out-of-office events out of notifications, instead of marking them as such
+	SlackChannel           string                                    // Name or ID of the channel to post to
+	SlackClientFactory     func(context.Context, string) SlackPoster // Creates the Slack client from ctx and SlackToken (NewSlackClient if nil)
+	SlackIconEmoji         string                                    // Emoji used as the bot's icon, e.g. ":calendar:"
+	SlackIdentities        map[string]SlackIdentity                  // Per calendar ID overrides of SlackUsername and SlackIconEmoji
+	SlackMetadata          bool                                      // Attach event IDs and start times to messages as Slack metadata
+	SlackRetries           int                                       // Times a Slack call is tried again after a 429 or 5xx response (0 disables)
+	SlackRetryBase         time.Duration                             // Wait before the first Slack retry unless Slack sends Retry-After, doubling with each further one (1s by default)
+	SlackThumbURL          string                                    // Thumbnail URL to use when posting to Slack
+	SlackToken             string                                    // Access token for slack
+	SlackTransport         func(http.RoundTripper) http.RoundTripper // Wraps the transport used to talk to Slack
+	SlackUsername          string                                    // Username of the bot
+	StartTolerance         time.Duration                             // Events that started this recently are still reminded about (10s by default)
+	ThreadReminders        bool                                      // Post further reminders for an event as replies to the first one
+
+	channels  sync.Map          // Channel IDs by name, see cachedChannelID
+	lastPost  time.Time         // See MinPostInterval, guarded by postMu
+	palette   map[string]string // Google's event colors, see eventPalette
+	paletteMu sync.Mutex        // Guards palette
+	paused    int32             // Set by Pause, accessed atomically
+	postMu    sync.Mutex        // Guards lastPost
 }
 
 func New() *Bot {
 	return &Bot{
-		Cache:        newMemoryCache(),
-		CalendarName: `primary`,
+		AgendaHeader:      DefaultAgendaHeader,
+		AttachmentColor:   "good",
+		Cache:             newMemoryCache(0, DefaultSweepInterval),
+		CalendarName:      primaryCalendar,
+		CalendarRetryBase: time.Second,
+		DedupWindow:       15 * time.Minute,
+		DisplayLocation:   time.Local,
+		EventFields:       DefaultEventFields,
+		LogRedactor:       RedactLength,
+		Logger:            nullLogger{},
+		MaxAttendeeNames:  5,
+		MinFreeTime:       time.Hour,
+		ProximitySoon:     15 * time.Minute,
+		ProximityUrgent:   5 * time.Minute,
+		SkipCacheTTL:      15 * time.Minute,
+		SlackRetryBase:    time.Second,
+		StartTolerance:    10 * time.Second,
 	}
 }
 
+// cacheError is implemented by the errors an EventCache returns from
+// Get for keys that are not in the cache
 type cacheError interface {
 	CacheMiss() bool
 }
 
+// IsCacheMiss reports whether err means that a key is not in the cache,
+// as opposed to the cache failing
 func IsCacheMiss(err error) bool {
-	if cacheErr, ok := err.(cacheError); ok {
+	if cacheErr, ok := errors.Cause(err).(cacheError); ok {
 		return cacheErr.CacheMiss()
 	}
 	return false
 }
 
+type suppressedError struct {
+	reason string
+}
+
+func (e suppressedError) Suppressed() bool {
+	return true
+}
+func (e suppressedError) Error() string {
+	return "notification suppressed: " + e.reason
+}
+
+// IsSuppressed reports whether err means that a notification was
+// deliberately not posted, e.g. because of Bot.MinEventsToPost
+func IsSuppressed(err error) bool {
+	if s, ok := errors.Cause(err).(interface {
+		Suppressed() bool
+	}); ok {
+		return s.Suppressed()
+	}
+	return false
+}
+
+// DebugCache returns the keys in the cache along with their expiry, for
+// debugging deduplication
+func (b *Bot) DebugCache(ctx context.Context) (map[string]time.Time, error) {
+	inspector, ok := b.Cache.(CacheInspector)
+	if !ok {
+		return nil, ErrCacheNotInspectable
+	}
+	entries, err := inspector.Entries(ctx)
+	return entries, errors.Wrap(err, "failed to list cache entries")
+}
+
+// errPaused is returned by the Notify methods while the bot is paused
+var errPaused = suppressedError{reason: "bot is paused"}
+
+// Pause stops the bot from fetching events and posting until Resume is
+// called. Meanwhile, the Notify methods return an error for which
+// IsSuppressed is true
+func (b *Bot) Pause() {
+	atomic.StoreInt32(&b.paused, 1)
+}
+
+// Resume undoes Pause
+func (b *Bot) Resume() {
+	atomic.StoreInt32(&b.paused, 0)
+}
+
+// Paused reports whether the bot has been paused
+func (b *Bot) Paused() bool {
+	return atomic.LoadInt32(&b.paused) == 1
+}
+
+// reservePost records a post at `now`, unless MinPostInterval has not
+// passed since the previous one. Then it returns an error for which
+// IsSuppressed is true and the post should be dropped
+func (b *Bot) reservePost(now time.Time) error {
+	if b.MinPostInterval <= 0 {
+		return nil
+	}
+
+	b.postMu.Lock()
+	defer b.postMu.Unlock()
+	if !b.lastPost.IsZero() && now.Sub(b.lastPost) < b.MinPostInterval {
+		return suppressedError{reason: fmt.Sprintf("last post was less than %s ago", b.MinPostInterval)}
+	}
+	b.lastPost = now
+	return nil
+}
+
+// cacheGetAttempts is how many times a failing cache Get is tried
+const cacheGetAttempts = 3
+
+// cacheRetryBackoff is the wait before the first retry, doubling with
+// each further one
+var cacheRetryBackoff = 100 * time.Millisecond
+
+// seen reports whether key is present in the cache. Failing Gets are
+// retried, and if the cache keeps failing, the key is reported as
+// missing if IgnoreCacheErrors is set
+func (b *Bot) seen(ctx context.Context, key string) (bool, error) {
+	var err error
+	backoff := cacheRetryBackoff
+	for attempt := 1; ; attempt++ {
+		_, err = b.Cache.Get(ctx, key)
+		switch {
+		case err == nil:
+			return true, nil
+		case IsCacheMiss(err):
+			return false, nil
+		}
+
+		if attempt == cacheGetAttempts {
+			break
+		}
+		b.Logger.Debugf(ctx, "cache get failed, retrying in %s: %s", backoff, err)
+		select {
+		case <-ctx.Done():
+			return false, errors.Wrap(ctx.Err(), "failed to communicate with cache")
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+
+	if b.IgnoreCacheErrors {
+		b.Logger.Warningf(ctx, "cache is failing, proceeding without deduplication: %s", err)
+		return false, nil
+	}
+	return false, errors.Wrap(err, "failed to communicate with cache")
+}
+
+// NotifyIndividualEvents sends a reminder for each event in CalendarName
+// starting between `t` and `t+delta`. If delta is 0, ReminderLead is used, or
+// the calendar's default reminder if that is not set either
 func (b *Bot) NotifyIndividualEvents(ctx context.Context, t time.Time, delta time.Duration) error {
+	if b.Paused() {
+		return errPaused
+	}
+
 	s, err := b.CalendarService(ctx)
 	if err != nil {
 		return errors.Wrap(err, "failed to create calendar service")
 	}
 
+	id := calendarID(b.CalendarName)
+	if delta == 0 {
+		if delta, err = b.reminderLead(s, id); err != nil {
+			return err
+		}
+	}
+	delta = b.lookahead(ctx, delta)
+
 	// Collect events that are due in the given time frame
 	start := t.Format(time.RFC3339)
 	end := t.Add(delta).Format(time.RFC3339)
 
-	events, err := s.Events.
-		List(`primary`).
+	events, err := b.listEvents(ctx, b.eventsList(s, id).
 		TimeMin(start).
 		TimeMax(end).
-		SingleEvents(true).
-		Do()
+		SingleEvents(true))
 	if err != nil {
 		return errors.Wrap(err, "failed to list events")
 	}
+	events = b.fetchOmittedAttendees(ctx, s, id, events)
 	now := time.Now().UTC()
-	for _, event := range events.Items {
-		_, err := b.Cache.Get(ctx, event.Id)
-		switch {
-		case err == nil:
-			// Found, go to next item
-			// log.Debugf(ctx, "event %s has been processed in the last 15 minutes, skipping", event.Id)
+	var due []reminder
+	for _, event := range b.filterEvents(events) {
+		processed, err := b.seen(ctx, event.Id)
+		if err != nil {
+			return err
+		}
+		if processed {
+			b.debugEvent(ctx, event, "event has been processed recently, skipping")
 			continue
-		case IsCacheMiss(err):
-			// Not found, need to process
-		case err != nil:
-			return errors.Wrap(err, "failed to communicate with cache")
 		}
 
-		t, err := time.Parse(time.RFC3339, event.Start.DateTime)
+		// One broken event shouldn't hold up the reminders for the others
+		t, allDay, err := eventStart(event)
 		if err != nil {
-			return errors.Wrap(err, "failed to parse event start time")
+			b.Logger.Warningf(ctx, "skipping event %s: %s", event.Id, err)
+			continue
+		}
+
+		// Where someone works from is not something to get ready for
+		if isWorkingLocation(event) {
+			b.debugEvent(ctx, event, "working-location event, skipping")
+			continue
+		}
+
+		// There is no start time to remind about
+		if allDay {
+			if err := b.announceAllDay(ctx, event, time.Now()); err != nil {
+				return err
+			}
+			continue
 		}
 		diff := t.Sub(now)
-		if diff < 0 { // event %s has negative offset. skipping
-			b.Cache.Add(ctx, event.Id, []byte{0x1}, 15*time.Minute)
+		if diff < -b.StartTolerance {
+			b.debugEvent(ctx, event, "event has negative offset, skipping")
+			// A clock that is off for a moment could make this wrong, so
+			// it is remembered apart from reminders
+			if b.SkipCacheTTL > 0 {
+				b.Cache.Add(ctx, event.Id, []byte{0x1}, b.SkipCacheTTL)
+			}
 			continue
 		}
-		fields := []slack.AttachmentField{
-			slack.AttachmentField{
-				Title: "Start Time",
-				Value: t.Format("15:04"),
-			},
+		due = append(due, reminder{event: event, start: t})
+	}
+	conflicts := b.reminderConflicts(ctx, s, id, due)
+
+	for _, batch := range batchReminders(due, b.BatchWindow) {
+		mention := b.mention(ctx)
+
+		// Keep going, so that one failing notifier doesn't affect the others
+		var failed error
+		for _, nn := range b.namedNotifiers() {
+			pending, err := b.undelivered(ctx, nn.name, batch)
+			if err != nil {
+				return err
+			}
+			if len(pending) == 0 {
+				continue
+			}
+			n := b.reminderNotification(id, pending, mention, now)
+			n.Conflicts = conflicts
+			if err := b.sendReminder(ctx, nn, pending, n); err != nil && failed == nil {
+				failed = err
+			}
+		}
+		if failed != nil {
+			return errors.Wrap(failed, "failed to send reminder")
+		}
+
+		// Remember these jobs for DedupWindow so we don't do them again
+		for _, r := range batch {
+			b.Cache.Add(ctx, r.event.Id, []byte{0x1}, b.DedupWindow)
+		}
+	}
+	return nil
+}
+
+// reminderNotification creates the notification for a batch of
+// reminders from calendar `id`
+func (b *Bot) reminderNotification(id string, batch []reminder, mention string, now time.Time) *Notification {
+	n := &Notification{
+		Kind:     ReminderNotification,
+		Calendar: id,
+		Start:    batch[0].start,
+	}
+	starts := make(map[string]time.Time, len(batch))
+	for _, r := range batch {
+		n.Events = append(n.Events, r.event)
+		starts[r.event.Id] = r.start
+	}
+	n.reminderText = func(events []*calendar.Event) string {
+		if len(events) == 1 {
+			return mention + b.leadText(starts[events[0].Id].Sub(now))
+		}
+		return mention + b.translate(msgEventsStartingSoon, len(events))
+	}
+	n.Text = n.reminderText(n.Events)
+	return n
+}
+
+// deliveredKey is the cache key telling that the reminder for event was
+// sent to the notifier `name`, see Notifiers
+func deliveredKey(name string, event *calendar.Event) string {
+	return "delivered:" + name + ":" + event.Id
+}
+
+// undelivered returns the reminders in batch that haven't been sent to
+// the notifier `name` yet. Only Notifiers are tracked one by one,
+// otherwise that is the whole batch
+func (b *Bot) undelivered(ctx context.Context, name string, batch []reminder) ([]reminder, error) {
+	if name == "" {
+		return batch, nil
+	}
+	var pending []reminder
+	for _, r := range batch {
+		delivered, err := b.seen(ctx, deliveredKey(name, r.event))
+		if err != nil {
+			return nil, err
+		}
+		if delivered {
+			b.debugEvent(ctx, r.event, "reminder already sent to "+name+", skipping")
+			continue
+		}
+		pending = append(pending, r)
+	}
+	return pending, nil
+}
+
+// sendReminder sends n, the notification for the reminders in batch,
+// to nn
+func (b *Bot) sendReminder(ctx context.Context, nn namedNotifier, batch []reminder, n *Notification) error {
+	// A post that timed out may have gone through, so the attempt is
+	// recorded up front and only forgotten if it surely failed. Missing
+	// a reminder is better than posting it twice
+	key := reminderKey(nn.name, batch)
+	sending, err := b.seen(ctx, "sending:"+key)
+	if err != nil {
+		return err
+	}
+	if sending {
+		b.Logger.Debugf(ctx, "reminder %s may have been sent already, skipping", key)
+	} else {
+		b.Cache.Add(ctx, "sending:"+key, []byte{0x1}, b.DedupWindow)
+		err := nn.notifier.Notify(withIdempotencyKey(ctx, key), n)
+		if err == nil || !isTimeout(err) {
+			b.Cache.Remove(ctx, "sending:"+key)
 		}
-		if txt := event.Description; txt != "" {
-			fields = append(fields, slack.AttachmentField{
-				Title: "Description",
-				Value: txt,
-			})
+		if err != nil {
+			if nn.name != "" {
+				return errors.Wrapf(err, "failed to notify %s", nn.name)
+			}
+			return err
 		}
+	}
 
-		params := slack.NewPostMessageParameters()
-		params.Username = b.SlackUsername
-		params.Attachments = []slack.Attachment{
-			slack.Attachment{
-				Fallback:  event.Summary,
-				Fields:    fields,
-				ThumbURL:  b.SlackThumbURL,
-				Title:     event.Summary,
-				TitleLink: event.HtmlLink,
-			},
+	if nn.name != "" {
+		for _, r := range batch {
+			b.Cache.Add(ctx, deliveredKey(nn.name, r.event), []byte{0x1}, b.DedupWindow)
 		}
-		txt := fmt.Sprintf("This event starts in %d minutes", int(diff.Minutes()))
-		if err := postSlack(ctx, b.SlackToken, b.SlackChannel, txt, &params); err != nil {
-			return errors.Wrap(err, "failed to post message to slack")
+	}
+	return nil
+}
+
+// reminder is an event that is due to be reminded about
+type reminder struct {
+	event *calendar.Event
+	start time.Time
+}
+
+// batchReminders groups reminders by start time. Each batch holds the
+// reminders starting within `window` of the first one in it. With a
+// window of 0, each reminder is a batch of its own
+func batchReminders(reminders []reminder, window time.Duration) [][]reminder {
+	sort.SliceStable(reminders, func(i, j int) bool {
+		return reminders[i].start.Before(reminders[j].start)
+	})
+
+	var batches [][]reminder
+	for _, r := range reminders {
+		if last := len(batches) - 1; window > 0 && last >= 0 && r.start.Sub(batches[last][0].start) <= window {
+			batches[last] = append(batches[last], r)
+			continue
 		}
+		batches = append(batches, []reminder{r})
+	}
+	return batches
+}
 
-		// Remember this job for the next 15 minutes so we don't do it again
-		b.Cache.Add(ctx, event.Id, []byte{0x1}, 15*time.Minute)
+// announceAllDay sends a "Today: <title>" notification for an all-day
+// event on each day it covers, if AnnounceAllDay is set. Days are
+// based on the local time zone
+func (b *Bot) announceAllDay(ctx context.Context, event *calendar.Event, now time.Time) error {
+	if !b.AnnounceAllDay {
+		b.debugEvent(ctx, event, "all-day event, skipping")
+		return nil
 	}
+	start, end, _, err := eventTimes(event)
+	if err != nil {
+		b.Logger.Warningf(ctx, "skipping event %s: %s", event.Id, err)
+		return nil
+	}
+	today := now.Format(allDayLayout)
+	if today < start.Format(allDayLayout) || today >= end.Format(allDayLayout) {
+		b.debugEvent(ctx, event, "all-day event, skipping")
+		return nil
+	}
+
+	key := "allday:" + event.Id + "@" + today
+	announced, err := b.seen(ctx, key)
+	if err != nil {
+		return err
+	}
+	if announced {
+		b.debugEvent(ctx, event, "all-day event has been announced today, skipping")
+		return nil
+	}
+
+	n := &Notification{
+		Kind:     ReminderNotification,
+		Text:     "Today: " + event.Summary,
+		Calendar: calendarID(b.CalendarName),
+		Events:   []*calendar.Event{event},
+	}
+	if err := b.notifier().Notify(ctx, n); err != nil {
+		return errors.Wrap(err, "failed to send reminder")
+	}
+	b.Cache.Add(ctx, key, []byte{0x1}, 24*time.Hour)
 	return nil
 }
 
-// NotifyUpcomingEvents sends one message to slack
+// lookahead returns delta, or MaxLookahead if delta is longer than
+// that. Listing a window that long by mistake is slow and floods the
+// channel
+func (b *Bot) lookahead(ctx context.Context, delta time.Duration) time.Duration {
+	if b.MaxLookahead <= 0 || delta <= b.MaxLookahead {
+		return delta
+	}
+	b.Logger.Warningf(ctx, "looking %s ahead is more than MaxLookahead, looking %s ahead instead", delta, b.MaxLookahead)
+	return b.MaxLookahead
+}
+
+// defaultReminderLead is used when neither ReminderLead nor the
+// calendar's default reminders say how early to remind
+const defaultReminderLead = 15 * time.Minute
+
+// reminderLead returns ReminderLead, falling back to the default
+// reminder of the calendar, preferring popup reminders
+func (b *Bot) reminderLead(s *calendar.Service, id string) (time.Duration, error) {
+	if b.ReminderLead > 0 {
+		return b.ReminderLead, nil
+	}
+
+	entry, err := s.CalendarList.Get(id).Do()
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to get calendar list entry")
+	}
+
+	var lead time.Duration
+	for i, r := range entry.DefaultReminders {
+		if i == 0 || r.Method == "popup" {
+			lead = time.Duration(r.Minutes) * time.Minute
+		}
+		if r.Method == "popup" {
+			break
+		}
+	}
+	if lead <= 0 {
+		return defaultReminderLead, nil
+	}
+	return lead, nil
+}
+
+// NotifyUpcomingEvents sends one message to slack (or Notifier)
 // containing all of the events that are scheduled to happen
-// in the next `delta` amount of time, starting at `t`
+// in the next `delta` amount of time, starting at `t`. If the
+// agenda is not posted because of MinEventsToPost, the returned
+// error satisfies IsSuppressed
 func (b *Bot) NotifyUpcomingEvents(ctx context.Context, t time.Time, delta time.Duration) error {
+	if b.Paused() {
+		return errPaused
+	}
+
+	n, err := b.upcomingAgenda(ctx, t, delta)
+	if err != nil {
+		return err
+	}
+
+	// Nothing to do
+	if n == nil {
+		return nil
+	}
+
+	// Overlapping runs may render the exact same agenda
+	var key string
+	if b.AgendaDedupWindow > 0 {
+		params, err := b.slackAgenda(n)
+		if err != nil {
+			return err
+		}
+		key = "agenda:" + agendaHash(b.SlackChannel, params.Attachments)
+		posted, err := b.seen(ctx, key)
+		if err != nil {
+			return err
+		}
+		if posted {
+			b.Logger.Debugf(ctx, "identical agenda has been posted in the last %s, skipping", b.AgendaDedupWindow)
+			return nil
+		}
+	}
+
+	if err := b.notifier().Notify(ctx, n); err != nil {
+		return errors.Wrap(err, "failed to send agenda")
+	}
+
+	if key != "" {
+		b.Cache.Add(ctx, key, []byte{0x1}, b.AgendaDedupWindow)
+	}
+	return nil
+}
+
+// upcomingMessage creates the message posted by NotifyUpcomingEvents,
+// see upcomingAgenda
+func (b *Bot) upcomingMessage(ctx context.Context, t time.Time, delta time.Duration) (*slack.PostMessageParameters, error) {
+	n, err := b.upcomingAgenda(ctx, t, delta)
+	if err != nil || n == nil {
+		return nil, err
+	}
+	return b.slackAgenda(n)
+}
+
+const (
+	orderByStartTime = "startTime"
+	orderByUpdated   = "updated"
+)
+
+// agendaOrder returns the OrderBy to list agenda events with. Google
+// only orders by start time when recurring events are expanded, so
+// with CollapseRecurring the default falls back to "updated". Asking
+// for "startTime" explicitly in that case is an error
+func (b *Bot) agendaOrder(ctx context.Context) (string, error) {
+	switch b.OrderBy {
+	case "":
+		if b.CollapseRecurring {
+			b.Logger.Debugf(ctx, "recurring events are collapsed, ordering by update time")
+			return orderByUpdated, nil
+		}
+		return orderByStartTime, nil
+	case orderByStartTime:
+		if b.CollapseRecurring {
+			return "", errors.New(`OrderBy "startTime" requires expanding recurring events, unset CollapseRecurring or use "updated"`)
+		}
+		return orderByStartTime, nil
+	case orderByUpdated:
+		return orderByUpdated, nil
+	default:
+		return "", errors.Errorf(`unsupported OrderBy %q, must be "startTime" or "updated"`, b.OrderBy)
+	}
+}
+
+// upcomingAgenda creates the notification sent by NotifyUpcomingEvents.
+// It returns nil if there is nothing to send, and an error for which
+// IsSuppressed is true if there are fewer than MinEventsToPost events
+func (b *Bot) upcomingAgenda(ctx context.Context, t time.Time, delta time.Duration) (*Notification, error) {
 	s, err := b.CalendarService(ctx)
 	if err != nil {
-		return errors.Wrap(err, "failed to create calendar service")
+		return nil, errors.Wrap(err, "failed to create calendar service")
 	}
+	delta = b.lookahead(ctx, delta)
 
 	// Collect events that are due in the given time frame
 	start := t.Format(time.RFC3339)
 	end := t.Add(delta).Format(time.RFC3339)
 
-	events, err := s.Events.
-		List(b.CalendarName).
-		TimeMin(start).
-		TimeMax(end).
-		SingleEvents(true).
-		OrderBy("startTime").
-		Do()
+	orderBy, err := b.agendaOrder(ctx)
 	if err != nil {
-		return errors.Wrap(err, "failed to list events")
-	}
-
-	// Nothing to do
-	if len(events.Items) == 0 {
-		return nil
+		return nil, err
 	}
 
-	// Create a message containing all events for the day
-	buf := bytes.Buffer{}
-	fields := make([]slack.AttachmentField, len(events.Items))
-	for i, event := range events.Items {
-		t1, err := time.Parse(time.RFC3339, event.Start.DateTime)
+	// The same event shows up in each calendar it was added to
+	var items []*calendar.Event
+	seen := make(map[string]bool)
+	for _, id := range b.calendarIDs() {
+		events, err := b.listEvents(ctx, b.eventsList(s, id).
+			TimeMin(start).
+			TimeMax(end).
+			SingleEvents(!b.CollapseRecurring).
+			OrderBy(orderBy))
 		if err != nil {
-			return errors.Wrap(err, "failed to parse start date/time")
+			return nil, errors.Wrapf(err, "failed to list events of %s", id)
 		}
+		for _, event := range b.fetchOmittedAttendees(ctx, s, id, events) {
+			if !seen[event.Id] {
+				seen[event.Id] = true
+				items = append(items, event)
+			}
+		}
+	}
+	// Even ordered by start time, merged calendars and pages fetched at
+	// different times can come out of order. OrderBy only affects
+	// which events Google returns first, never the agenda
+	if err := sortByStart(items); err != nil {
+		return nil, err
+	}
 
-		t2, err := time.Parse(time.RFC3339, event.End.DateTime)
-		if err != nil {
-			return errors.Wrap(err, "failed to parse end date/time")
+	// Google returns the events that end after t, including those that
+	// are already under way
+	if b.ExcludeOngoing {
+		if items, err = withoutOngoing(items, t); err != nil {
+			return nil, err
 		}
+	}
+	items = b.filterEvents(items)
+	if b.SkipAllDay {
+		items = withoutAllDay(items)
+	}
 
-		buf.Reset()
-		fmt.Fprintf(&buf, "%s-%s: <%s|%s>", t1.Format("15:04"), t2.Format("15:04"), event.HtmlLink, event.Summary)
+	if len(items) == 0 {
+		return nil, nil
+	}
 
-		fields[i] = slack.AttachmentField{
-			Value: buf.String(),
-		}
+	if n := len(items); n < b.MinEventsToPost {
+		b.Logger.Debugf(ctx, "only %d events, not posting the agenda", n)
+		return nil, suppressedError{reason: fmt.Sprintf("%d events is fewer than %d", n, b.MinEventsToPost)}
 	}
 
-	buf.Reset()
-	fmt.Fprintf(&buf, "Upcoming events between %s to %s", t.Format("2006 Jan 02 15:04"), t.Add(delta).Format("2006 Jan 02 15:04"))
+	header, err := b.agendaHeader(t, t.Add(delta), items)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create agenda header")
+	}
 
-	params := slack.NewPostMessageParameters()
-	params.Username = b.SlackUsername
+	n := &Notification{
+		Kind:   AgendaNotification,
+		Title:  header,
+		Start:  t,
+		End:    t.Add(delta),
+		Events: items,
+	}
+	if ids := b.calendarIDs(); len(ids) == 1 {
+		n.Calendar = ids[0]
+	}
+	return n, nil
+}
+
+// slackAgenda renders an agenda notification as a Slack message
+func (b *Bot) slackAgenda(n *Notification) (*slack.PostMessageParameters, error) {
+	// Create a message containing all events for the day
+	fields, err := b.agendaFields(n.Events)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create agenda")
+	}
+
+	params := b.slackParams(n.Calendar)
 	params.Attachments = []slack.Attachment{
 		slack.Attachment{
-			Fallback: buf.String(),
-			Fields:   fields,
-			ThumbURL: b.SlackThumbURL,
-			Title:    buf.String(),
+			Color:      b.AttachmentColor,
+			Fallback:   n.Title,
+			Fields:     fields,
+			MarkdownIn: []string{"fields"}, // free time is rendered in italics
+			ThumbURL:   b.SlackThumbURL,
+			Title:      n.Title,
 		},
 	}
-
-	return errors.Wrap(postSlack(ctx, b.SlackToken, b.SlackChannel, "", &params), "failed to post message to slack")
+	return &params, nil
 }
 
+// CalendarService creates the calendar service the bot reads events
+// with, using CalendarServiceFactory if set
 func (b *Bot) CalendarService(ctx context.Context) (*calendar.Service, error) {
+	if b.CalendarServiceFactory != nil {
+		return b.CalendarServiceFactory(ctx)
+	}
+
 	token, err := b.OAuth2Token.OAuth2Token(ctx)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to load OAuth2 token")
 	}
 
+	// Otherwise the API calls fail with a rather confusing error
+	if token.RefreshToken == "" && !token.Expiry.IsZero() && token.Expiry.Before(time.Now()) {
+		return nil, ErrTokenExpiredNoRefresh
+	}
+
 	config, err := b.OAuth2Config.OAuth2Config(ctx)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to load OAuth2 config")
@@ -280,10 +993,148 @@ func (b *Bot) CalendarService(ctx context.Context) (*calendar.Service, error) {
 	return s, nil
 }
 
-func channelID(slackcl *slack.Client, channelName string) (string, error) {
+// DefaultEventFields is the default value of Bot.EventFields. It covers
+// the fields of events.list responses that the bot uses
+const DefaultEventFields = "nextPageToken," +
+	"items(id,summary,description,location,htmlLink,colorId,created,start,end," +
+	"attendeesOmitted,attendees(email,displayName,self,organizer,optional,resource,responseStatus)," +
+	"organizer(email,displayName,self),transparency,extendedProperties," +
+	"hangoutLink,conferenceData(entryPoints(entryPointType,uri))," +
+	"recurringEventId,originalStartTime,eventType,workingLocationProperties)"
+
+const primaryCalendar = `primary`
+
+// calendarID returns the calendar ID to pass to the API. The "primary"
+// keyword is matched case-insensitively, while real calendar IDs are
+// email addresses and are passed through untouched
+func calendarID(name string) string {
+	if strings.EqualFold(name, primaryCalendar) {
+		return primaryCalendar
+	}
+	return name
+}
+
+// calendarIDs returns the calendars to include in the agenda: Calendars,
+// or CalendarName if that is empty
+func (b *Bot) calendarIDs() []string {
+	if len(b.Calendars) == 0 {
+		return []string{calendarID(b.CalendarName)}
+	}
+	ids := make([]string, len(b.Calendars))
+	for i, name := range b.Calendars {
+		ids[i] = calendarID(name)
+	}
+	return ids
+}
+
+// eventsList starts an events.list call on calendar `id` with the
+// options shared by all notifications
+func (b *Bot) eventsList(s *calendar.Service, id string) *calendar.EventsListCall {
+	call := s.Events.List(id)
+	if b.MaxAttendees > 0 {
+		call = call.MaxAttendees(b.MaxAttendees)
+	}
+	if b.PageSize > 0 {
+		call = call.MaxResults(int64(b.PageSize))
+	}
+	if b.ShowHiddenInvitations {
+		call = call.ShowHiddenInvitations(true)
+	}
+	if b.EventFields != "" {
+		call = call.Fields(googleapi.Field(b.EventFields))
+	}
+	return call
+}
+
+// listEvents runs call, following NextPageToken until all pages have
+// been fetched. The parameters of call, such as the time window and the
+// ordering, apply to each page. Listing starts over if Google fails
+// with a status that retryableCalendarError accepts, see CalendarRetries
+func (b *Bot) listEvents(ctx context.Context, call *calendar.EventsListCall) ([]*calendar.Event, error) {
+	backoff := b.CalendarRetryBase
+	for attempt := 0; ; attempt++ {
+		var events []*calendar.Event
+		err := call.Pages(ctx, func(page *calendar.Events) error {
+			events = append(events, page.Items...)
+			return nil
+		})
+		if err == nil || !retryableCalendarError(err) || attempt == b.CalendarRetries {
+			return events, err
+		}
+
+		wait := jitter(backoff)
+		b.Logger.Debugf(ctx, "listing events failed, retrying in %s: %s", wait, err)
+		select {
+		case <-ctx.Done():
+			return nil, errors.Wrap(ctx.Err(), "failed to wait for calendar")
+		case <-time.After(wait):
+		}
+		backoff *= 2
+	}
+}
+
+// fetchOmittedAttendees replaces the events of calendar `id` that Google
+// left attendees out of with the whole event, if FetchOmittedAttendees
+// is set. Events that can't be fetched are kept as they are
+func (b *Bot) fetchOmittedAttendees(ctx context.Context, s *calendar.Service, id string, events []*calendar.Event) []*calendar.Event {
+	if !b.FetchOmittedAttendees {
+		return events
+	}
+	for i, event := range events {
+		if !event.AttendeesOmitted {
+			continue
+		}
+		full, err := s.Events.Get(id, event.Id).Context(ctx).Do()
+		if err != nil {
+			b.Logger.Warningf(ctx, "failed to get all attendees of %s, using those listed: %s", event.Id, err)
+			continue
+		}
+		events[i] = full
+	}
+	return events
+}
+
+var errChannelNotFound = errors.New("failed to find matching channel/group")
+
+type channelLister interface {
+	GetChannels(bool) ([]slack.Channel, error)
+	GetGroups(bool) ([]slack.Group, error)
+}
+
+// SlackPoster is the part of the Slack API the bot needs to post, see
+// Bot.SlackClientFactory. *slack.Client implements it.
+//
+// SlackTransport, SlackRetries, SlackMetadata and idempotency keys only
+// apply to a *slack.Client. AgendaReaction, RouteToOrganizer and
+// PresenceCandidates need the AddReaction, GetUsers, OpenIMChannel and
+// GetUserPresence methods of *slack.Client, and are skipped with a
+// warning if the client doesn't have them
+type SlackPoster interface {
+	AuthTest() (*slack.AuthTestResponse, error)
+	GetChannels(bool) ([]slack.Channel, error)
+	GetGroups(bool) ([]slack.Group, error)
+	PostMessage(string, string, slack.PostMessageParameters) (string, string, error)
+}
+
+type reactionAdder interface {
+	AddReaction(string, slack.ItemRef) error
+}
+
+// slackIDPattern matches the IDs of public channels, private channels
+// and direct messages. Channel names are lowercase, so they never match
+var slackIDPattern = regexp.MustCompile(`^[CGD][A-Z0-9]{8,}$`)
+
+// channelID returns the ID of the channel or group named channelName.
+// Names that already are IDs, e.g. "C012AB3CD", are returned as they
+// are, which doesn't require being allowed to list channels
+func channelID(slackcl channelLister, channelName string) (string, error) {
+	if slackIDPattern.MatchString(channelName) {
+		return channelName, nil
+	}
+
 	channels, err := slackcl.GetChannels(false)
 	if err != nil {
-		return "", errors.Wrap(err, "failed to get channel list")
+		return "", errors.Wrap(missingScope(err, "channels.list"), "failed to get channel list")
 	}
 
 	for _, ch := range channels {
@@ -294,7 +1145,7 @@ func channelID(slackcl *slack.Client, channelName string) (string, error) {
 
 	groups, err := slackcl.GetGroups(false)
 	if err != nil {
-		return "", errors.Wrap(err, "failed to get group list")
+		return "", errors.Wrap(missingScope(err, "groups.list"), "failed to get group list")
 	}
 
 	for _, g := range groups {
@@ -302,28 +1153,231 @@ func channelID(slackcl *slack.Client, channelName string) (string, error) {
 			return g.ID, nil
 		}
 	}
-	return "", errors.New("failed to find matching channel/group")
+	return "", errChannelNotFound
+}
+
+// channelCacheEntry is a channel ID remembered by cachedChannelID
+type channelCacheEntry struct {
+	id      string
+	expires time.Time // Zero if it doesn't
 }
 
-func slackClient(ctx context.Context, token string) (*slack.Client, error) {
-	slackcl := NewSlackClient(ctx, token)
+// cachedChannelID is like channelID, reusing the IDs found in the last
+// ChannelCacheTTL. Channels that can't be found are looked up again
+// every time
+func (b *Bot) cachedChannelID(slackcl channelLister, channelName string) (string, error) {
+	if v, ok := b.channels.Load(channelName); ok {
+		entry := v.(channelCacheEntry)
+		if entry.expires.IsZero() || time.Now().Before(entry.expires) {
+			return entry.id, nil
+		}
+		b.channels.Delete(channelName)
+	}
+
+	id, err := channelID(slackcl, channelName)
+	if err != nil {
+		return "", err
+	}
+	entry := channelCacheEntry{id: id}
+	if b.ChannelCacheTTL > 0 {
+		entry.expires = time.Now().Add(b.ChannelCacheTTL)
+	}
+	b.channels.Store(channelName, entry)
+	return id, nil
+}
+
+// ClearChannelCache forgets the channel IDs looked up so far, e.g. after
+// a channel has been deleted and created again under the same name
+func (b *Bot) ClearChannelCache() {
+	b.channels.Range(func(name, _ interface{}) bool {
+		b.channels.Delete(name)
+		return true
+	})
+}
+
+// resolveChannel finds the ID of SlackChannel, trying FallbackSlackChannel
+// if the former does not exist (e.g. it has been renamed or archived)
+func (b *Bot) resolveChannel(ctx context.Context, slackcl channelLister) (string, error) {
+	chID, err := b.cachedChannelID(slackcl, b.SlackChannel)
+	if err == nil || b.FallbackSlackChannel == "" || errors.Cause(err) != errChannelNotFound {
+		return chID, err
+	}
+
+	b.Logger.Warningf(ctx, "channel %s not found, using fallback channel %s", b.SlackChannel, b.FallbackSlackChannel)
+	chID, err = b.cachedChannelID(slackcl, b.FallbackSlackChannel)
+	return chID, errors.Wrap(err, "failed to find fallback channel")
+}
+
+// newSlackClient creates a slack client with SlackClientFactory, or
+// NewSlackClient if it isn't set
+func (b *Bot) newSlackClient(ctx context.Context) SlackPoster {
+	if b.SlackClientFactory != nil {
+		return b.SlackClientFactory(ctx, b.SlackToken)
+	}
+	return NewSlackClient(ctx, b.SlackToken)
+}
+
+// slackClient creates an authenticated slack client, using
+// SlackTransport if set
+func (b *Bot) slackClient(ctx context.Context) (SlackPoster, error) {
+	poster := b.newSlackClient(ctx)
+	slackcl, ok := poster.(*slack.Client)
+	if !ok {
+		if _, err := poster.AuthTest(); err != nil {
+			return nil, errors.Wrap(err, "slack authentication test failed")
+		}
+		return poster, nil
+	}
+
+	if b.SlackTransport != nil || b.SlackRetries > 0 {
+		var base http.RoundTripper = http.DefaultTransport
+		if slackcl.HTTPClient != nil && slackcl.HTTPClient.Transport != nil {
+			base = slackcl.HTTPClient.Transport
+		}
+		if b.SlackTransport != nil {
+			base = b.SlackTransport(base)
+		}
+		if b.SlackRetries > 0 {
+			base = &retryTransport{
+				base:    base,
+				ctx:     ctx,
+				logger:  b.Logger,
+				retries: b.SlackRetries,
+				backoff: b.SlackRetryBase,
+			}
+		}
+		slackcl.HTTPClient = &http.Client{Transport: base}
+	}
 	if _, err := slackcl.AuthTest(); err != nil {
 		return nil, errors.Wrap(err, "slack authentication test failed")
 	}
+	if key := IdempotencyKey(ctx); key != "" {
+		withPostField(slackcl, "client_msg_id", key)
+	}
 	return slackcl, nil
 }
 
-func postSlack(ctx context.Context, token, channel, txt string, params *slack.PostMessageParameters) error {
-	slackcl, err := slackClient(ctx, token)
+// TestPost posts `text` to SlackChannel, to check that the bot is set
+// up correctly. It goes through the same steps as notifications, so
+// an error tells which one failed
+func (b *Bot) TestPost(ctx context.Context, text string) error {
+	params := b.slackParams("")
+	return b.postSlack(ctx, text, &params)
+}
+
+// SlackIdentity is the name and icon the bot posts with, see
+// Bot.SlackIdentities. Empty fields are left to SlackUsername and
+// SlackIconEmoji
+type SlackIdentity struct {
+	Username  string
+	IconEmoji string
+}
+
+// slackParams creates the parameters for a message about events from
+// calendar `id`, posted with that calendar's identity. An empty id uses
+// the global one
+func (b *Bot) slackParams(id string) slack.PostMessageParameters {
+	params := slack.NewPostMessageParameters()
+	params.Username = b.SlackUsername
+	params.IconEmoji = b.SlackIconEmoji
+	if identity, ok := b.SlackIdentities[id]; ok {
+		if identity.Username != "" {
+			params.Username = identity.Username
+		}
+		if identity.IconEmoji != "" {
+			params.IconEmoji = identity.IconEmoji
+		}
+	}
+	return params
+}
+
+func (b *Bot) postSlack(ctx context.Context, txt string, params *slack.PostMessageParameters) error {
+	return b.postSlackMetadata(ctx, txt, params, nil)
+}
+
+// postSlackMetadata posts to SlackChannel, attaching meta if not nil
+func (b *Bot) postSlackMetadata(ctx context.Context, txt string, params *slack.PostMessageParameters, meta *slackMetadata) error {
+	return b.postSlackReaction(ctx, txt, params, meta, "")
+}
+
+// postSlackReaction posts like postSlackMetadata, then adds the reaction
+// `name` to the message unless name is empty. Failing to react is only
+// logged, as the message is out already
+func (b *Bot) postSlackReaction(ctx context.Context, txt string, params *slack.PostMessageParameters, meta *slackMetadata, name string) error {
+	slackcl, err := b.slackClient(ctx)
 	if err != nil {
 		return errors.Wrap(err, "failed to create and authenticate slack client")
 	}
 
-	chID, err := channelID(slackcl, channel)
+	chID, err := b.resolveChannel(ctx, slackcl)
 	if err != nil {
 		return errors.Wrap(err, "failed to find channel ID")
 	}
 
-	_, _, err = slackcl.PostMessage(chID, txt, *params)
-	return errors.Wrap(err, "failed to post slack message")
+	ts, err := b.postMessage(slackcl, chID, txt, params, meta)
+	if b.archivedFallback(err) {
+		chID, ts, err = b.postFallback(ctx, slackcl, txt, params)
+	}
+	if err != nil || name == "" {
+		return err
+	}
+
+	reacter, ok := slackcl.(reactionAdder)
+	if !ok {
+		b.Logger.Warningf(ctx, "slack client can't add reactions, not adding %s", name)
+		return nil
+	}
+
+	// Slack wants the name without the colons
+	name = strings.Trim(name, ":")
+	if err := reacter.AddReaction(name, slack.NewRefToMessage(chID, ts)); err != nil {
+		b.Logger.Warningf(ctx, "failed to add reaction %s: %s", name, missingScope(err, "reactions.add"))
+	}
+	return nil
+}
+
+// postMessage posts to the channel with ID chID, applying ModifyParams
+// and attaching meta if not nil and slackcl is a *slack.Client. It
+// returns the timestamp of the message
+func (b *Bot) postMessage(slackcl SlackPoster, chID, txt string, params *slack.PostMessageParameters, meta *slackMetadata) (string, error) {
+	if err := b.reservePost(time.Now()); err != nil {
+		return "", err
+	}
+	if b.ModifyParams != nil {
+		b.ModifyParams(params)
+	}
+	if cl, ok := slackcl.(*slack.Client); ok && meta != nil {
+		if err := withMetadata(cl, meta); err != nil {
+			return "", err
+		}
+	}
+	return sendMessage(slackcl, chID, txt, params)
+}
+
+// sendMessage posts to the channel with ID chID as is
+func sendMessage(slackcl SlackPoster, chID, txt string, params *slack.PostMessageParameters) (string, error) {
+	_, ts, err := slackcl.PostMessage(chID, txt, *params)
+	err = archivedChannel(missingScope(err, "chat.postMessage"), chID)
+	return ts, errors.Wrap(err, "failed to post slack message")
+}
+
+// archivedFallback reports whether a post that failed with err should
+// be sent to FallbackSlackChannel instead, see FallbackOnArchived
+func (b *Bot) archivedFallback(err error) bool {
+	_, archived := errors.Cause(err).(*ArchivedChannelError)
+	return archived && b.FallbackOnArchived && b.FallbackSlackChannel != ""
+}
+
+// postFallback posts a message that could not be posted to the
+// archived SlackChannel to FallbackSlackChannel. The message has been
+// prepared by postMessage already. It returns the channel ID and the
+// timestamp of the message
+func (b *Bot) postFallback(ctx context.Context, slackcl SlackPoster, txt string, params *slack.PostMessageParameters) (string, string, error) {
+	b.Logger.Warningf(ctx, "channel %s is archived, using fallback channel %s", b.SlackChannel, b.FallbackSlackChannel)
+	chID, err := b.cachedChannelID(slackcl, b.FallbackSlackChannel)
+	if err != nil {
+		return "", "", errors.Wrap(err, "failed to find fallback channel")
+	}
+	ts, err := sendMessage(slackcl, chID, txt, params)
+	return chID, ts, err
 }
@@ -0,0 +1,2660 @@
+package calendarbot
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lestrrat/slack"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/calendar/v3"
+)
+
+// Times are shown in the local time zone by default, see DisplayLocation,
+// so it is the same everywhere the tests run
+func TestMain(m *testing.M) {
+	time.Local = time.UTC
+	os.Exit(m.Run())
+}
+
+type recordingLogger struct {
+	messages []string
+}
+
+func (l *recordingLogger) Debugf(_ context.Context, f string, args ...interface{}) {
+	l.messages = append(l.messages, "DEBUG "+fmt.Sprintf(f, args...))
+}
+
+func (l *recordingLogger) Warningf(_ context.Context, f string, args ...interface{}) {
+	l.messages = append(l.messages, "WARNING "+fmt.Sprintf(f, args...))
+}
+
+type fakeChannelLister struct {
+	channels map[string]string // name -> ID
+	groups   map[string]string // name -> ID
+	lookups  int               // Calls to GetChannels
+}
+
+func (l *fakeChannelLister) GetChannels(_ bool) ([]slack.Channel, error) {
+	l.lookups++
+	var list []slack.Channel
+	for name, id := range l.channels {
+		var ch slack.Channel
+		ch.ID = id
+		ch.Name = name
+		list = append(list, ch)
+	}
+	return list, nil
+}
+
+func (l *fakeChannelLister) GetGroups(_ bool) ([]slack.Group, error) {
+	var list []slack.Group
+	for name, id := range l.groups {
+		var g slack.Group
+		g.ID = id
+		g.Name = name
+		list = append(list, g)
+	}
+	return list, nil
+}
+
+func TestResolveChannelFallback(t *testing.T) {
+	lister := &fakeChannelLister{
+		channels: map[string]string{"general": "C001"},
+		groups:   map[string]string{"private-alerts": "G001"},
+	}
+
+	t.Run("primary exists", func(t *testing.T) {
+		logger := &recordingLogger{}
+		b := New()
+		b.Logger = logger
+		b.SlackChannel = "general"
+		b.FallbackSlackChannel = "private-alerts"
+
+		chID, err := b.resolveChannel(context.Background(), lister)
+		if err != nil {
+			t.Fatalf("resolveChannel failed: %s", err)
+		}
+		if chID != "C001" {
+			t.Errorf("expected C001, got %s", chID)
+		}
+		if len(logger.messages) != 0 {
+			t.Errorf("expected no warnings, got %q", logger.messages)
+		}
+	})
+
+	t.Run("primary missing", func(t *testing.T) {
+		logger := &recordingLogger{}
+		b := New()
+		b.Logger = logger
+		b.SlackChannel = "renamed"
+		b.FallbackSlackChannel = "private-alerts"
+
+		chID, err := b.resolveChannel(context.Background(), lister)
+		if err != nil {
+			t.Fatalf("resolveChannel failed: %s", err)
+		}
+		if chID != "G001" {
+			t.Errorf("expected G001, got %s", chID)
+		}
+		if len(logger.messages) != 1 || !strings.HasPrefix(logger.messages[0], "WARNING channel renamed not found") {
+			t.Errorf("expected a warning about the missing channel, got %q", logger.messages)
+		}
+	})
+
+	t.Run("no fallback", func(t *testing.T) {
+		b := New()
+		b.SlackChannel = "renamed"
+
+		if _, err := b.resolveChannel(context.Background(), lister); err == nil {
+			t.Errorf("expected an error")
+		}
+	})
+
+	t.Run("fallback missing", func(t *testing.T) {
+		b := New()
+		b.SlackChannel = "renamed"
+		b.FallbackSlackChannel = "also-renamed"
+
+		if _, err := b.resolveChannel(context.Background(), lister); err == nil {
+			t.Errorf("expected an error")
+		}
+	})
+}
+
+func TestChannelID(t *testing.T) {
+	tests := []struct {
+		name    string
+		channel string
+		expect  string
+		lookups int
+	}{
+		{"name", "general", "C001", 1},
+		{"group name", "private-alerts", "G001", 1},
+		{"channel ID", "C012AB3CD", "C012AB3CD", 0},
+		{"group ID", "G012AB3CD", "G012AB3CD", 0},
+		{"direct message ID", "D012AB3CD", "D012AB3CD", 0},
+		{"too short", "C012", "C002", 1},
+		{"lowercase", "c012ab3cd", "C003", 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lister := &fakeChannelLister{
+				channels: map[string]string{"general": "C001", "C012": "C002", "c012ab3cd": "C003"},
+				groups:   map[string]string{"private-alerts": "G001"},
+			}
+			chID, err := channelID(lister, test.channel)
+			if err != nil {
+				t.Fatalf("channelID failed: %s", err)
+			}
+			if chID != test.expect {
+				t.Errorf("expected %s, got %s", test.expect, chID)
+			}
+			if lister.lookups != test.lookups {
+				t.Errorf("expected %d lookups, got %d", test.lookups, lister.lookups)
+			}
+		})
+	}
+}
+
+func TestChannelCache(t *testing.T) {
+	t.Run("posts", func(t *testing.T) {
+		slackAPI := &fakeSlack{}
+		b := newTestBot()
+		b.SlackTransport = slackAPI.transport
+
+		for i := 0; i < 3; i++ {
+			if err := b.TestPost(context.Background(), "hello"); err != nil {
+				t.Fatalf("TestPost failed: %s", err)
+			}
+		}
+		var lookups int
+		for _, call := range slackAPI.calls {
+			if call.Method == "channels.list" {
+				lookups++
+			}
+		}
+		if lookups != 1 || len(slackAPI.posts()) != 3 {
+			t.Errorf("expected 3 posts after 1 lookup, got %d posts after %d", len(slackAPI.posts()), lookups)
+		}
+	})
+
+	tests := []struct {
+		name   string
+		ttl    time.Duration
+		clear  bool
+		expect int // Lookups after two resolutions
+	}{
+		{"no ttl", 0, false, 1},
+		{"within ttl", time.Hour, false, 1},
+		{"expired", time.Nanosecond, false, 2},
+		{"cleared", 0, true, 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lister := &fakeChannelLister{channels: map[string]string{"general": "C001"}}
+			b := New()
+			b.ChannelCacheTTL = test.ttl
+			b.SlackChannel = "general"
+
+			for i := 0; i < 2; i++ {
+				if i == 1 {
+					time.Sleep(time.Millisecond)
+					if test.clear {
+						b.ClearChannelCache()
+					}
+				}
+				chID, err := b.resolveChannel(context.Background(), lister)
+				if err != nil {
+					t.Fatalf("resolveChannel failed: %s", err)
+				}
+				if chID != "C001" {
+					t.Errorf("expected C001, got %s", chID)
+				}
+			}
+			if lister.lookups != test.expect {
+				t.Errorf("expected %d lookups, got %d", test.expect, lister.lookups)
+			}
+		})
+	}
+
+	t.Run("not found", func(t *testing.T) {
+		lister := &fakeChannelLister{}
+		b := New()
+		b.SlackChannel = "general"
+
+		for i := 0; i < 2; i++ {
+			if _, err := b.resolveChannel(context.Background(), lister); err == nil {
+				t.Fatal("expected an error")
+			}
+		}
+		// Creating the channel takes effect right away
+		lister.channels = map[string]string{"general": "C001"}
+		if chID, err := b.resolveChannel(context.Background(), lister); err != nil || chID != "C001" {
+			t.Errorf("expected C001, got %q (%v)", chID, err)
+		}
+		if lister.lookups != 3 {
+			t.Errorf("expected missing channels to be looked up every time, got %d lookups", lister.lookups)
+		}
+	})
+}
+
+func TestLogRedactor(t *testing.T) {
+	event := &calendar.Event{
+		Id:          "abc123",
+		Summary:     "Salary review: Jane",
+		Description: "confidential",
+	}
+
+	t.Run("default", func(t *testing.T) {
+		logger := &recordingLogger{}
+		b := New()
+		b.Logger = logger
+
+		b.debugEvent(context.Background(), event, "skipping")
+		expect := []string{"DEBUG skipping: abc123 [19 chars]"}
+		if !reflect.DeepEqual(logger.messages, expect) {
+			t.Errorf("expected %q, got %q", expect, logger.messages)
+		}
+	})
+
+	t.Run("custom", func(t *testing.T) {
+		logger := &recordingLogger{}
+		b := New()
+		b.Logger = logger
+		b.LogRedactor = func(_ string) string { return "***" }
+
+		b.debugEvent(context.Background(), event, "skipping")
+		expect := []string{"DEBUG skipping: abc123 ***"}
+		if !reflect.DeepEqual(logger.messages, expect) {
+			t.Errorf("expected %q, got %q", expect, logger.messages)
+		}
+	})
+
+	t.Run("unset", func(t *testing.T) {
+		logger := &recordingLogger{}
+		b := &Bot{Logger: logger}
+
+		b.debugEvent(context.Background(), event, "skipping")
+		for _, msg := range logger.messages {
+			if strings.Contains(msg, "Salary") {
+				t.Errorf("summary leaked into log: %q", msg)
+			}
+		}
+	})
+
+	if s := RedactLength("日本語"); s != "[3 chars]" {
+		t.Errorf("expected length in characters, got %s", s)
+	}
+}
+
+func TestNewInvitations(t *testing.T) {
+	since := time.Date(2017, 1, 10, 9, 0, 0, 0, time.UTC)
+
+	fresh := testEvent("fresh", "2017-01-11T09:00:00Z", "2017-01-11T10:00:00Z")
+	fresh.Created = "2017-01-10T09:30:00Z"
+	fresh.Organizer = &calendar.EventOrganizer{Email: "boss@example.com"}
+
+	boundary := testEvent("boundary", "2017-01-11T10:00:00Z", "2017-01-11T11:00:00Z")
+	boundary.Created = "2017-01-10T09:00:00Z"
+
+	old := testEvent("old", "2017-01-11T11:00:00Z", "2017-01-11T12:00:00Z")
+	old.Created = "2017-01-03T09:00:00Z"
+	old.Updated = "2017-01-10T09:30:00Z"
+
+	mine := testEvent("mine", "2017-01-11T12:00:00Z", "2017-01-11T13:00:00Z")
+	mine.Created = "2017-01-10T09:30:00Z"
+	mine.Organizer = &calendar.EventOrganizer{Email: "me@example.com", Self: true}
+
+	broken := testEvent("broken", "2017-01-11T12:00:00Z", "2017-01-11T13:00:00Z")
+	broken.Created = "yesterday"
+
+	logger := &recordingLogger{}
+	b := newTestBot()
+	b.Logger = logger
+	list := b.newInvitations(context.Background(), []*calendar.Event{fresh, broken, boundary, old, mine}, since)
+
+	var ids []string
+	for _, event := range list {
+		ids = append(ids, event.Id)
+	}
+	expect := []string{"fresh", "boundary"}
+	if !reflect.DeepEqual(ids, expect) {
+		t.Errorf("expected %q, got %q", expect, ids)
+	}
+
+	// One malformed event doesn't hide the others
+	if len(logger.messages) != 1 || !strings.HasPrefix(logger.messages[0], "WARNING failed to parse creation time of broken") {
+		t.Errorf("expected a warning for the malformed creation time, got %q", logger.messages)
+	}
+}
+
+type fakePresence struct {
+	presence map[string]string // user ID -> presence
+	lookups  []string
+}
+
+func (p *fakePresence) GetUserPresence(user string) (*slack.UserPresence, error) {
+	p.lookups = append(p.lookups, user)
+	presence, ok := p.presence[user]
+	if !ok {
+		return nil, errors.New("user_not_found")
+	}
+	return &slack.UserPresence{Presence: presence}, nil
+}
+
+func TestActiveUser(t *testing.T) {
+	tests := []struct {
+		name       string
+		presence   map[string]string
+		candidates []string
+		expect     string
+		lookups    []string
+	}{
+		{
+			name:       "first active",
+			presence:   map[string]string{"U1": "active", "U2": "active"},
+			candidates: []string{"U1", "U2"},
+			expect:     "U1",
+			lookups:    []string{"U1"},
+		},
+		{
+			name:       "skip away",
+			presence:   map[string]string{"U1": "away", "U2": "active", "U3": "active"},
+			candidates: []string{"U1", "U2", "U3"},
+			expect:     "U2",
+			lookups:    []string{"U1", "U2"},
+		},
+		{
+			name:       "nobody active",
+			presence:   map[string]string{"U1": "away", "U2": "away"},
+			candidates: []string{"U1", "U2"},
+			expect:     "",
+			lookups:    []string{"U1", "U2"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := &fakePresence{presence: test.presence}
+			user, err := activeUser(p, test.candidates)
+			if err != nil {
+				t.Fatalf("activeUser failed: %s", err)
+			}
+			if user != test.expect {
+				t.Errorf("expected %q, got %q", test.expect, user)
+			}
+			if !reflect.DeepEqual(p.lookups, test.lookups) {
+				t.Errorf("expected lookups %q, got %q", test.lookups, p.lookups)
+			}
+		})
+	}
+
+	p := &fakePresence{presence: map[string]string{}}
+	if _, err := activeUser(p, []string{"U404"}); err == nil {
+		t.Errorf("expected an error for an unknown user")
+	}
+}
+
+type testCacheMiss struct{}
+
+func (_ testCacheMiss) CacheMiss() bool { return true }
+func (_ testCacheMiss) Error() string   { return "cache miss" }
+
+// mapCache is an EventCache that reports misses in a way IsCacheMiss
+// recognizes, and records the TTLs it was given
+type mapCache struct {
+	data map[string][]byte
+	ttls map[string]time.Duration
+}
+
+func newMapCache() *mapCache {
+	return &mapCache{
+		data: make(map[string][]byte),
+		ttls: make(map[string]time.Duration),
+	}
+}
+
+func (c *mapCache) Add(_ context.Context, key string, val []byte, ttl time.Duration) error {
+	if _, ok := c.data[key]; ok {
+		return errors.New("entry exists")
+	}
+	c.data[key] = val
+	c.ttls[key] = ttl
+	return nil
+}
+
+func (c *mapCache) Get(_ context.Context, key string) (interface{}, error) {
+	v, ok := c.data[key]
+	if !ok {
+		return nil, testCacheMiss{}
+	}
+	return v, nil
+}
+
+func (c *mapCache) Remove(_ context.Context, key string) error {
+	delete(c.data, key)
+	delete(c.ttls, key)
+	return nil
+}
+
+func TestSeen(t *testing.T) {
+	cache := newMapCache()
+	b := New()
+	b.Cache = cache
+
+	ctx := context.Background()
+	if ok, err := b.seen(ctx, "foo"); err != nil || ok {
+		t.Errorf("expected miss, got %t, %v", ok, err)
+	}
+	cache.Add(ctx, "foo", []byte{0x1}, time.Minute)
+	if ok, err := b.seen(ctx, "foo"); err != nil || !ok {
+		t.Errorf("expected hit, got %t, %v", ok, err)
+	}
+}
+
+type staticTokenProvider struct {
+	token *oauth2.Token
+}
+
+func (p staticTokenProvider) OAuth2Token(_ context.Context) (*oauth2.Token, error) {
+	return p.token, nil
+}
+
+type staticConfigProvider struct {
+	config *oauth2.Config
+}
+
+func (p staticConfigProvider) OAuth2Config(_ context.Context) (*oauth2.Config, error) {
+	return p.config, nil
+}
+
+func TestCalendarServiceExpiredToken(t *testing.T) {
+	tests := []struct {
+		name    string
+		token   *oauth2.Token
+		expired bool
+	}{
+		{
+			name: "expired without refresh token",
+			token: &oauth2.Token{
+				AccessToken: "access",
+				Expiry:      time.Now().Add(-time.Hour),
+			},
+			expired: true,
+		},
+		{
+			name: "expired with refresh token",
+			token: &oauth2.Token{
+				AccessToken:  "access",
+				RefreshToken: "refresh",
+				Expiry:       time.Now().Add(-time.Hour),
+			},
+		},
+		{
+			name: "valid without refresh token",
+			token: &oauth2.Token{
+				AccessToken: "access",
+				Expiry:      time.Now().Add(time.Hour),
+			},
+		},
+		{
+			name:  "no expiry",
+			token: &oauth2.Token{AccessToken: "access"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := New()
+			b.OAuth2Token = staticTokenProvider{token: test.token}
+			b.OAuth2Config = staticConfigProvider{config: &oauth2.Config{}}
+
+			_, err := b.CalendarService(context.Background())
+			if test.expired {
+				if errors.Cause(err) != ErrTokenExpiredNoRefresh {
+					t.Errorf("expected ErrTokenExpiredNoRefresh, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("CalendarService failed: %s", err)
+			}
+		})
+	}
+}
+
+// fakeCalendar is a http.RoundTripper standing in for the Google
+// Calendar API. It answers events.list, events.get, calendarList.get and
+// freebusy.query requests with canned responses and records every
+// request it sees
+type fakeCalendar struct {
+	events       []*calendar.Event
+	acl          *calendar.Acl                // Returned by acl.list
+	calendars    map[string][]*calendar.Event // Events by calendar ID, instead of events
+	calendarList *calendar.CalendarListEntry  // Returned for any calendar
+	colors       *calendar.Colors             // Returned by colors.get
+	freeBusy     *calendar.FreeBusyResponse   // Returned by freebusy.query
+	full         map[string]*calendar.Event   // Returned by events.get instead of the listed event, 404 if nil
+	pageSize     int                          // Events per page of events.list, all on one page if 0
+	requests     []*http.Request
+}
+
+func (c *fakeCalendar) RoundTrip(r *http.Request) (*http.Response, error) {
+	c.requests = append(c.requests, r)
+	if strings.Contains(r.URL.Path, "/users/me/calendarList/") && c.calendarList != nil {
+		buf, err := json.Marshal(c.calendarList)
+		if err != nil {
+			return nil, err
+		}
+		return jsonResponse(http.StatusOK, string(buf)), nil
+	}
+
+	if strings.HasSuffix(r.URL.Path, "/acl") && c.acl != nil {
+		buf, err := json.Marshal(c.acl)
+		if err != nil {
+			return nil, err
+		}
+		return jsonResponse(http.StatusOK, string(buf)), nil
+	}
+	if strings.HasSuffix(r.URL.Path, "/colors") && c.colors != nil {
+		buf, err := json.Marshal(c.colors)
+		if err != nil {
+			return nil, err
+		}
+		return jsonResponse(http.StatusOK, string(buf)), nil
+	}
+	if strings.HasSuffix(r.URL.Path, "/freeBusy") && c.freeBusy != nil {
+		buf, err := json.Marshal(c.freeBusy)
+		if err != nil {
+			return nil, err
+		}
+		return jsonResponse(http.StatusOK, string(buf)), nil
+	}
+
+	notFound := jsonResponse(http.StatusNotFound, `{"error":{"code":404,"message":"Not Found"}}`)
+	parts := strings.Split(r.URL.Path, "/calendars/")
+	if len(parts) != 2 {
+		return notFound, nil
+	}
+	id, eventID := strings.TrimSuffix(parts[1], "/events"), ""
+	if i := strings.Index(id, "/events/"); i >= 0 {
+		id, eventID = id[:i], id[i+len("/events/"):]
+	}
+	events := c.events
+	if c.calendars != nil {
+		var ok bool
+		if events, ok = c.calendars[id]; !ok {
+			return notFound, nil
+		}
+	}
+	if eventID != "" {
+		if event, ok := c.full[eventID]; ok {
+			if event == nil {
+				return notFound, nil
+			}
+			buf, err := json.Marshal(event)
+			if err != nil {
+				return nil, err
+			}
+			return jsonResponse(http.StatusOK, string(buf)), nil
+		}
+		for _, event := range events {
+			if event.Id == eventID {
+				buf, err := json.Marshal(event)
+				if err != nil {
+					return nil, err
+				}
+				return jsonResponse(http.StatusOK, string(buf)), nil
+			}
+		}
+		return notFound, nil
+	}
+	if !strings.HasSuffix(r.URL.Path, "/events") {
+		return jsonResponse(http.StatusOK, fmt.Sprintf(`{"id":%q}`, id)), nil
+	}
+
+	page := &calendar.Events{Items: events}
+	if c.pageSize > 0 {
+		// The page token is the index of the first event on the page
+		first, _ := strconv.Atoi(r.URL.Query().Get("pageToken"))
+		last := first + c.pageSize
+		if last < len(events) {
+			page.NextPageToken = strconv.Itoa(last)
+		} else {
+			last = len(events)
+		}
+		page.Items = events[first:last]
+	}
+	buf, err := json.Marshal(page)
+	if err != nil {
+		return nil, err
+	}
+	if mask := r.URL.Query().Get("fields"); mask != "" {
+		var v interface{}
+		if err := json.Unmarshal(buf, &v); err != nil {
+			return nil, err
+		}
+		if buf, err = json.Marshal(applyFieldMask(v, mask)); err != nil {
+			return nil, err
+		}
+	}
+	return jsonResponse(http.StatusOK, string(buf)), nil
+}
+
+// applyFieldMask trims v like Google does for partial responses, e.g.
+// "items(id,start)" leaves only the id and start of each item
+func applyFieldMask(v interface{}, mask string) interface{} {
+	switch v := v.(type) {
+	case []interface{}:
+		for i := range v {
+			v[i] = applyFieldMask(v[i], mask)
+		}
+		return v
+	case map[string]interface{}:
+		fields := make(map[string]string) // name -> nested mask
+		depth, begin := 0, 0
+		for i := 0; i <= len(mask); i++ {
+			if i < len(mask) && mask[i] == '(' {
+				depth++
+			} else if i < len(mask) && mask[i] == ')' {
+				depth--
+			} else if i == len(mask) || (mask[i] == ',' && depth == 0) {
+				field := mask[begin:i]
+				if j := strings.IndexByte(field, '('); j >= 0 {
+					fields[field[:j]] = field[j+1 : len(field)-1]
+				} else {
+					fields[field] = ""
+				}
+				begin = i + 1
+			}
+		}
+		trimmed := make(map[string]interface{})
+		for name, nested := range fields {
+			if value, ok := v[name]; ok {
+				if nested != "" {
+					value = applyFieldMask(value, nested)
+				}
+				trimmed[name] = value
+			}
+		}
+		return trimmed
+	default:
+		return v
+	}
+}
+
+// context returns a context that makes the OAuth2 client used by
+// Bot.CalendarService talk to c
+func (c *fakeCalendar) context() context.Context {
+	return context.WithValue(context.Background(), oauth2.HTTPClient, &http.Client{Transport: c})
+}
+
+func jsonResponse(code int, body string) *http.Response {
+	return &http.Response{
+		StatusCode: code,
+		Status:     http.StatusText(code),
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       ioutil.NopCloser(strings.NewReader(body)),
+	}
+}
+
+// newTestBot creates a Bot with valid OAuth2 credentials and a cache
+// that IsCacheMiss understands
+func newTestBot() *Bot {
+	b := New()
+	b.Cache = newMapCache()
+	b.OAuth2Config = staticConfigProvider{config: &oauth2.Config{}}
+	b.OAuth2Token = staticTokenProvider{token: &oauth2.Token{
+		AccessToken: "access",
+		Expiry:      time.Now().Add(time.Hour),
+	}}
+	b.SlackChannel = "general"
+	b.SlackUsername = "calendarbot"
+	return b
+}
+
+func TestCalendarID(t *testing.T) {
+	tests := []struct {
+		name   string
+		expect string
+	}{
+		{"primary", "primary"},
+		{"Primary", "primary"},
+		{"PRIMARY", "primary"},
+		{"Team.Calendar@Example.com", "Team.Calendar@Example.com"},
+		{"Primary@example.com", "Primary@example.com"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := calendarID(test.name); got != test.expect {
+				t.Errorf("expected %q, got %q", test.expect, got)
+			}
+		})
+	}
+}
+
+func TestUpcomingMessageCalendarID(t *testing.T) {
+	cal := &fakeCalendar{}
+	b := newTestBot()
+	b.CalendarName = "PRIMARY"
+
+	if _, err := b.upcomingMessage(cal.context(), time.Now(), time.Hour); err != nil {
+		t.Fatalf("upcomingMessage failed: %s", err)
+	}
+	if len(cal.requests) != 1 {
+		t.Fatalf("expected 1 calendar request, got %d", len(cal.requests))
+	}
+	if p := cal.requests[0].URL.Path; !strings.HasSuffix(p, "/calendars/primary/events") {
+		t.Errorf("expected the primary calendar, got %s", p)
+	}
+}
+
+func TestMemoryCacheEviction(t *testing.T) {
+	ctx := context.Background()
+	c := newMemoryCache(2, 0)
+
+	isMiss := func(key string) bool {
+		_, err := c.Get(ctx, key)
+		return IsCacheMiss(err)
+	}
+
+	for _, key := range []string{"a", "b"} {
+		if err := c.Add(ctx, key, nil, time.Hour); err != nil {
+			t.Fatalf("failed to add %s: %s", key, err)
+		}
+	}
+	// Using "a" makes "b" the least recently used entry
+	if isMiss("a") {
+		t.Fatalf("expected a to be cached")
+	}
+	if err := c.Add(ctx, "c", nil, time.Hour); err != nil {
+		t.Fatalf("failed to add c: %s", err)
+	}
+
+	for key, expect := range map[string]bool{"a": false, "b": true, "c": false} {
+		if got := isMiss(key); got != expect {
+			t.Errorf("%s: expected miss to be %t, got %t", key, expect, got)
+		}
+	}
+	if len(c.data) != 2 || c.lru.Len() != 2 {
+		t.Errorf("expected 2 entries, got %d/%d", len(c.data), c.lru.Len())
+	}
+}
+
+func TestMemoryCacheUnbounded(t *testing.T) {
+	ctx := context.Background()
+	c := NewMemoryCache(0)
+
+	for i := 0; i < 100; i++ {
+		if err := c.Add(ctx, fmt.Sprintf("key%d", i), nil, time.Hour); err != nil {
+			t.Fatalf("failed to add key%d: %s", i, err)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		if _, err := c.Get(ctx, fmt.Sprintf("key%d", i)); err != nil {
+			t.Errorf("expected key%d to be cached: %s", i, err)
+		}
+	}
+}
+
+func TestMemoryCacheSweep(t *testing.T) {
+	ctx := context.Background()
+	c := newMemoryCache(0, 10*time.Millisecond)
+	defer c.Close()
+
+	if err := c.Add(ctx, "short", nil, time.Millisecond); err != nil {
+		t.Fatalf("failed to add short: %s", err)
+	}
+	if err := c.Add(ctx, "long", nil, time.Hour); err != nil {
+		t.Fatalf("failed to add long: %s", err)
+	}
+
+	size := func() (int, int) {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		return len(c.data), c.lru.Len()
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		if n, _ := size(); n == 1 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n, l := size(); n != 1 || l != 1 {
+		t.Fatalf("expected the expired entry to be swept, got %d/%d entries", n, l)
+	}
+	c.mutex.Lock()
+	_, ok := c.data["long"]
+	c.mutex.Unlock()
+	if !ok {
+		t.Errorf("expected long to be kept")
+	}
+
+	// Closing stops the sweeper, and may be done more than once
+	c.Close()
+	c.Close()
+	if err := c.Add(ctx, "after", nil, time.Millisecond); err != nil {
+		t.Fatalf("failed to add after: %s", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n, _ := size(); n != 2 {
+		t.Errorf("expected nothing to be swept after Close, got %d entries", n)
+	}
+}
+
+func TestMinEventsToPost(t *testing.T) {
+	events := []*calendar.Event{
+		testEvent("a", "2017-01-10T09:00:00Z", "2017-01-10T10:00:00Z"),
+		testEvent("b", "2017-01-10T10:00:00Z", "2017-01-10T11:00:00Z"),
+		testEvent("c", "2017-01-10T11:00:00Z", "2017-01-10T12:00:00Z"),
+	}
+	start := mustParseTime(t, "2017-01-10T08:00:00Z")
+
+	tests := []struct {
+		minimum    int
+		suppressed bool
+	}{
+		{0, false},
+		{2, false},
+		{3, false},
+		{4, true},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("minimum %d", test.minimum), func(t *testing.T) {
+			cal := &fakeCalendar{events: events}
+			b := newTestBot()
+			b.MinEventsToPost = test.minimum
+
+			params, err := b.upcomingMessage(cal.context(), start, 12*time.Hour)
+			if IsSuppressed(err) != test.suppressed {
+				t.Fatalf("expected suppressed to be %t, got error %v", test.suppressed, err)
+			}
+			if test.suppressed {
+				if params != nil {
+					t.Errorf("expected no message when suppressed")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("upcomingMessage failed: %s", err)
+			}
+			if params == nil || len(params.Attachments[0].Fields) != len(events) {
+				t.Errorf("expected a message with %d events", len(events))
+			}
+		})
+	}
+
+	// The suppression survives wrapping, and other errors are not affected
+	if !IsSuppressed(errors.Wrap(suppressedError{reason: "test"}, "wrapped")) {
+		t.Errorf("expected a wrapped suppressedError to be suppressed")
+	}
+	if IsSuppressed(errors.New("failed")) || IsSuppressed(nil) {
+		t.Errorf("expected other errors not to be suppressed")
+	}
+}
+
+// fakeSlack is a http.RoundTripper standing in for the Slack API. It
+// knows about a single "general" channel and a user "alice", and
+// records every call
+type fakeSlack struct {
+	calls          []fakeSlackCall
+	errors         map[string]string // Returned by the method used as key
+	postError      string            // Returned by chat.postMessage if set
+	deletedThreads map[string]bool   // Replies to these timestamps fail with thread_not_found
+	archived       map[string]bool   // Posts to these channel IDs fail with is_archived
+}
+
+type fakeSlackCall struct {
+	Method string
+	Form   url.Values
+}
+
+func (s *fakeSlack) RoundTrip(r *http.Request) (*http.Response, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+	method := path.Base(r.URL.Path)
+	s.calls = append(s.calls, fakeSlackCall{Method: method, Form: r.PostForm})
+	if msg, ok := s.errors[method]; ok {
+		return jsonResponse(http.StatusOK, `{"ok":false,"error":"`+msg+`"}`), nil
+	}
+
+	switch method {
+	case "channels.list":
+		return jsonResponse(http.StatusOK, `{"ok":true,"channels":[{"id":"C024BE91L","name":"general"}]}`), nil
+	case "users.list":
+		return jsonResponse(http.StatusOK, `{"ok":true,"members":[{"id":"U0G9QF9C6","name":"alice","profile":{"email":"alice@example.com"}}]}`), nil
+	case "im.open":
+		return jsonResponse(http.StatusOK, `{"ok":true,"channel":{"id":"D0G9QF9C6"}}`), nil
+	case "chat.postMessage":
+		if s.postError != "" {
+			return jsonResponse(http.StatusOK, `{"ok":false,"error":"`+s.postError+`"}`), nil
+		}
+		if s.archived[r.PostForm.Get("channel")] {
+			return jsonResponse(http.StatusOK, `{"ok":false,"error":"is_archived"}`), nil
+		}
+		if s.deletedThreads[r.PostForm.Get("thread_ts")] {
+			return jsonResponse(http.StatusOK, `{"ok":false,"error":"thread_not_found"}`), nil
+		}
+		return jsonResponse(http.StatusOK, fmt.Sprintf(`{"ok":true,"channel":"C024BE91L","ts":"1484000000.%06d"}`, len(s.calls))), nil
+	default:
+		return jsonResponse(http.StatusOK, `{"ok":true}`), nil
+	}
+}
+
+// transport can be used as Bot.SlackTransport
+func (s *fakeSlack) transport(http.RoundTripper) http.RoundTripper {
+	return s
+}
+
+// posts returns the chat.postMessage calls
+func (s *fakeSlack) posts() []fakeSlackCall {
+	var posts []fakeSlackCall
+	for _, call := range s.calls {
+		if call.Method == "chat.postMessage" {
+			posts = append(posts, call)
+		}
+	}
+	return posts
+}
+
+func TestSlackTransport(t *testing.T) {
+	slackAPI := &fakeSlack{}
+	var base http.RoundTripper
+	b := newTestBot()
+	b.SlackTransport = func(rt http.RoundTripper) http.RoundTripper {
+		base = rt
+		return slackAPI
+	}
+
+	params := slack.NewPostMessageParameters()
+	if err := b.postSlack(context.Background(), "hello", &params); err != nil {
+		t.Fatalf("postSlack failed: %s", err)
+	}
+	if base != http.DefaultTransport {
+		t.Errorf("expected the default transport to be wrapped, got %v", base)
+	}
+
+	var methods []string
+	for _, call := range slackAPI.calls {
+		methods = append(methods, call.Method)
+	}
+	expect := []string{"auth.test", "channels.list", "chat.postMessage"}
+	if !reflect.DeepEqual(methods, expect) {
+		t.Errorf("expected calls %q, got %q", expect, methods)
+	}
+	posts := slackAPI.posts()
+	if len(posts) != 1 || posts[0].Form.Get("channel") != "C024BE91L" || posts[0].Form.Get("text") != "hello" {
+		t.Errorf("unexpected posts %#v", posts)
+	}
+}
+
+func TestPageSize(t *testing.T) {
+	start := mustParseTime(t, "2017-01-10T08:00:00Z")
+	for _, size := range []int{0, 50} {
+		t.Run(fmt.Sprintf("size %d", size), func(t *testing.T) {
+			cal := &fakeCalendar{events: []*calendar.Event{
+				testEvent("a", "2017-01-10T09:00:00Z", "2017-01-10T10:00:00Z"),
+			}}
+			b := newTestBot()
+			b.PageSize = size
+
+			if _, err := b.upcomingMessage(cal.context(), start, time.Hour); err != nil {
+				t.Fatalf("upcomingMessage failed: %s", err)
+			}
+			expect := ""
+			if size > 0 {
+				expect = fmt.Sprint(size)
+			}
+			for _, r := range cal.requests {
+				if got := r.URL.Query().Get("maxResults"); got != expect {
+					t.Errorf("expected maxResults %q, got %q", expect, got)
+				}
+			}
+		})
+	}
+}
+
+func TestPagination(t *testing.T) {
+	start := time.Now().Add(5 * time.Minute).UTC().Truncate(time.Minute)
+	event := func(id string, offset time.Duration) *calendar.Event {
+		t := start.Add(offset)
+		return testEvent(id, t.Format(time.RFC3339), t.Add(time.Hour).Format(time.RFC3339))
+	}
+	events := []*calendar.Event{
+		event("a", 0),
+		event("b", 5*time.Minute),
+		event("c", 10*time.Minute),
+	}
+
+	tests := []struct {
+		name   string
+		notify func(*Bot, context.Context) error
+	}{
+		{"upcoming", func(b *Bot, ctx context.Context) error {
+			return b.NotifyUpcomingEvents(ctx, start, time.Hour)
+		}},
+		{"individual", func(b *Bot, ctx context.Context) error {
+			return b.NotifyIndividualEvents(ctx, start, time.Hour)
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cal := &fakeCalendar{events: events, pageSize: 2}
+			var got []string
+			b := newTestBot()
+			b.PageSize = 2
+			b.Notifier = NotifierFunc(func(_ context.Context, n *Notification) error {
+				for _, event := range n.Events {
+					got = append(got, event.Id)
+				}
+				return nil
+			})
+
+			if err := test.notify(b, cal.context()); err != nil {
+				t.Fatalf("notify failed: %s", err)
+			}
+			if expect := []string{"a", "b", "c"}; !reflect.DeepEqual(got, expect) {
+				t.Errorf("expected events %q, got %q", expect, got)
+			}
+
+			if len(cal.requests) != 2 {
+				t.Fatalf("expected 2 requests, got %d", len(cal.requests))
+			}
+			first, second := cal.requests[0].URL.Query(), cal.requests[1].URL.Query()
+			if token := second.Get("pageToken"); token != "2" {
+				t.Errorf("expected the second page to be requested, got token %q", token)
+			}
+			second.Del("pageToken")
+			if !reflect.DeepEqual(first, second) {
+				t.Errorf("expected the same parameters for each page, got %v and %v", first, second)
+			}
+		})
+	}
+}
+
+func TestMaxAttendees(t *testing.T) {
+	start := mustParseTime(t, "2017-01-10T08:00:00Z")
+	events := []*calendar.Event{
+		testEvent("a", "2017-01-10T09:00:00Z", "2017-01-10T10:00:00Z"),
+	}
+
+	tests := []struct {
+		max    int64
+		expect string
+	}{
+		{0, ""},
+		{10, "10"},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("max %d", test.max), func(t *testing.T) {
+			cal := &fakeCalendar{events: events}
+			b := newTestBot()
+			b.MaxAttendees = test.max
+
+			if _, err := b.upcomingMessage(cal.context(), start, time.Hour); err != nil {
+				t.Fatalf("upcomingMessage failed: %s", err)
+			}
+			if len(cal.requests) != 1 {
+				t.Fatalf("expected 1 calendar request, got %d", len(cal.requests))
+			}
+			if got := cal.requests[0].URL.Query().Get("maxAttendees"); got != test.expect {
+				t.Errorf("expected maxAttendees %q, got %q", test.expect, got)
+			}
+		})
+	}
+}
+
+func TestModifyParams(t *testing.T) {
+	slackAPI := &fakeSlack{}
+	b := newTestBot()
+	b.SlackTransport = slackAPI.transport
+	b.ModifyParams = func(params *slack.PostMessageParameters) {
+		params.LinkNames = 1
+		params.Username = "override"
+		params.Attachments[0].Color = "good"
+	}
+
+	params := slack.NewPostMessageParameters()
+	params.Username = b.SlackUsername
+	params.Attachments = []slack.Attachment{{Title: "event"}}
+	if err := b.postSlack(context.Background(), "hello", &params); err != nil {
+		t.Fatalf("postSlack failed: %s", err)
+	}
+
+	posts := slackAPI.posts()
+	if len(posts) != 1 {
+		t.Fatalf("expected 1 post, got %d", len(posts))
+	}
+	form := posts[0].Form
+	if form.Get("link_names") != "1" || form.Get("username") != "override" {
+		t.Errorf("expected modified parameters, got %v", form)
+	}
+	var attachments []slack.Attachment
+	if err := json.Unmarshal([]byte(form.Get("attachments")), &attachments); err != nil {
+		t.Fatalf("failed to decode attachments: %s", err)
+	}
+	if len(attachments) != 1 || attachments[0].Color != "good" {
+		t.Errorf("expected modified attachments, got %#v", attachments)
+	}
+}
+
+func TestRouteToOrganizer(t *testing.T) {
+	start := time.Now().Add(10 * time.Minute).UTC()
+	event := func(id, organizer string) *calendar.Event {
+		e := testEvent(id, start.Format(time.RFC3339), start.Add(time.Hour).Format(time.RFC3339))
+		e.Organizer = &calendar.EventOrganizer{Email: organizer}
+		return e
+	}
+
+	tests := []struct {
+		name     string
+		route    bool
+		event    *calendar.Event
+		expect   string
+		warnings int
+	}{
+		{"disabled", false, event("a", "alice@example.com"), "C024BE91L", 0},
+		{"resolvable", true, event("a", "Alice@Example.com"), "D0G9QF9C6", 0},
+		{"unresolvable", true, event("b", "bob@example.com"), "C024BE91L", 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cal := &fakeCalendar{events: []*calendar.Event{test.event}}
+			slackAPI := &fakeSlack{}
+			logger := &recordingLogger{}
+			b := newTestBot()
+			b.Logger = logger
+			b.RouteToOrganizer = test.route
+			b.SlackTransport = slackAPI.transport
+
+			if err := b.NotifyIndividualEvents(cal.context(), time.Now(), time.Hour); err != nil {
+				t.Fatalf("NotifyIndividualEvents failed: %s", err)
+			}
+			posts := slackAPI.posts()
+			if len(posts) != 1 {
+				t.Fatalf("expected 1 post, got %d", len(posts))
+			}
+			if ch := posts[0].Form.Get("channel"); ch != test.expect {
+				t.Errorf("expected channel %s, got %s", test.expect, ch)
+			}
+			var warnings int
+			for _, msg := range logger.messages {
+				if strings.HasPrefix(msg, "WARNING ") {
+					warnings++
+				}
+			}
+			if warnings != test.warnings {
+				t.Errorf("expected %d warnings, got %q", test.warnings, logger.messages)
+			}
+		})
+	}
+}
+
+func TestSkipAllDay(t *testing.T) {
+	start := mustParseTime(t, "2017-01-10T00:00:00Z")
+	events := []*calendar.Event{
+		testAllDayEvent("birthday", "2017-01-10", "2017-01-11"),
+		testEvent("a", "2017-01-10T09:00:00Z", "2017-01-10T10:00:00Z"),
+	}
+
+	tests := []struct {
+		skip   bool
+		expect []string
+	}{
+		{false, []string{
+			"All day: <https://calendar.google.com/event?date=20170110&eid=birthday|birthday>",
+			"09:00-10:00: <https://calendar.google.com/event?eid=a|a>",
+		}},
+		{true, []string{
+			"09:00-10:00: <https://calendar.google.com/event?eid=a|a>",
+		}},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("skip %t", test.skip), func(t *testing.T) {
+			cal := &fakeCalendar{events: events}
+			b := newTestBot()
+			b.SkipAllDay = test.skip
+
+			params, err := b.upcomingMessage(cal.context(), start, 24*time.Hour)
+			if err != nil {
+				t.Fatalf("upcomingMessage failed: %s", err)
+			}
+			var values []string
+			for _, f := range params.Attachments[0].Fields {
+				values = append(values, f.Value)
+			}
+			if !reflect.DeepEqual(values, test.expect) {
+				t.Errorf("expected %q, got %q", test.expect, values)
+			}
+		})
+	}
+}
+
+func TestIndividualEventsAllDay(t *testing.T) {
+	now := time.Now()
+	day := func(offset int) string {
+		return now.AddDate(0, 0, offset).Format("2006-01-02")
+	}
+
+	tests := []struct {
+		name     string
+		announce bool
+		event    *calendar.Event
+		expect   []string
+	}{
+		{"skipped by default", false, testAllDayEvent("birthday", day(0), day(1)), nil},
+		{"announced today", true, testAllDayEvent("birthday", day(0), day(1)), []string{"Today: birthday"}},
+		{"announced during several days", true, testAllDayEvent("vacation", day(-1), day(2)), []string{"Today: vacation"}},
+		{"tomorrow", true, testAllDayEvent("birthday", day(1), day(2)), nil},
+		{"ended yesterday", true, testAllDayEvent("birthday", day(-1), day(0)), nil},
+		{"no end", true, func() *calendar.Event {
+			event := testAllDayEvent("birthday", day(0), day(1))
+			event.End = nil
+			return event
+		}(), nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cal := &fakeCalendar{events: []*calendar.Event{test.event}}
+			rec := &recordingNotifier{}
+			b := newTestBot()
+			b.AnnounceAllDay = test.announce
+			b.Notifier = rec
+
+			// The second run must not announce the event again
+			for i := 0; i < 2; i++ {
+				if err := b.NotifyIndividualEvents(cal.context(), time.Now(), time.Hour); err != nil {
+					t.Fatalf("NotifyIndividualEvents failed: %s", err)
+				}
+			}
+			var texts []string
+			for _, n := range rec.notifications {
+				texts = append(texts, n.Text)
+			}
+			if !reflect.DeepEqual(texts, test.expect) {
+				t.Errorf("expected %q, got %q", test.expect, texts)
+			}
+		})
+	}
+}
+
+func TestDebugCache(t *testing.T) {
+	ctx := context.Background()
+	b := New()
+
+	before := time.Now()
+	b.Cache.Add(ctx, "a", []byte{0x1}, time.Hour)
+	b.Cache.Add(ctx, "b", []byte{0x1}, 15*time.Minute)
+	b.Cache.Add(ctx, "expired", []byte{0x1}, -time.Minute)
+	after := time.Now()
+
+	entries, err := b.DebugCache(ctx)
+	if err != nil {
+		t.Fatalf("DebugCache failed: %s", err)
+	}
+	ttls := map[string]time.Duration{"a": time.Hour, "b": 15 * time.Minute}
+	if len(entries) != len(ttls) {
+		t.Errorf("expected %d entries, got %v", len(ttls), entries)
+	}
+	for key, ttl := range ttls {
+		expires, ok := entries[key]
+		if !ok {
+			t.Errorf("expected an entry for %s", key)
+			continue
+		}
+		if expires.Before(before.Add(ttl)) || expires.After(after.Add(ttl)) {
+			t.Errorf("%s: expected expiry in %s, got %s", key, ttl, expires)
+		}
+	}
+
+	b.Cache = newMapCache()
+	if _, err := b.DebugCache(ctx); err != ErrCacheNotInspectable {
+		t.Errorf("expected ErrCacheNotInspectable, got %v", err)
+	}
+}
+
+func TestReminderLead(t *testing.T) {
+	reminders := func(methodMinutes ...interface{}) *calendar.CalendarListEntry {
+		entry := &calendar.CalendarListEntry{Id: "primary"}
+		for i := 0; i < len(methodMinutes); i += 2 {
+			entry.DefaultReminders = append(entry.DefaultReminders, &calendar.EventReminder{
+				Method:  methodMinutes[i].(string),
+				Minutes: int64(methodMinutes[i+1].(int)),
+			})
+		}
+		return entry
+	}
+
+	tests := []struct {
+		name     string
+		lead     time.Duration
+		delta    time.Duration
+		entry    *calendar.CalendarListEntry
+		expect   time.Duration
+		requests int
+	}{
+		{"explicit delta", 0, 5 * time.Minute, reminders("popup", 30), 5 * time.Minute, 1},
+		{"explicit lead", 20 * time.Minute, 0, reminders("popup", 30), 20 * time.Minute, 1},
+		{"popup default", 0, 0, reminders("email", 60, "popup", 30), 30 * time.Minute, 2},
+		{"email default", 0, 0, reminders("email", 60), time.Hour, 2},
+		{"no defaults", 0, 0, reminders(), defaultReminderLead, 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cal := &fakeCalendar{calendarList: test.entry}
+			b := newTestBot()
+			b.ReminderLead = test.lead
+
+			now := time.Now().UTC().Truncate(time.Second)
+			if err := b.NotifyIndividualEvents(cal.context(), now, test.delta); err != nil {
+				t.Fatalf("NotifyIndividualEvents failed: %s", err)
+			}
+			if len(cal.requests) != test.requests {
+				t.Fatalf("expected %d requests, got %d", test.requests, len(cal.requests))
+			}
+			list := cal.requests[len(cal.requests)-1]
+			expect := now.Add(test.expect).Format(time.RFC3339)
+			if got := list.URL.Query().Get("timeMax"); got != expect {
+				t.Errorf("expected timeMax %s, got %s", expect, got)
+			}
+		})
+	}
+}
+
+func TestStartTolerance(t *testing.T) {
+	tests := []struct {
+		name      string
+		offset    time.Duration
+		tolerance time.Duration
+		notify    bool
+	}{
+		{"ahead", 5 * time.Second, 10 * time.Second, true},
+		{"just started", -5 * time.Second, 10 * time.Second, true},
+		{"started too long ago", -30 * time.Second, 10 * time.Second, false},
+		{"no tolerance", -5 * time.Second, 0, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			start := time.Now().Add(test.offset).UTC()
+			cal := &fakeCalendar{events: []*calendar.Event{
+				testEvent("a", start.Format(time.RFC3339Nano), start.Add(time.Hour).Format(time.RFC3339Nano)),
+			}}
+			rec := &recordingNotifier{}
+			b := newTestBot()
+			b.Notifier = rec
+			b.StartTolerance = test.tolerance
+
+			if err := b.NotifyIndividualEvents(cal.context(), time.Now(), time.Hour); err != nil {
+				t.Fatalf("NotifyIndividualEvents failed: %s", err)
+			}
+			if notified := len(rec.notifications) == 1; notified != test.notify {
+				t.Errorf("expected notify to be %t", test.notify)
+			}
+			// Either way, the event is not looked at again
+			if _, ok := b.Cache.(*mapCache).data["a"]; !ok {
+				t.Errorf("expected the event to be cached")
+			}
+		})
+	}
+
+	if b := New(); b.StartTolerance != 10*time.Second {
+		t.Errorf("expected a default tolerance of 10s, got %s", b.StartTolerance)
+	}
+}
+
+func TestPause(t *testing.T) {
+	start := time.Now().Add(10 * time.Minute).UTC().Truncate(time.Second)
+	cal := &fakeCalendar{events: []*calendar.Event{
+		testEvent("a", start.Format(time.RFC3339), start.Add(time.Hour).Format(time.RFC3339)),
+	}}
+	slackAPI := &fakeSlack{}
+	b := newTestBot()
+	b.SlackTransport = slackAPI.transport
+	ctx := cal.context()
+
+	notifiers := map[string]func() error{
+		"individual":    func() error { return b.NotifyIndividualEvents(ctx, time.Now(), time.Hour) },
+		"upcoming":      func() error { return b.NotifyUpcomingEvents(ctx, time.Now(), time.Hour) },
+		"invitations":   func() error { return b.NotifyNewInvitations(ctx, time.Now()) },
+		"rescheduled":   func() error { return b.NotifyRescheduledEvents(ctx, time.Now(), time.Hour) },
+		"room conflict": func() error { return b.NotifyRoomConflicts(ctx, time.Now(), time.Hour) },
+		"rsvp":          func() error { return b.NotifyPendingResponses(ctx, time.Now(), time.Hour) },
+	}
+
+	b.Pause()
+	if !b.Paused() {
+		t.Fatalf("expected the bot to be paused")
+	}
+	for name, notify := range notifiers {
+		if err := notify(); !IsSuppressed(err) {
+			t.Errorf("%s: expected a suppressed error, got %v", name, err)
+		}
+	}
+	if len(cal.requests) != 0 || len(slackAPI.calls) != 0 {
+		t.Errorf("expected no requests while paused, got %d/%d", len(cal.requests), len(slackAPI.calls))
+	}
+
+	b.Resume()
+	if b.Paused() {
+		t.Fatalf("expected the bot to be resumed")
+	}
+	if err := notifiers["upcoming"](); err != nil {
+		t.Fatalf("NotifyUpcomingEvents failed: %s", err)
+	}
+	if err := notifiers["individual"](); err != nil {
+		t.Fatalf("NotifyIndividualEvents failed: %s", err)
+	}
+	if posts := slackAPI.posts(); len(posts) != 2 {
+		t.Errorf("expected 2 posts after resuming, got %d", len(posts))
+	}
+}
+
+func TestPauseConcurrent(t *testing.T) {
+	b := New()
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 1000; i++ {
+			b.Pause()
+			b.Resume()
+		}
+		close(done)
+	}()
+	for i := 0; i < 1000; i++ {
+		b.Paused()
+	}
+	<-done
+}
+
+// flakyCache fails the first `failures` Gets before delegating to
+// EventCache
+type flakyCache struct {
+	EventCache
+	failures int
+	gets     int
+}
+
+func (c *flakyCache) Get(ctx context.Context, key string) (interface{}, error) {
+	c.gets++
+	if c.gets <= c.failures {
+		return nil, errors.New("connection reset")
+	}
+	return c.EventCache.Get(ctx, key)
+}
+
+func TestSeenRetry(t *testing.T) {
+	defer func(d time.Duration) { cacheRetryBackoff = d }(cacheRetryBackoff)
+	cacheRetryBackoff = time.Millisecond
+
+	tests := []struct {
+		name     string
+		failures int
+		ignore   bool
+		cached   bool
+		expect   bool
+		fail     bool
+		gets     int
+	}{
+		{name: "transient miss", failures: 2, expect: false, gets: 3},
+		{name: "transient hit", failures: 1, cached: true, expect: true, gets: 2},
+		{name: "persistent", failures: 10, fail: true, gets: cacheGetAttempts},
+		{name: "persistent ignored", failures: 10, ignore: true, expect: false, gets: cacheGetAttempts},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			cache := &flakyCache{EventCache: newMapCache(), failures: test.failures}
+			if test.cached {
+				cache.Add(ctx, "key", []byte{0x1}, time.Hour)
+			}
+			logger := &recordingLogger{}
+			b := New()
+			b.Cache = cache
+			b.IgnoreCacheErrors = test.ignore
+			b.Logger = logger
+
+			ok, err := b.seen(ctx, "key")
+			if test.fail {
+				if err == nil {
+					t.Errorf("expected an error")
+				}
+			} else if err != nil {
+				t.Errorf("expected no error, got %s", err)
+			}
+			if ok != test.expect {
+				t.Errorf("expected %t, got %t", test.expect, ok)
+			}
+			if cache.gets != test.gets {
+				t.Errorf("expected %d gets, got %d", test.gets, cache.gets)
+			}
+			if test.ignore && !strings.HasPrefix(logger.messages[len(logger.messages)-1], "WARNING cache is failing") {
+				t.Errorf("expected a warning, got %q", logger.messages)
+			}
+		})
+	}
+}
+
+func TestIgnoreCacheErrors(t *testing.T) {
+	defer func(d time.Duration) { cacheRetryBackoff = d }(cacheRetryBackoff)
+	cacheRetryBackoff = time.Millisecond
+
+	start := time.Now().Add(10 * time.Minute).UTC().Truncate(time.Second)
+	for _, ignore := range []bool{false, true} {
+		t.Run(fmt.Sprintf("ignore %t", ignore), func(t *testing.T) {
+			cal := &fakeCalendar{events: []*calendar.Event{
+				testEvent("a", start.Format(time.RFC3339), start.Add(time.Hour).Format(time.RFC3339)),
+			}}
+			rec := &recordingNotifier{}
+			b := newTestBot()
+			b.Cache = &flakyCache{EventCache: b.Cache, failures: 100}
+			b.IgnoreCacheErrors = ignore
+			b.Notifier = rec
+
+			err := b.NotifyIndividualEvents(cal.context(), time.Now(), time.Hour)
+			if ignore {
+				if err != nil || len(rec.notifications) != 1 {
+					t.Errorf("expected the reminder to be sent, got %d (%v)", len(rec.notifications), err)
+				}
+			} else if err == nil || len(rec.notifications) != 0 {
+				t.Errorf("expected the run to fail, got %d (%v)", len(rec.notifications), err)
+			}
+		})
+	}
+}
+
+func TestTestPost(t *testing.T) {
+	slackAPI := &fakeSlack{}
+	b := newTestBot()
+	b.SlackTransport = slackAPI.transport
+
+	if err := b.TestPost(context.Background(), "Hello from calendarbot"); err != nil {
+		t.Fatalf("TestPost failed: %s", err)
+	}
+	posts := slackAPI.posts()
+	if len(posts) != 1 {
+		t.Fatalf("expected 1 post, got %d", len(posts))
+	}
+	form := posts[0].Form
+	if form.Get("channel") != "C024BE91L" || form.Get("text") != "Hello from calendarbot" || form.Get("username") != "calendarbot" {
+		t.Errorf("unexpected post %v", form)
+	}
+
+	slackAPI.postError = "not_in_channel"
+	err := b.TestPost(context.Background(), "Hello again")
+	if err == nil || !strings.Contains(err.Error(), "not_in_channel") {
+		t.Errorf("expected the slack error, got %v", err)
+	}
+
+	b.SlackChannel = "missing"
+	err = b.TestPost(context.Background(), "Hello again")
+	if err == nil || !strings.Contains(err.Error(), "failed to find channel ID") {
+		t.Errorf("expected a channel error, got %v", err)
+	}
+}
+
+// fakePoster is a SlackPoster that records the messages posted to it
+type fakePoster struct {
+	fakeChannelLister
+	posts []fakePost
+}
+
+type fakePost struct {
+	channel     string
+	text        string
+	attachments []slack.Attachment
+}
+
+func (p *fakePoster) AuthTest() (*slack.AuthTestResponse, error) {
+	return &slack.AuthTestResponse{}, nil
+}
+
+func (p *fakePoster) PostMessage(channel, text string, params slack.PostMessageParameters) (string, string, error) {
+	p.posts = append(p.posts, fakePost{channel, text, params.Attachments})
+	return channel, fmt.Sprintf("1484000000.%06d", len(p.posts)), nil
+}
+
+func TestSlackClientFactory(t *testing.T) {
+	poster := &fakePoster{fakeChannelLister: fakeChannelLister{channels: map[string]string{"general": "C024BE91L"}}}
+	logger := &recordingLogger{}
+	b := newTestBot()
+	b.AgendaReaction = "white_check_mark"
+	b.Logger = logger
+	var token string
+	b.SlackClientFactory = func(_ context.Context, t string) SlackPoster {
+		token = t
+		return poster
+	}
+	b.SlackToken = "xoxb-test"
+
+	now := time.Now().UTC().Truncate(time.Second)
+	cal := &fakeCalendar{events: []*calendar.Event{
+		testEvent("a", now.Add(5*time.Minute).Format(time.RFC3339), now.Add(time.Hour).Format(time.RFC3339)),
+	}}
+	if err := b.NotifyIndividualEvents(cal.context(), now, 10*time.Minute); err != nil {
+		t.Fatalf("NotifyIndividualEvents failed: %s", err)
+	}
+	if err := b.NotifyUpcomingEvents(cal.context(), now, 12*time.Hour); err != nil {
+		t.Fatalf("NotifyUpcomingEvents failed: %s", err)
+	}
+
+	if token != "xoxb-test" {
+		t.Errorf("expected the factory to get the slack token, got %q", token)
+	}
+	if len(poster.posts) != 2 {
+		t.Fatalf("expected 2 posts, got %d", len(poster.posts))
+	}
+	for _, post := range poster.posts {
+		if post.channel != "C024BE91L" {
+			t.Errorf("unexpected post %+v", post)
+		}
+	}
+	reminder := poster.posts[0].attachments
+	if len(reminder) != 1 || reminder[0].Title != "a" || !strings.Contains(reminder[0].TitleLink, "eid=a") {
+		t.Errorf("unexpected reminder attachments %+v", reminder)
+	}
+	if agenda := poster.posts[1].attachments; len(agenda) != 1 || len(agenda[0].Fields) != 1 || !strings.Contains(agenda[0].Fields[0].Value, "eid=a|a") {
+		t.Errorf("unexpected agenda attachments %+v", agenda)
+	}
+
+	// The fake can't add reactions
+	expect := "WARNING slack client can't add reactions, not adding white_check_mark"
+	if len(logger.messages) == 0 || logger.messages[len(logger.messages)-1] != expect {
+		t.Errorf("expected %q, got %q", expect, logger.messages)
+	}
+}
+
+func TestCalendarServiceFactory(t *testing.T) {
+	cal := &fakeCalendar{events: []*calendar.Event{
+		testEvent("a", "2017-01-10T09:00:00Z", "2017-01-10T10:00:00Z"),
+		testEvent("b", "2017-01-10T11:00:00Z", "2017-01-10T12:00:00Z"),
+	}}
+	slackAPI := &fakeSlack{}
+	b := New()
+	b.Cache = newMapCache()
+	b.CalendarServiceFactory = func(context.Context) (*calendar.Service, error) {
+		return calendar.New(&http.Client{Transport: cal})
+	}
+	b.SlackChannel = "general"
+	b.SlackTransport = slackAPI.transport
+
+	// No OAuth2 configuration is needed
+	start := mustParseTime(t, "2017-01-10T08:00:00Z")
+	if err := b.NotifyUpcomingEvents(context.Background(), start, 12*time.Hour); err != nil {
+		t.Fatalf("NotifyUpcomingEvents failed: %s", err)
+	}
+	if len(cal.requests) != 1 {
+		t.Errorf("expected 1 calendar request, got %d", len(cal.requests))
+	}
+
+	posts := slackAPI.posts()
+	if len(posts) != 1 {
+		t.Fatalf("expected 1 post, got %d", len(posts))
+	}
+	var attachments []slack.Attachment
+	if err := json.Unmarshal([]byte(posts[0].Form.Get("attachments")), &attachments); err != nil {
+		t.Fatalf("failed to decode attachments: %s", err)
+	}
+	if len(attachments) != 1 {
+		t.Fatalf("expected 1 attachment, got %d", len(attachments))
+	}
+	var values []string
+	for _, f := range attachments[0].Fields {
+		values = append(values, f.Value)
+	}
+	expect := []string{
+		"09:00-10:00: <https://calendar.google.com/event?eid=a|a>",
+		"11:00-12:00: <https://calendar.google.com/event?eid=b|b>",
+	}
+	if !reflect.DeepEqual(values, expect) {
+		t.Errorf("expected %q, got %q", expect, values)
+	}
+	if title := "Upcoming events between 2017 Jan 10 08:00 to 2017 Jan 10 20:00"; attachments[0].Title != title {
+		t.Errorf("expected title %q, got %q", title, attachments[0].Title)
+	}
+}
+
+func TestEventFields(t *testing.T) {
+	start := mustParseTime(t, "2017-01-10T08:00:00Z")
+	event := testEvent("a", "2017-01-10T09:00:00Z", "2017-01-10T10:00:00Z")
+	event.ColorId = "11"
+	event.Visibility = "private"
+	event.Attendees = []*calendar.EventAttendee{
+		{Email: "alice@example.com", DisplayName: "Alice", Comment: "running late"},
+	}
+
+	tests := []struct {
+		name       string
+		fields     string
+		visibility string
+		comment    string
+	}{
+		{"default", DefaultEventFields, "", ""},
+		{"everything", "", "private", "running late"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cal := &fakeCalendar{events: []*calendar.Event{event}}
+			b := newTestBot()
+			b.EventFields = test.fields
+			b.ColorEmoji = map[string]string{"11": ":red_circle:"}
+
+			var got []*calendar.Event
+			b.Notifier = NotifierFunc(func(_ context.Context, n *Notification) error {
+				got = n.Events
+				return nil
+			})
+			if err := b.NotifyUpcomingEvents(cal.context(), start, 12*time.Hour); err != nil {
+				t.Fatalf("NotifyUpcomingEvents failed: %s", err)
+			}
+			if q := cal.requests[0].URL.Query(); q.Get("fields") != test.fields {
+				t.Errorf("expected fields %q, got %q", test.fields, q.Get("fields"))
+			}
+
+			// Unused fields are left out, and the rest still renders
+			if len(got) != 1 || got[0].Visibility != test.visibility || got[0].Attendees[0].Comment != test.comment {
+				t.Fatalf("unexpected events %#v", got)
+			}
+			fields, err := b.agendaFields(got)
+			if err != nil {
+				t.Fatalf("agendaFields failed: %s", err)
+			}
+			expect := ":red_circle: 09:00-10:00: <https://calendar.google.com/event?eid=a|a>"
+			if len(fields) != 1 || fields[0].Value != expect {
+				t.Errorf("expected %q, got %#v", expect, fields)
+			}
+			a := b.reminderAttachment(got[0], mustParseTime(t, "2017-01-10T09:00:00Z"))
+			if f, _ := attachmentField(a, "Attendees"); f.Value != "Alice" {
+				t.Errorf("expected attendees to render, got %q", f.Value)
+			}
+		})
+	}
+}
+
+func TestSelfTest(t *testing.T) {
+	tests := []struct {
+		name   string
+		errors map[string]string
+		expect *MissingScopeError
+	}{
+		{"ok", nil, nil},
+		{"channels", map[string]string{"channels.list": "missing_scope"}, &MissingScopeError{Method: "channels.list", Scope: "channels:read"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			slackAPI := &fakeSlack{errors: test.errors}
+			b := newTestBot()
+			b.SlackTransport = slackAPI.transport
+
+			err := b.SelfTest(context.Background())
+			if test.expect == nil {
+				if err != nil {
+					t.Fatalf("SelfTest failed: %s", err)
+				}
+			} else if got, ok := errors.Cause(err).(*MissingScopeError); !ok || !reflect.DeepEqual(got, test.expect) {
+				t.Fatalf("expected %#v, got %#v", test.expect, err)
+			}
+			if posts := slackAPI.posts(); len(posts) != 0 {
+				t.Errorf("expected nothing to be posted, got %v", posts)
+			}
+		})
+	}
+}
+
+func TestMissingScope(t *testing.T) {
+	tests := []struct {
+		name    string
+		channel string // groups are only listed if not found in channels.list
+		errors  map[string]string
+		expect  error
+	}{
+		{"post", "general", map[string]string{"chat.postMessage": "missing_scope"}, &MissingScopeError{Method: "chat.postMessage", Scope: "chat:write"}},
+		{"groups", "private", map[string]string{"groups.list": "missing_scope"}, &MissingScopeError{Method: "groups.list", Scope: "groups:read"}},
+		{"other", "general", map[string]string{"chat.postMessage": "channel_not_found"}, fmt.Errorf("channel_not_found")},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			slackAPI := &fakeSlack{errors: test.errors}
+			b := newTestBot()
+			b.SlackChannel = test.channel
+			b.SlackTransport = slackAPI.transport
+
+			err := b.TestPost(context.Background(), "hello")
+			if got := errors.Cause(err); !reflect.DeepEqual(got, test.expect) {
+				t.Errorf("expected %#v, got %#v", test.expect, got)
+			}
+		})
+	}
+}
+
+func TestArchivedChannel(t *testing.T) {
+	tests := []struct {
+		name     string
+		fallback string
+		enabled  bool
+		expect   []string // Channels posted to
+		warnings int
+	}{
+		{"no fallback", "", true, []string{"C024BE91L"}, 0},
+		{"disabled", "G024BE92M", false, []string{"C024BE91L"}, 0},
+		{"fallback", "G024BE92M", true, []string{"C024BE91L", "G024BE92M"}, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, kind := range []string{"channel", "reminder"} {
+				slackAPI := &fakeSlack{archived: map[string]bool{"C024BE91L": true}}
+				logger := &recordingLogger{}
+				b := newTestBot()
+				b.FallbackSlackChannel = test.fallback
+				b.FallbackOnArchived = test.enabled
+				b.Logger = logger
+				b.SlackTransport = slackAPI.transport
+
+				var err error
+				if kind == "channel" {
+					err = b.TestPost(context.Background(), "hello")
+				} else {
+					params := b.slackParams("")
+					err = b.postReminder(context.Background(), testEvent("a", "2017-01-10T09:00:00Z", "2017-01-10T10:00:00Z"), "hello", &params, nil)
+				}
+
+				if len(test.expect) == 1 {
+					archived, ok := errors.Cause(err).(*ArchivedChannelError)
+					if !ok || archived.Channel != "C024BE91L" {
+						t.Errorf("%s: expected an ArchivedChannelError, got %#v", kind, err)
+					}
+					if err != nil && !strings.Contains(err.Error(), "slack channel C024BE91L is archived") {
+						t.Errorf("%s: expected a clear message, got %q", kind, err)
+					}
+				} else if err != nil {
+					t.Errorf("%s: expected the fallback to succeed, got %s", kind, err)
+				}
+
+				var channels []string
+				for _, post := range slackAPI.posts() {
+					channels = append(channels, post.Form.Get("channel"))
+				}
+				if !reflect.DeepEqual(channels, test.expect) {
+					t.Errorf("%s: expected posts to %q, got %q", kind, test.expect, channels)
+				}
+				if len(logger.messages) != test.warnings {
+					t.Errorf("%s: expected %d warnings, got %q", kind, test.warnings, logger.messages)
+				}
+			}
+		})
+	}
+}
+
+func TestSlackIdentities(t *testing.T) {
+	start := time.Now().Add(10 * time.Minute).UTC()
+	event := func(id string) *calendar.Event {
+		return testEvent(id, start.Format(time.RFC3339), start.Add(time.Hour).Format(time.RFC3339))
+	}
+	identities := map[string]SlackIdentity{
+		"primary":          {Username: "my-calendar", IconEmoji: ":date:"},
+		"team@example.com": {Username: "team-calendar"},
+	}
+
+	tests := []struct {
+		name      string
+		calendars []string
+		notify    func(*Bot, context.Context) error
+		username  string
+		icon      string
+	}{
+		{"reminder", nil, func(b *Bot, ctx context.Context) error {
+			return b.NotifyIndividualEvents(ctx, time.Now(), time.Hour)
+		}, "my-calendar", ":date:"},
+		{"agenda", []string{"team@example.com"}, func(b *Bot, ctx context.Context) error {
+			return b.NotifyUpcomingEvents(ctx, time.Now(), time.Hour)
+		}, "team-calendar", ":calendar:"},
+		{"unknown calendar", []string{"other@example.com"}, func(b *Bot, ctx context.Context) error {
+			return b.NotifyUpcomingEvents(ctx, time.Now(), time.Hour)
+		}, "calendarbot", ":calendar:"},
+		{"several calendars", []string{"primary", "team@example.com"}, func(b *Bot, ctx context.Context) error {
+			return b.NotifyUpcomingEvents(ctx, time.Now(), time.Hour)
+		}, "calendarbot", ":calendar:"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cal := &fakeCalendar{calendars: map[string][]*calendar.Event{
+				"primary":           {event("a")},
+				"team@example.com":  {event("b")},
+				"other@example.com": {event("c")},
+			}}
+			slackAPI := &fakeSlack{}
+			b := newTestBot()
+			b.Calendars = test.calendars
+			b.SlackIconEmoji = ":calendar:"
+			b.SlackIdentities = identities
+			b.SlackTransport = slackAPI.transport
+
+			if err := test.notify(b, cal.context()); err != nil {
+				t.Fatalf("notify failed: %s", err)
+			}
+			posts := slackAPI.posts()
+			if len(posts) != 1 {
+				t.Fatalf("expected 1 post, got %d", len(posts))
+			}
+			if got := posts[0].Form.Get("username"); got != test.username {
+				t.Errorf("expected username %q, got %q", test.username, got)
+			}
+			if got := posts[0].Form.Get("icon_emoji"); got != test.icon {
+				t.Errorf("expected icon %q, got %q", test.icon, got)
+			}
+		})
+	}
+}
+
+func TestAgendaReaction(t *testing.T) {
+	start := mustParseTime(t, "2017-01-10T08:00:00Z")
+	tests := []struct {
+		name     string
+		reaction string
+		errors   map[string]string
+		expect   []string // reactions.add name, channel and timestamp
+		warned   bool
+	}{
+		{"none", "", nil, nil, false},
+		{"name", "white_check_mark", nil, []string{"white_check_mark", "C024BE91L"}, false},
+		{"colons", ":white_check_mark:", nil, []string{"white_check_mark", "C024BE91L"}, false},
+		{"failing", "white_check_mark", map[string]string{"reactions.add": "missing_scope"}, []string{"white_check_mark", "C024BE91L"}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cal := &fakeCalendar{events: []*calendar.Event{
+				testEvent("a", "2017-01-10T09:00:00Z", "2017-01-10T10:00:00Z"),
+			}}
+			slackAPI := &fakeSlack{errors: test.errors}
+			logger := &recordingLogger{}
+			b := newTestBot()
+			b.AgendaReaction = test.reaction
+			b.Logger = logger
+			b.SlackTransport = slackAPI.transport
+
+			if err := b.NotifyUpcomingEvents(cal.context(), start, 12*time.Hour); err != nil {
+				t.Fatalf("NotifyUpcomingEvents failed: %s", err)
+			}
+
+			// The fake numbers messages by the calls made so far
+			var ts string
+			var got []string
+			for i, call := range slackAPI.calls {
+				switch call.Method {
+				case "chat.postMessage":
+					ts = fmt.Sprintf("1484000000.%06d", i+1)
+				case "reactions.add":
+					got = []string{call.Form.Get("name"), call.Form.Get("channel"), call.Form.Get("timestamp")}
+				}
+			}
+			var expect []string
+			if test.expect != nil {
+				expect = append(test.expect, ts)
+			}
+			if !reflect.DeepEqual(got, expect) {
+				t.Errorf("expected reaction %q, got %q", expect, got)
+			}
+			if warned := len(logger.messages) > 0 && strings.HasPrefix(logger.messages[len(logger.messages)-1], "WARNING failed to add reaction"); warned != test.warned {
+				t.Errorf("expected warning %t, got %q", test.warned, logger.messages)
+			}
+		})
+	}
+}
+
+func TestShowHiddenInvitations(t *testing.T) {
+	start := mustParseTime(t, "2017-01-10T08:00:00Z")
+	pending := testEvent("a", "2017-01-10T09:00:00Z", "2017-01-10T10:00:00Z")
+	pending.Attendees = []*calendar.EventAttendee{
+		{Email: "bob@example.com", ResponseStatus: "accepted"},
+		{Email: "me@example.com", Self: true, ResponseStatus: "needsAction"},
+	}
+	accepted := testEvent("b", "2017-01-10T11:00:00Z", "2017-01-10T12:00:00Z")
+	accepted.Attendees = []*calendar.EventAttendee{
+		{Email: "me@example.com", Self: true, ResponseStatus: "accepted"},
+	}
+
+	tests := []struct {
+		name   string
+		show   bool
+		param  string
+		expect []string
+	}{
+		{"disabled", false, "", []string{
+			"09:00-10:00: <https://calendar.google.com/event?eid=a|a>",
+			"11:00-12:00: <https://calendar.google.com/event?eid=b|b>",
+		}},
+		{"enabled", true, "true", []string{
+			"09:00-10:00: <https://calendar.google.com/event?eid=a|a> _(pending response)_",
+			"11:00-12:00: <https://calendar.google.com/event?eid=b|b>",
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cal := &fakeCalendar{events: []*calendar.Event{pending, accepted}}
+			b := newTestBot()
+			b.ShowHiddenInvitations = test.show
+
+			n, err := b.upcomingAgenda(cal.context(), start, 12*time.Hour)
+			if err != nil {
+				t.Fatalf("upcomingAgenda failed: %s", err)
+			}
+			if got := cal.requests[0].URL.Query().Get("showHiddenInvitations"); got != test.param {
+				t.Errorf("expected showHiddenInvitations %q, got %q", test.param, got)
+			}
+
+			fields, err := b.agendaFields(n.Events)
+			if err != nil {
+				t.Fatalf("agendaFields failed: %s", err)
+			}
+			var lines []string
+			for _, f := range fields {
+				lines = append(lines, f.Value)
+			}
+			if !reflect.DeepEqual(lines, test.expect) {
+				t.Errorf("expected %q, got %q", test.expect, lines)
+			}
+		})
+	}
+}
+
+func TestAgendaOrder(t *testing.T) {
+	start := mustParseTime(t, "2017-01-10T08:00:00Z")
+	tests := []struct {
+		name         string
+		orderBy      string
+		collapse     bool
+		expectOrder  string
+		expectSingle string
+		expectErr    string
+	}{
+		{"default", "", false, "startTime", "true", ""},
+		{"start time", "startTime", false, "startTime", "true", ""},
+		{"updated", "updated", false, "updated", "true", ""},
+		{"collapsed", "", true, "updated", "false", ""},
+		{"collapsed updated", "updated", true, "updated", "false", ""},
+		{"collapsed start time", "startTime", true, "", "", `OrderBy "startTime" requires expanding recurring events`},
+		{"unknown", "summary", false, "", "", `unsupported OrderBy "summary"`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// Listed in update order
+			cal := &fakeCalendar{events: []*calendar.Event{
+				testEvent("b", "2017-01-10T11:00:00Z", "2017-01-10T12:00:00Z"),
+				testEvent("a", "2017-01-10T09:00:00Z", "2017-01-10T10:00:00Z"),
+			}}
+			b := newTestBot()
+			b.OrderBy = test.orderBy
+			b.CollapseRecurring = test.collapse
+
+			n, err := b.upcomingAgenda(cal.context(), start, 12*time.Hour)
+			if test.expectErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.expectErr) {
+					t.Fatalf("expected error %q, got %v", test.expectErr, err)
+				}
+				if len(cal.requests) != 0 {
+					t.Errorf("expected no requests, got %d", len(cal.requests))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("upcomingAgenda failed: %s", err)
+			}
+
+			q := cal.requests[0].URL.Query()
+			if got := q.Get("orderBy"); got != test.expectOrder {
+				t.Errorf("expected orderBy %q, got %q", test.expectOrder, got)
+			}
+			if got := q.Get("singleEvents"); got != test.expectSingle {
+				t.Errorf("expected singleEvents %q, got %q", test.expectSingle, got)
+			}
+
+			// Events are sorted by start time whatever the order asked
+			// of Google
+			var ids []string
+			for _, event := range n.Events {
+				ids = append(ids, event.Id)
+			}
+			if expect := []string{"a", "b"}; !reflect.DeepEqual(ids, expect) {
+				t.Errorf("expected %q, got %q", expect, ids)
+			}
+		})
+	}
+}
+
+func TestAgendaChronological(t *testing.T) {
+	start := mustParseTime(t, "2017-01-10T00:00:00Z")
+	tests := []struct {
+		name      string
+		calendars []string
+		cal       *fakeCalendar
+		expect    []string
+	}{
+		{"single calendar", nil, &fakeCalendar{events: []*calendar.Event{
+			testEvent("c", "2017-01-10T15:00:00Z", "2017-01-10T16:00:00Z"),
+			testEvent("a", "2017-01-10T09:00:00Z", "2017-01-10T10:00:00Z"),
+			testEvent("b", "2017-01-10T11:00:00Z", "2017-01-10T12:00:00Z"),
+		}}, []string{"a", "b", "c"}},
+		{"merged calendars", []string{"work", "home"}, &fakeCalendar{calendars: map[string][]*calendar.Event{
+			"work": {
+				testEvent("a", "2017-01-10T09:00:00Z", "2017-01-10T10:00:00Z"),
+				testEvent("c", "2017-01-10T15:00:00Z", "2017-01-10T16:00:00Z"),
+			},
+			"home": {
+				testEvent("b", "2017-01-10T11:00:00Z", "2017-01-10T12:00:00Z"),
+			},
+		}}, []string{"a", "b", "c"}},
+		{"all day", nil, &fakeCalendar{events: []*calendar.Event{
+			testEvent("a", "2017-01-10T09:00:00Z", "2017-01-10T10:00:00Z"),
+			testAllDayEvent("holiday", "2017-01-10", "2017-01-11"),
+		}}, []string{"holiday_20170110", "a"}},
+		{"same start", nil, &fakeCalendar{events: []*calendar.Event{
+			testEvent("b", "2017-01-10T09:00:00Z", "2017-01-10T10:00:00Z"),
+			testEvent("c", "2017-01-10T11:00:00Z", "2017-01-10T12:00:00Z"),
+			testEvent("a", "2017-01-10T09:00:00Z", "2017-01-10T09:30:00Z"),
+		}}, []string{"b", "a", "c"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := newTestBot()
+			b.Calendars = test.calendars
+
+			n, err := b.upcomingAgenda(test.cal.context(), start, 24*time.Hour)
+			if err != nil {
+				t.Fatalf("upcomingAgenda failed: %s", err)
+			}
+			var ids []string
+			for _, event := range n.Events {
+				ids = append(ids, event.Id)
+			}
+			if !reflect.DeepEqual(ids, test.expect) {
+				t.Errorf("expected %q, got %q", test.expect, ids)
+			}
+		})
+	}
+}
+
+func TestMinPostInterval(t *testing.T) {
+	slackAPI := &fakeSlack{}
+	b := newTestBot()
+	b.MinPostInterval = time.Minute
+	b.SlackTransport = slackAPI.transport
+	ctx := context.Background()
+
+	if err := b.TestPost(ctx, "first"); err != nil {
+		t.Fatalf("TestPost failed: %s", err)
+	}
+	for i := 0; i < 5; i++ {
+		if err := b.TestPost(ctx, "flood"); !IsSuppressed(err) {
+			t.Fatalf("expected a suppressed error, got %v", err)
+		}
+	}
+	if posts := slackAPI.posts(); len(posts) != 1 {
+		t.Fatalf("expected 1 post, got %d", len(posts))
+	}
+
+	// Pretend the interval has passed
+	b.lastPost = b.lastPost.Add(-time.Minute)
+	if err := b.TestPost(ctx, "later"); err != nil {
+		t.Fatalf("TestPost failed: %s", err)
+	}
+	if posts := slackAPI.posts(); len(posts) != 2 {
+		t.Errorf("expected 2 posts, got %d", len(posts))
+	}
+}
+
+func TestReservePost(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name     string
+		interval time.Duration
+		times    []time.Duration // Offsets from now
+		expect   []bool          // Whether each post is allowed
+	}{
+		{"disabled", 0, []time.Duration{0, 0, time.Second}, []bool{true, true, true}},
+		{"rapid", time.Minute, []time.Duration{0, time.Second, 59 * time.Second}, []bool{true, false, false}},
+		{"spaced", time.Minute, []time.Duration{0, time.Minute, 2 * time.Minute}, []bool{true, true, true}},
+		{"dropped posts don't count", time.Minute, []time.Duration{0, 30 * time.Second, 60 * time.Second, 90 * time.Second}, []bool{true, false, true, false}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := New()
+			b.MinPostInterval = test.interval
+			var got []bool
+			for _, offset := range test.times {
+				got = append(got, b.reservePost(now.Add(offset)) == nil)
+			}
+			if !reflect.DeepEqual(got, test.expect) {
+				t.Errorf("expected %v, got %v", test.expect, got)
+			}
+		})
+	}
+}
+
+func TestIsCacheMiss(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		expect bool
+	}{
+		{"nil", nil, false},
+		{"miss", cacheMissError{}, true},
+		{"wrapped miss", errors.Wrap(cacheMissError{}, "failed to get key"), true},
+		{"custom miss", testCacheMiss{}, true},
+		{"failure", errors.New("connection refused"), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := IsCacheMiss(test.err); got != test.expect {
+				t.Errorf("expected %t, got %t", test.expect, got)
+			}
+		})
+	}
+}
+
+func TestMemoryCacheMiss(t *testing.T) {
+	ctx := context.Background()
+	c := NewMemoryCache(0)
+
+	if _, err := c.Get(ctx, "absent"); !IsCacheMiss(err) {
+		t.Errorf("expected a miss for an absent key, got %v", err)
+	}
+	if err := c.Add(ctx, "expired", nil, -time.Second); err != nil {
+		t.Fatalf("failed to add expired: %s", err)
+	}
+	if _, err := c.Get(ctx, "expired"); !IsCacheMiss(err) {
+		t.Errorf("expected a miss for an expired key, got %v", err)
+	}
+}
+
+func TestMemoryCacheAddExpired(t *testing.T) {
+	ctx := context.Background()
+	c := newMemoryCache(0, 0)
+
+	if err := c.Add(ctx, "a", []byte{0x1}, time.Millisecond); err != nil {
+		t.Fatalf("failed to add a: %s", err)
+	}
+	if err := c.Add(ctx, "a", []byte{0x2}, time.Minute); err == nil {
+		t.Errorf("expected adding an unexpired key to fail")
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	if err := c.Add(ctx, "a", []byte{0x2}, time.Minute); err != nil {
+		t.Fatalf("expected adding an expired key to succeed, got %s", err)
+	}
+	v, err := c.Get(ctx, "a")
+	if err != nil {
+		t.Fatalf("failed to get a: %s", err)
+	}
+	if got := v.(cacheEntry).Value; !reflect.DeepEqual(got, []byte{0x2}) {
+		t.Errorf("expected the new value, got %v", got)
+	}
+	if c.lru.Len() != 1 {
+		t.Errorf("expected 1 entry, got %d", c.lru.Len())
+	}
+}
+
+func TestMemoryCacheRemove(t *testing.T) {
+	ctx := context.Background()
+	c := newMemoryCache(0, 0)
+
+	if err := c.Add(ctx, "a", []byte{0x1}, time.Minute); err != nil {
+		t.Fatalf("failed to add a: %s", err)
+	}
+	if err := c.Remove(ctx, "a"); err != nil {
+		t.Fatalf("failed to remove a: %s", err)
+	}
+	if _, err := c.Get(ctx, "a"); !IsCacheMiss(err) {
+		t.Errorf("expected a miss for a removed key, got %v", err)
+	}
+	if c.lru.Len() != 0 {
+		t.Errorf("expected the LRU list to be empty, got %d entries", c.lru.Len())
+	}
+	if err := c.Add(ctx, "a", []byte{0x1}, time.Minute); err != nil {
+		t.Errorf("expected a removed key to be added again, got %s", err)
+	}
+	if err := c.Remove(ctx, "absent"); err != nil {
+		t.Errorf("expected removing an absent key to succeed, got %s", err)
+	}
+}
+
+func TestNotifyIndividualEventsMemoryCache(t *testing.T) {
+	start := time.Now().Add(10 * time.Minute).UTC()
+	cal := &fakeCalendar{events: []*calendar.Event{
+		testEvent("a", start.Format(time.RFC3339), start.Add(time.Hour).Format(time.RFC3339)),
+	}}
+	slackAPI := &fakeSlack{}
+	b := newTestBot()
+	b.Cache = NewMemoryCache(0)
+	b.SlackTransport = slackAPI.transport
+
+	// The reminder is sent once, not on every run
+	for i := 0; i < 3; i++ {
+		if err := b.NotifyIndividualEvents(cal.context(), time.Now(), time.Hour); err != nil {
+			t.Fatalf("run %d: NotifyIndividualEvents failed: %s", i, err)
+		}
+	}
+	if posts := slackAPI.posts(); len(posts) != 1 {
+		t.Errorf("expected 1 post, got %d", len(posts))
+	}
+}
+
+func TestNotifyIndividualEventsCalendarName(t *testing.T) {
+	start := time.Now().Add(10 * time.Minute).UTC()
+	event := func(id string) *calendar.Event {
+		return testEvent(id, start.Format(time.RFC3339), start.Add(time.Hour).Format(time.RFC3339))
+	}
+
+	tests := []struct {
+		name     string
+		calendar string // Left as set by New if empty
+		path     string
+		expect   []string
+	}{
+		{"default", "", "/calendars/primary/events", []string{"mine"}},
+		{"team", "team@example.com", "/calendars/team@example.com/events", []string{"team"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cal := &fakeCalendar{
+				calendars: map[string][]*calendar.Event{
+					"primary":          {event("mine")},
+					"team@example.com": {event("team")},
+				},
+				calendarList: &calendar.CalendarListEntry{},
+			}
+			var got []string
+			b := newTestBot()
+			if test.calendar != "" {
+				b.CalendarName = test.calendar
+			}
+			b.Notifier = NotifierFunc(func(_ context.Context, n *Notification) error {
+				for _, event := range n.Events {
+					got = append(got, event.Id)
+				}
+				return nil
+			})
+
+			// A delta of 0 looks up the calendar's default reminders too
+			if err := b.NotifyIndividualEvents(cal.context(), time.Now(), 0); err != nil {
+				t.Fatalf("NotifyIndividualEvents failed: %s", err)
+			}
+			var paths []string
+			for _, r := range cal.requests {
+				paths = append(paths, r.URL.Path)
+			}
+			expectPaths := []string{
+				"/calendar/v3/users/me/calendarList/" + calendarID(b.CalendarName),
+				"/calendar/v3" + test.path,
+			}
+			if !reflect.DeepEqual(paths, expectPaths) {
+				t.Errorf("expected requests %q, got %q", expectPaths, paths)
+			}
+			if !reflect.DeepEqual(got, test.expect) {
+				t.Errorf("expected reminders for %q, got %q", test.expect, got)
+			}
+		})
+	}
+}
+
+func TestNotifyIndividualEventsMalformed(t *testing.T) {
+	start := time.Now().Add(10 * time.Minute).UTC()
+	broken := testEvent("broken", "soon", start.Add(time.Hour).Format(time.RFC3339))
+	today := time.Now().Format(allDayLayout)
+	cal := &fakeCalendar{events: []*calendar.Event{
+		broken,
+		testAllDayEvent("holiday", today, time.Now().Add(24*time.Hour).Format(allDayLayout)),
+		testEvent("a", start.Format(time.RFC3339), start.Add(time.Hour).Format(time.RFC3339)),
+	}}
+	logger := &recordingLogger{}
+	var got []string
+	b := newTestBot()
+	b.Logger = logger
+	b.Notifier = NotifierFunc(func(_ context.Context, n *Notification) error {
+		got = append(got, n.Events[0].Id)
+		return nil
+	})
+
+	if err := b.NotifyIndividualEvents(cal.context(), time.Now(), time.Hour); err != nil {
+		t.Fatalf("NotifyIndividualEvents failed: %s", err)
+	}
+	if expect := []string{"a"}; !reflect.DeepEqual(got, expect) {
+		t.Errorf("expected reminders for %q, got %q", expect, got)
+	}
+	var warnings []string
+	for _, msg := range logger.messages {
+		if strings.HasPrefix(msg, "WARNING ") {
+			warnings = append(warnings, msg)
+		}
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "skipping event broken") {
+		t.Errorf("expected a warning about the broken event, got %q", warnings)
+	}
+}
+
+func TestBatchReminders(t *testing.T) {
+	base := mustParseTime(t, "2017-01-10T09:00:00Z")
+	r := func(id string, offset time.Duration) reminder {
+		return reminder{event: &calendar.Event{Id: id}, start: base.Add(offset)}
+	}
+
+	tests := []struct {
+		name   string
+		window time.Duration
+		expect [][]string
+	}{
+		{"disabled", 0, [][]string{{"a"}, {"b"}, {"c"}, {"d"}}},
+		{"five minutes", 5 * time.Minute, [][]string{{"a", "b"}, {"c", "d"}}},
+		{"one hour", time.Hour, [][]string{{"a", "b", "c", "d"}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// Out of order, as events.list without orderBy may return them
+			reminders := []reminder{r("c", 10*time.Minute), r("a", 0), r("d", 14*time.Minute), r("b", 5*time.Minute)}
+			var got [][]string
+			for _, batch := range batchReminders(reminders, test.window) {
+				var ids []string
+				for _, r := range batch {
+					ids = append(ids, r.event.Id)
+				}
+				got = append(got, ids)
+			}
+			if !reflect.DeepEqual(got, test.expect) {
+				t.Errorf("expected %q, got %q", test.expect, got)
+			}
+		})
+	}
+}
+
+func TestBatchWindow(t *testing.T) {
+	start := time.Now().Add(10 * time.Minute).UTC().Truncate(time.Minute)
+	event := func(id string, offset time.Duration) *calendar.Event {
+		t := start.Add(offset)
+		return testEvent(id, t.Format(time.RFC3339), t.Add(time.Hour).Format(time.RFC3339))
+	}
+	cal := &fakeCalendar{events: []*calendar.Event{
+		event("a", 0),
+		event("b", 3*time.Minute),
+		event("c", 30*time.Minute),
+	}}
+	slackAPI := &fakeSlack{}
+	b := newTestBot()
+	b.BatchWindow = 5 * time.Minute
+	b.SlackTransport = slackAPI.transport
+
+	// The second run finds every event already reminded about
+	for i := 0; i < 2; i++ {
+		if err := b.NotifyIndividualEvents(cal.context(), time.Now(), time.Hour); err != nil {
+			t.Fatalf("NotifyIndividualEvents failed: %s", err)
+		}
+	}
+
+	type post struct {
+		Text   string
+		Titles []string
+	}
+	var got []post
+	for _, call := range slackAPI.posts() {
+		var attachments []slack.Attachment
+		if err := json.Unmarshal([]byte(call.Form.Get("attachments")), &attachments); err != nil {
+			t.Fatalf("failed to decode attachments: %s", err)
+		}
+		p := post{Text: call.Form.Get("text")}
+		// The exact lead depends on how long the test took
+		p.Text = regexp.MustCompile(`\d+ minutes`).ReplaceAllString(p.Text, "N minutes")
+		for _, a := range attachments {
+			p.Titles = append(p.Titles, a.Title)
+		}
+		got = append(got, p)
+	}
+	expect := []post{
+		{"2 events are starting soon", []string{"a", "b"}},
+		{"This event starts in N minutes", []string{"c"}},
+	}
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("expected %+v, got %+v", expect, got)
+	}
+}
+
+func TestBatchWindowThreadReminders(t *testing.T) {
+	start := time.Now().Add(10 * time.Minute).UTC().Truncate(time.Minute)
+	cal := &fakeCalendar{events: []*calendar.Event{
+		testEvent("a", start.Format(time.RFC3339), start.Add(time.Hour).Format(time.RFC3339)),
+		testEvent("b", start.Format(time.RFC3339), start.Add(time.Hour).Format(time.RFC3339)),
+	}}
+	slackAPI := &fakeSlack{}
+	b := newTestBot()
+	b.BatchWindow = 5 * time.Minute
+	b.ThreadReminders = true
+	b.SlackTransport = slackAPI.transport
+
+	if err := b.NotifyIndividualEvents(cal.context(), time.Now(), time.Hour); err != nil {
+		t.Fatalf("NotifyIndividualEvents failed: %s", err)
+	}
+
+	var titles []string
+	for _, call := range slackAPI.posts() {
+		if txt := call.Form.Get("text"); !strings.HasPrefix(txt, "This event starts in") {
+			t.Errorf("expected a reminder for a single event, got %q", txt)
+		}
+		var attachments []slack.Attachment
+		if err := json.Unmarshal([]byte(call.Form.Get("attachments")), &attachments); err != nil {
+			t.Fatalf("failed to decode attachments: %s", err)
+		}
+		for _, a := range attachments {
+			titles = append(titles, a.Title)
+		}
+	}
+	if expect := []string{"a", "b"}; !reflect.DeepEqual(titles, expect) || len(slackAPI.posts()) != 2 {
+		t.Errorf("expected a post for each of %q, got %d posts of %q", expect, len(slackAPI.posts()), titles)
+	}
+	for _, id := range []string{"a", "b"} {
+		if _, ok := b.Cache.(*mapCache).data["thread:"+id]; !ok {
+			t.Errorf("expected a thread for %s", id)
+		}
+	}
+}
+
+func TestDedupWindow(t *testing.T) {
+	now := time.Now().UTC()
+	event := func(id string, offset time.Duration) *calendar.Event {
+		t := now.Add(offset)
+		return testEvent(id, t.Format(time.RFC3339), t.Add(time.Hour).Format(time.RFC3339))
+	}
+
+	tests := []struct {
+		name   string
+		window time.Duration // Left as set by New if 0
+		skip   time.Duration // Left as set by New if -1
+		expect map[string]time.Duration
+	}{
+		{"default", 0, -1, map[string]time.Duration{"due": 15 * time.Minute, "started": 15 * time.Minute}},
+		{"custom", 2 * time.Hour, -1, map[string]time.Duration{"due": 2 * time.Hour, "started": 15 * time.Minute}},
+		{"custom skip", 0, time.Minute, map[string]time.Duration{"due": 15 * time.Minute, "started": time.Minute}},
+		{"skip not cached", 2 * time.Hour, 0, map[string]time.Duration{"due": 2 * time.Hour}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cal := &fakeCalendar{events: []*calendar.Event{
+				event("due", 10*time.Minute),
+				event("started", -10*time.Minute),
+			}}
+			b := newTestBot()
+			if test.window != 0 {
+				b.DedupWindow = test.window
+			}
+			if test.skip >= 0 {
+				b.SkipCacheTTL = test.skip
+			}
+			b.Notifier = NotifierFunc(func(context.Context, *Notification) error { return nil })
+			cache := b.Cache.(*mapCache)
+
+			if err := b.NotifyIndividualEvents(cal.context(), now.Add(-time.Hour), 2*time.Hour); err != nil {
+				t.Fatalf("NotifyIndividualEvents failed: %s", err)
+			}
+			if !reflect.DeepEqual(cache.ttls, test.expect) {
+				t.Errorf("expected TTLs %v, got %v", test.expect, cache.ttls)
+			}
+		})
+	}
+}
+
+func TestMaxLookahead(t *testing.T) {
+	start := mustParseTime(t, "2017-01-10T00:00:00Z")
+	tests := []struct {
+		name      string
+		max       time.Duration
+		delta     time.Duration
+		expectMax string
+		warned    bool
+	}{
+		{"no limit", 0, 365 * 24 * time.Hour, "2018-01-10T00:00:00Z", false},
+		{"within", 48 * time.Hour, 24 * time.Hour, "2017-01-11T00:00:00Z", false},
+		{"at limit", 48 * time.Hour, 48 * time.Hour, "2017-01-12T00:00:00Z", false},
+		{"over limit", 48 * time.Hour, 365 * 24 * time.Hour, "2017-01-12T00:00:00Z", true},
+	}
+	notify := map[string]func(*Bot, context.Context, time.Time, time.Duration) error{
+		"agenda":    (*Bot).NotifyUpcomingEvents,
+		"reminders": (*Bot).NotifyIndividualEvents,
+		"rsvp":      (*Bot).NotifyPendingResponses,
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for name, f := range notify {
+				cal := &fakeCalendar{}
+				logger := &recordingLogger{}
+				b := newTestBot()
+				b.Logger = logger
+				b.MaxLookahead = test.max
+
+				if err := f(b, cal.context(), start, test.delta); err != nil {
+					t.Fatalf("%s failed: %s", name, err)
+				}
+				if got := cal.requests[0].URL.Query().Get("timeMax"); got != test.expectMax {
+					t.Errorf("%s: expected timeMax %s, got %s", name, test.expectMax, got)
+				}
+				var warned bool
+				for _, msg := range logger.messages {
+					if strings.HasPrefix(msg, "WARNING looking 8760h0m0s ahead is more than MaxLookahead") {
+						warned = true
+					}
+				}
+				if warned != test.warned {
+					t.Errorf("%s: expected warning %t, got %q", name, test.warned, logger.messages)
+				}
+			}
+		})
+	}
+}
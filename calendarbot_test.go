@@ -8,7 +8,7 @@ import (
 	"golang.org/x/net/context"
 )
 
-func ExampleCalendarBot() {
+func ExampleBot() {
 	ctx := context.Background()
 
 	bot := calendarbot.New()
@@ -0,0 +1,34 @@
+package calendarbot
+
+import (
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/api/googleapi"
+)
+
+// retryableCalendarError reports whether err is Google failing in a way
+// that may go away when trying again: rate limiting or a server error
+func retryableCalendarError(err error) bool {
+	gerr, ok := errors.Cause(err).(*googleapi.Error)
+	if !ok {
+		return false
+	}
+	switch gerr.Code {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// jitter returns a random wait between half of backoff and backoff, so
+// that bots failing at the same time don't all retry at the same time
+func jitter(backoff time.Duration) time.Duration {
+	if backoff <= 1 {
+		return backoff
+	}
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
@@ -0,0 +1,136 @@
+package calendarbot
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/calendar/v3"
+)
+
+// flakyCalendar fails the calls numbered in failing with status, and
+// answers the others from the fake calendar
+type flakyCalendar struct {
+	*fakeCalendar
+	failing map[int]bool // Numbers of the failing calls, from 1
+	status  int
+	calls   int
+}
+
+func (c *flakyCalendar) RoundTrip(r *http.Request) (*http.Response, error) {
+	c.calls++
+	if c.failing[c.calls] {
+		return jsonResponse(c.status, fmt.Sprintf(`{"error":{"code":%d,"message":"%s"}}`, c.status, http.StatusText(c.status))), nil
+	}
+	return c.fakeCalendar.RoundTrip(r)
+}
+
+func (c *flakyCalendar) context() context.Context {
+	return context.WithValue(context.Background(), oauth2.HTTPClient, &http.Client{Transport: c})
+}
+
+func TestCalendarRetries(t *testing.T) {
+	start := time.Now().Add(10 * time.Minute).UTC()
+	events := []*calendar.Event{
+		testEvent("a", start.Format(time.RFC3339), start.Add(time.Hour).Format(time.RFC3339)),
+	}
+
+	tests := []struct {
+		name      string
+		retries   int
+		failures  int
+		status    int
+		expectErr bool
+		calls     int
+	}{
+		{"no failures", 2, 0, http.StatusServiceUnavailable, false, 1},
+		{"unavailable once", 2, 1, http.StatusServiceUnavailable, false, 2},
+		{"rate limited", 2, 2, http.StatusTooManyRequests, false, 3},
+		{"internal error", 2, 1, http.StatusInternalServerError, false, 2},
+		{"bad gateway", 2, 1, http.StatusBadGateway, false, 2},
+		{"gateway timeout", 2, 1, http.StatusGatewayTimeout, false, 2},
+		{"too many failures", 2, 3, http.StatusServiceUnavailable, true, 3},
+		{"disabled", 0, 1, http.StatusServiceUnavailable, true, 1},
+		{"unauthorized", 2, 1, http.StatusUnauthorized, true, 1},
+		{"forbidden", 2, 1, http.StatusForbidden, true, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cal := &flakyCalendar{
+				fakeCalendar: &fakeCalendar{events: events},
+				failing:      make(map[int]bool),
+				status:       test.status,
+			}
+			for i := 1; i <= test.failures; i++ {
+				cal.failing[i] = true
+			}
+			rec := &recordingNotifier{}
+			b := newTestBot()
+			b.CalendarRetries = test.retries
+			b.CalendarRetryBase = time.Millisecond
+			b.Notifier = rec
+
+			err := b.NotifyIndividualEvents(cal.context(), time.Now(), time.Hour)
+			if test.expectErr {
+				if err == nil {
+					t.Error("expected an error")
+				}
+			} else if err != nil {
+				t.Errorf("NotifyIndividualEvents failed: %s", err)
+			} else if ids, expect := rec.eventIDs(), [][]string{{"a"}}; !reflect.DeepEqual(ids, expect) {
+				t.Errorf("expected %q, got %q", expect, ids)
+			}
+			if cal.calls != test.calls {
+				t.Errorf("expected %d calls, got %d", test.calls, cal.calls)
+			}
+		})
+	}
+}
+
+func TestCalendarRetriesPages(t *testing.T) {
+	// The second page fails once, and listing starts over
+	cal := &flakyCalendar{
+		fakeCalendar: &fakeCalendar{
+			events: []*calendar.Event{
+				testEvent("a", "2017-01-10T09:00:00Z", "2017-01-10T10:00:00Z"),
+				testEvent("b", "2017-01-10T10:00:00Z", "2017-01-10T11:00:00Z"),
+			},
+			pageSize: 1,
+		},
+		failing: map[int]bool{2: true},
+		status:  http.StatusServiceUnavailable,
+	}
+	b := newTestBot()
+	b.CalendarRetries = 1
+	b.CalendarRetryBase = time.Millisecond
+
+	n, err := b.upcomingAgenda(cal.context(), mustParseTime(t, "2017-01-10T00:00:00Z"), 24*time.Hour)
+	if err != nil {
+		t.Fatalf("upcomingAgenda failed: %s", err)
+	}
+	var ids []string
+	for _, event := range n.Events {
+		ids = append(ids, event.Id)
+	}
+	if expect := []string{"a", "b"}; !reflect.DeepEqual(ids, expect) {
+		t.Errorf("expected %q, got %q", expect, ids)
+	}
+	if cal.calls != 4 {
+		t.Errorf("expected 4 calls, got %d", cal.calls)
+	}
+}
+
+func TestJitter(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		if wait := jitter(time.Second); wait < 500*time.Millisecond || wait > time.Second {
+			t.Fatalf("expected a wait between 500ms and 1s, got %s", wait)
+		}
+	}
+	if wait := jitter(0); wait != 0 {
+		t.Errorf("expected no wait, got %s", wait)
+	}
+}
@@ -0,0 +1,76 @@
+package calendarbot
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"google.golang.org/api/calendar/v3"
+)
+
+// reminderColor returns the color of the bar next to the reminder for
+// event, which starts at `start`. With ProximityColors it depends on how
+// soon that is, otherwise it is the event's color or AttachmentColor
+func (b *Bot) reminderColor(ctx context.Context, event *calendar.Event, start, now time.Time) string {
+	if b.ProximityColors {
+		switch lead := start.Sub(now); {
+		case lead < b.ProximityUrgent:
+			return "danger"
+		case lead < b.ProximitySoon:
+			return "warning"
+		default:
+			return "good"
+		}
+	}
+	if color := b.eventColor(ctx, event); color != "" {
+		return color
+	}
+	return b.AttachmentColor
+}
+
+// eventColor returns the color of the bar next to reminders for event:
+// its ColorId in EventColors, or else the color Google shows it in.
+// Events without a ColorId have the color of their calendar, and get
+// none
+func (b *Bot) eventColor(ctx context.Context, event *calendar.Event) string {
+	if event.ColorId == "" {
+		return ""
+	}
+	if color, ok := b.EventColors[event.ColorId]; ok {
+		return color
+	}
+
+	palette, err := b.eventPalette(ctx)
+	if err != nil {
+		b.Logger.Warningf(ctx, "failed to get event colors, using the default: %s", err)
+		return ""
+	}
+	return palette[event.ColorId]
+}
+
+// eventPalette returns the background colors of Google's event colors,
+// by ColorId. The palette is fetched once, and again only if that fails
+func (b *Bot) eventPalette(ctx context.Context) (map[string]string, error) {
+	b.paletteMu.Lock()
+	defer b.paletteMu.Unlock()
+
+	if b.palette != nil {
+		return b.palette, nil
+	}
+
+	s, err := b.CalendarService(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create calendar service")
+	}
+	colors, err := s.Colors.Get().Fields("event").Context(ctx).Do()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get colors")
+	}
+
+	palette := make(map[string]string, len(colors.Event))
+	for id, def := range colors.Event {
+		palette[id] = def.Background
+	}
+	b.palette = palette
+	return palette, nil
+}
@@ -0,0 +1,228 @@
+package calendarbot
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lestrrat/slack"
+	"golang.org/x/net/context"
+	"google.golang.org/api/calendar/v3"
+)
+
+func TestEventColor(t *testing.T) {
+	palette := &calendar.Colors{Event: map[string]calendar.ColorDefinition{
+		"5":  {Background: "#fbd75b", Foreground: "#1d1d1d"},
+		"11": {Background: "#dc2127", Foreground: "#1d1d1d"},
+	}}
+	colored := func(id string) *calendar.Event {
+		event := testEvent("a", "2017-01-10T09:00:00Z", "2017-01-10T10:00:00Z")
+		event.ColorId = id
+		return event
+	}
+
+	tests := []struct {
+		name   string
+		colors map[string]string
+		event  *calendar.Event
+		expect string
+	}{
+		{"override", map[string]string{"11": "#ff0000"}, colored("11"), "#ff0000"},
+		{"palette", map[string]string{"11": "#ff0000"}, colored("5"), "#fbd75b"},
+		{"no override", nil, colored("11"), "#dc2127"},
+		{"unknown", nil, colored("42"), ""},
+		{"calendar color", map[string]string{"": "#ff0000"}, colored(""), ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cal := &fakeCalendar{colors: palette}
+			b := newTestBot()
+			b.EventColors = test.colors
+
+			if color := b.eventColor(cal.context(), test.event); color != test.expect {
+				t.Errorf("expected %q, got %q", test.expect, color)
+			}
+		})
+	}
+}
+
+func TestEventPalette(t *testing.T) {
+	cal := &fakeCalendar{}
+	logger := &recordingLogger{}
+	b := newTestBot()
+	b.Logger = logger
+
+	event := testEvent("a", "2017-01-10T09:00:00Z", "2017-01-10T10:00:00Z")
+	event.ColorId = "11"
+
+	// Failing to get the palette leaves the color to Slack
+	if color := b.eventColor(cal.context(), event); color != "" {
+		t.Errorf("expected no color, got %q", color)
+	}
+	if len(logger.messages) != 1 || !strings.HasPrefix(logger.messages[0], "WARNING failed to get event colors") {
+		t.Errorf("expected a warning, got %q", logger.messages)
+	}
+
+	// The palette is fetched again after a failure, but only once after
+	// that
+	cal.colors = &calendar.Colors{Event: map[string]calendar.ColorDefinition{
+		"11": {Background: "#dc2127"},
+	}}
+	for i := 0; i < 3; i++ {
+		if color := b.eventColor(cal.context(), event); color != "#dc2127" {
+			t.Errorf("expected the palette color, got %q", color)
+		}
+	}
+	var fetched []string
+	for _, r := range cal.requests {
+		fetched = append(fetched, r.URL.Query().Get("fields"))
+	}
+	if expect := []string{"event", "event"}; !reflect.DeepEqual(fetched, expect) {
+		t.Errorf("expected colors to be fetched with masks %q, got %q", expect, fetched)
+	}
+}
+
+func TestReminderColor(t *testing.T) {
+	start := time.Now().Add(10 * time.Minute).UTC()
+	event := func(id, colorID string) *calendar.Event {
+		event := testEvent(id, start.Format(time.RFC3339), start.Add(time.Hour).Format(time.RFC3339))
+		event.ColorId = colorID
+		return event
+	}
+
+	tests := []struct {
+		name   string
+		window time.Duration
+		expect [][]string
+	}{
+		{"single", 0, [][]string{{"#ff0000"}, {"#fbd75b"}}},
+		{"batch", time.Minute, [][]string{{"#ff0000", "#fbd75b"}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cal := &fakeCalendar{
+				events: []*calendar.Event{event("a", "11"), event("b", "5")},
+				colors: &calendar.Colors{Event: map[string]calendar.ColorDefinition{
+					"5":  {Background: "#fbd75b"},
+					"11": {Background: "#dc2127"},
+				}},
+			}
+			slackAPI := &fakeSlack{}
+			b := newTestBot()
+			b.BatchWindow = test.window
+			b.EventColors = map[string]string{"11": "#ff0000"}
+			b.SlackTransport = slackAPI.transport
+
+			if err := b.NotifyIndividualEvents(cal.context(), time.Now(), time.Hour); err != nil {
+				t.Fatalf("NotifyIndividualEvents failed: %s", err)
+			}
+			var got [][]string
+			for _, post := range slackAPI.posts() {
+				var attachments []slack.Attachment
+				if err := json.Unmarshal([]byte(post.Form.Get("attachments")), &attachments); err != nil {
+					t.Fatalf("failed to decode attachments: %s", err)
+				}
+				var colors []string
+				for _, a := range attachments {
+					colors = append(colors, a.Color)
+				}
+				got = append(got, colors)
+			}
+			if !reflect.DeepEqual(got, test.expect) {
+				t.Errorf("expected colors %q, got %q", test.expect, got)
+			}
+		})
+	}
+}
+
+func TestProximityColor(t *testing.T) {
+	now := mustParseTime(t, "2017-01-10T09:00:00Z")
+	event := testEvent("a", "2017-01-10T10:00:00Z", "2017-01-10T11:00:00Z")
+	event.ColorId = "11"
+
+	tests := []struct {
+		name      string
+		proximity bool
+		soon      time.Duration
+		urgent    time.Duration
+		lead      time.Duration
+		expect    string
+	}{
+		{"started", true, 0, 0, -time.Minute, "danger"},
+		{"now", true, 0, 0, 0, "danger"},
+		{"urgent", true, 0, 0, 4 * time.Minute, "danger"},
+		{"urgent threshold", true, 0, 0, 5 * time.Minute, "warning"},
+		{"soon", true, 0, 0, 14 * time.Minute, "warning"},
+		{"soon threshold", true, 0, 0, 15 * time.Minute, "good"},
+		{"later", true, 0, 0, time.Hour, "good"},
+		{"custom soon", true, time.Hour, 0, 30 * time.Minute, "warning"},
+		{"custom urgent", true, time.Hour, 30 * time.Minute, 10 * time.Minute, "danger"},
+		{"event color", false, 0, 0, time.Minute, "#ff0000"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := newTestBot()
+			b.EventColors = map[string]string{"11": "#ff0000"}
+			b.ProximityColors = test.proximity
+			if test.soon != 0 {
+				b.ProximitySoon = test.soon
+			}
+			if test.urgent != 0 {
+				b.ProximityUrgent = test.urgent
+			}
+
+			if color := b.reminderColor(context.Background(), event, now.Add(test.lead), now); color != test.expect {
+				t.Errorf("expected %q, got %q", test.expect, color)
+			}
+		})
+	}
+}
+
+func TestAttachmentColor(t *testing.T) {
+	start := time.Now().Add(10 * time.Minute).UTC()
+	cal := &fakeCalendar{events: []*calendar.Event{
+		testEvent("a", start.Format(time.RFC3339), start.Add(time.Hour).Format(time.RFC3339)),
+	}}
+
+	tests := []struct {
+		name   string
+		color  string
+		expect string
+	}{
+		{"default", "", "good"},
+		{"custom", "#439fe0", "#439fe0"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			slackAPI := &fakeSlack{}
+			b := newTestBot()
+			if test.color != "" {
+				b.AttachmentColor = test.color
+			}
+			b.SlackTransport = slackAPI.transport
+
+			if err := b.NotifyIndividualEvents(cal.context(), time.Now(), time.Hour); err != nil {
+				t.Fatalf("NotifyIndividualEvents failed: %s", err)
+			}
+			if err := b.NotifyUpcomingEvents(cal.context(), time.Now(), time.Hour); err != nil {
+				t.Fatalf("NotifyUpcomingEvents failed: %s", err)
+			}
+
+			var got []string
+			for _, post := range slackAPI.posts() {
+				var attachments []slack.Attachment
+				if err := json.Unmarshal([]byte(post.Form.Get("attachments")), &attachments); err != nil {
+					t.Fatalf("failed to decode attachments: %s", err)
+				}
+				for _, a := range attachments {
+					got = append(got, a.Color)
+				}
+			}
+			if expect := []string{test.expect, test.expect}; !reflect.DeepEqual(got, expect) {
+				t.Errorf("expected colors %q, got %q", expect, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package calendarbot
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// EmailNotifier is a Notifier that sends notifications as email, with
+// both a plain text and an HTML version
+type EmailNotifier struct {
+	Addr string    // SMTP server as host:port
+	Auth smtp.Auth // Optional, e.g. smtp.PlainAuth
+	Bot  *Bot      // Optional, shows times like the bot does, see DisplayLocation and EndAsDuration
+	From string
+	To   []string
+}
+
+func (e *EmailNotifier) Notify(_ context.Context, n *Notification) error {
+	msg, err := e.message(n, time.Now())
+	if err != nil {
+		return errors.Wrap(err, "failed to create email")
+	}
+	if err := smtp.SendMail(e.Addr, e.Auth, e.From, e.To, msg); err != nil {
+		return errors.Wrapf(err, "failed to send email via %s", e.Addr)
+	}
+	return nil
+}
+
+// emailLine is one event in an email
+type emailLine struct {
+	When    string
+	Summary string
+	Link    string
+}
+
+var emailHTML = template.Must(template.New("email").Parse(`<html><body>
+{{if .Text}}<p>{{.Text}}</p>
+{{end}}<ul>
+{{range .Lines}}<li>{{.When}}: {{if .Link}}<a href="{{.Link}}">{{.Summary}}</a>{{else}}{{.Summary}}{{end}}</li>
+{{end}}</ul>
+</body></html>
+`))
+
+// message renders n as a multipart email
+func (e *EmailNotifier) message(n *Notification, now time.Time) ([]byte, error) {
+	subject := n.Title
+	if n.Kind == ReminderNotification && len(n.Events) > 0 {
+		subject = n.Events[0].Summary
+	}
+
+	b := e.Bot
+	if b == nil {
+		b = &Bot{DisplayLocation: time.Local}
+	}
+
+	lines := make([]emailLine, 0, len(n.Events))
+	for _, event := range n.Events {
+		start, end, allDay, err := eventTimes(event)
+		if err != nil {
+			return nil, err
+		}
+		when := "All day"
+		if !allDay {
+			when = b.timeRange(event, start, end)
+		}
+		lines = append(lines, emailLine{When: when, Summary: event.Summary, Link: event.HtmlLink})
+	}
+
+	var text bytes.Buffer
+	if n.Text != "" {
+		fmt.Fprintf(&text, "%s\n\n", n.Text)
+	}
+	for _, line := range lines {
+		fmt.Fprintf(&text, "%s: %s\n", line.When, line.Summary)
+		if line.Link != "" {
+			fmt.Fprintf(&text, "  %s\n", line.Link)
+		}
+	}
+
+	var html bytes.Buffer
+	data := struct {
+		Text  string
+		Lines []emailLine
+	}{n.Text, lines}
+	if err := emailHTML.Execute(&html, data); err != nil {
+		return nil, errors.Wrap(err, "failed to render HTML")
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for _, part := range []struct {
+		contentType string
+		content     []byte
+	}{
+		{"text/plain; charset=utf-8", text.Bytes()},
+		{"text/html; charset=utf-8", html.Bytes()},
+	} {
+		w, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create part")
+		}
+		qw := quotedprintable.NewWriter(w)
+		if _, err := qw.Write(part.content); err != nil {
+			return nil, errors.Wrap(err, "failed to write part")
+		}
+		if err := qw.Close(); err != nil {
+			return nil, errors.Wrap(err, "failed to write part")
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, errors.Wrap(err, "failed to finish message")
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", e.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(e.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", mw.Boundary())
+	msg.Write(body.Bytes())
+	return msg.Bytes(), nil
+}
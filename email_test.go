@@ -0,0 +1,199 @@
+package calendarbot
+
+import (
+	"bufio"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/calendar/v3"
+)
+
+// smtpServer is a minimal SMTP server accepting a single message
+type smtpServer struct {
+	listener   net.Listener
+	recipients []string
+	data       chan string
+}
+
+func newSMTPServer(t *testing.T) *smtpServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err)
+	}
+	s := &smtpServer{listener: l, data: make(chan string, 1)}
+	go s.serve()
+	return s
+}
+
+func (s *smtpServer) serve() {
+	conn, err := s.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	reply := func(line string) {
+		conn.Write([]byte(line + "\r\n"))
+	}
+	reply("220 localhost ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			s.recipients = append(s.recipients, strings.Trim(strings.TrimSpace(line)[8:], "<>"))
+			reply("250 OK")
+		case cmd == "DATA":
+			reply("354 Go ahead")
+			var data []string
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data = append(data, strings.TrimPrefix(l, "."))
+			}
+			s.data <- strings.Join(data, "")
+			reply("250 OK")
+		case cmd == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+func TestEmailNotifier(t *testing.T) {
+	s := newSMTPServer(t)
+	defer s.listener.Close()
+
+	e := &EmailNotifier{
+		Addr: s.listener.Addr().String(),
+		From: "bot@example.com",
+		To:   []string{"alice@example.com", "bob@example.com"},
+	}
+	n := &Notification{
+		Kind:  AgendaNotification,
+		Title: "Upcoming events – today",
+		Events: []*calendar.Event{
+			testAllDayEvent("holiday", "2017-01-10", "2017-01-11"),
+			testEvent("a <b> & c", "2017-01-10T09:00:00Z", "2017-01-10T10:00:00Z"),
+		},
+	}
+	if err := e.Notify(context.Background(), n); err != nil {
+		t.Fatalf("Notify failed: %s", err)
+	}
+
+	msg, err := mail.ReadMessage(strings.NewReader(<-s.data))
+	if err != nil {
+		t.Fatalf("failed to parse message: %s", err)
+	}
+	if got := strings.Join(s.recipients, ","); got != "alice@example.com,bob@example.com" {
+		t.Errorf("unexpected recipients %s", got)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil || subject != n.Title {
+		t.Errorf("expected subject %q, got %q (%v)", n.Title, subject, err)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("expected a multipart/alternative message, got %s (%v)", mediaType, err)
+	}
+	parts := map[string]string{}
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		p, err := mr.NextPart()
+		if err != nil {
+			break
+		}
+		// The reader undoes the quoted-printable encoding, but line
+		// endings were converted to CRLF on the wire
+		buf, err := ioutil.ReadAll(p)
+		if err != nil {
+			t.Fatalf("failed to read part: %s", err)
+		}
+		parts[strings.SplitN(p.Header.Get("Content-Type"), ";", 2)[0]] = strings.Replace(string(buf), "\r\n", "\n", -1)
+	}
+
+	expectText := "All day: holiday\n" +
+		"  https://calendar.google.com/event?eid=holiday\n" +
+		"09:00-10:00: a <b> & c\n" +
+		"  https://calendar.google.com/event?eid=a <b> & c\n"
+	if parts["text/plain"] != expectText {
+		t.Errorf("expected text %q, got %q", expectText, parts["text/plain"])
+	}
+	html := parts["text/html"]
+	if !strings.Contains(html, `<li>All day: <a href="https://calendar.google.com/event?eid=holiday">holiday</a></li>`) {
+		t.Errorf("expected the all-day event in the HTML part, got %s", html)
+	}
+	if !strings.Contains(html, "09:00-10:00: <a") || !strings.Contains(html, ">a &lt;b&gt; &amp; c</a>") {
+		t.Errorf("expected an escaped event in the HTML part, got %s", html)
+	}
+}
+
+func TestEmailNotifierBot(t *testing.T) {
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Skipf("time zone data not available: %s", err)
+	}
+
+	n := &Notification{
+		Kind:   AgendaNotification,
+		Events: []*calendar.Event{testEvent("a", "2017-01-10T09:00:00Z", "2017-01-10T10:30:00Z")},
+	}
+	tests := []struct {
+		name   string
+		bot    *Bot
+		expect string
+	}{
+		{"default", nil, "09:00-10:30: a"},
+		{"display location", &Bot{DisplayLocation: tokyo}, "18:00-19:30: a"},
+		{"end as duration", &Bot{DisplayLocation: time.UTC, EndAsDuration: true}, "09:00 (1h30m): a"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e := &EmailNotifier{Bot: test.bot, From: "bot@example.com", To: []string{"alice@example.com"}}
+			msg, err := e.message(n, time.Now())
+			if err != nil {
+				t.Fatalf("message failed: %s", err)
+			}
+			if !strings.Contains(string(msg), test.expect) {
+				t.Errorf("expected %q in the message, got %s", test.expect, msg)
+			}
+		})
+	}
+}
+
+func TestEmailNotifierConnectionError(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	e := &EmailNotifier{Addr: addr, From: "bot@example.com", To: []string{"alice@example.com"}}
+	n := &Notification{Kind: ReminderNotification, Text: "This event starts in 5 minutes"}
+	err = e.Notify(context.Background(), n)
+	if err == nil || !strings.Contains(err.Error(), "failed to send email via "+addr) {
+		t.Errorf("expected a connection error, got %v", err)
+	}
+}
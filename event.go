@@ -0,0 +1,227 @@
+package calendarbot
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/api/calendar/v3"
+)
+
+// allDayLayout is the format of EventDateTime.Date
+const allDayLayout = "2006-01-02"
+
+// isAllDay reports whether event lasts whole days, e.g. a holiday or a
+// birthday. These only carry a date without a time
+func isAllDay(event *calendar.Event) bool {
+	return event.Start != nil && event.Start.DateTime == "" && event.Start.Date != ""
+}
+
+// Values of Event.EventType that are handled separately from regular
+// events, see Bot.keepEventType
+const (
+	eventTypeFocusTime       = "focusTime"
+	eventTypeOutOfOffice     = "outOfOffice"
+	eventTypeWorkingLocation = "workingLocation"
+)
+
+// isWorkingLocation reports whether event only tells where the calendar
+// owner works from, rather than being something to attend
+func isWorkingLocation(event *calendar.Event) bool {
+	return event.EventType == eventTypeWorkingLocation
+}
+
+// isFocusTime reports whether event is time the calendar owner blocked
+// to work undisturbed
+func isFocusTime(event *calendar.Event) bool {
+	return event.EventType == eventTypeFocusTime
+}
+
+// isOutOfOffice reports whether event marks the calendar owner as away
+func isOutOfOffice(event *calendar.Event) bool {
+	return event.EventType == eventTypeOutOfOffice
+}
+
+// workingLocationText describes a working-location event, e.g.
+// "Working from home" or "Working at Tokyo office"
+func workingLocationText(event *calendar.Event) string {
+	if p := event.WorkingLocationProperties; p != nil {
+		switch {
+		case p.Type == "homeOffice" || p.HomeOffice != nil:
+			return "Working from home"
+		case p.OfficeLocation != nil && p.OfficeLocation.Label != "":
+			return "Working at " + p.OfficeLocation.Label
+		case p.CustomLocation != nil && p.CustomLocation.Label != "":
+			return "Working at " + p.CustomLocation.Label
+		}
+	}
+	return "Working location: " + event.Summary
+}
+
+// locationText renders the location of an event for Slack, as a link if
+// it is a URL such as a video call
+func locationText(location string) string {
+	if strings.ContainsAny(location, " \t\n") {
+		return location
+	}
+	if u, err := url.Parse(location); err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" {
+		return "<" + location + ">"
+	}
+	return location
+}
+
+// joinURL returns the link to join the video call of event: its
+// HangoutLink, or else the first video entry point of its conference
+func joinURL(event *calendar.Event) string {
+	if event.HangoutLink != "" {
+		return event.HangoutLink
+	}
+	if event.ConferenceData == nil {
+		return ""
+	}
+	for _, entry := range event.ConferenceData.EntryPoints {
+		if entry.EntryPointType == "video" && entry.Uri != "" {
+			return entry.Uri
+		}
+	}
+	return ""
+}
+
+// pendingResponse reports whether the calendar owner has not responded
+// to event yet
+func pendingResponse(event *calendar.Event) bool {
+	for _, attendee := range event.Attendees {
+		if attendee.Self {
+			return attendee.ResponseStatus == "needsAction"
+		}
+	}
+	return false
+}
+
+// soleRequiredAttendee reports whether the calendar owner is a required
+// attendee of event, and the only one besides the organizer and
+// resources such as meeting rooms. That can't be told if Google left
+// attendees out
+func soleRequiredAttendee(event *calendar.Event) bool {
+	if event.AttendeesOmitted {
+		return false
+	}
+	var self bool
+	for _, attendee := range event.Attendees {
+		if attendee.Self {
+			if attendee.Optional || attendee.Organizer {
+				return false
+			}
+			self = true
+			continue
+		}
+		if !attendee.Optional && !attendee.Organizer && !attendee.Resource {
+			return false
+		}
+	}
+	return self
+}
+
+// eventStart returns the start of event, and whether it is an all-day
+// event. See eventTimes
+func eventStart(event *calendar.Event) (time.Time, bool, error) {
+	if event.Start == nil {
+		return time.Time{}, false, errors.New("event has no start")
+	}
+	t, allDay, err := parseEventDateTime(event.Start)
+	return t, allDay, errors.Wrap(err, "failed to parse start")
+}
+
+// eventTimes returns the start and end of event. For all-day events,
+// these are midnight UTC of the first day and of the day after the
+// last day. Instances of recurring events are handled like any other
+// event, as they carry their own start and end
+func eventTimes(event *calendar.Event) (start, end time.Time, allDay bool, err error) {
+	if event.Start == nil || event.End == nil {
+		return start, end, false, errors.New("event has no start or end")
+	}
+
+	start, allDay, err = parseEventDateTime(event.Start)
+	if err != nil {
+		return start, end, allDay, errors.Wrap(err, "failed to parse start")
+	}
+	end, _, err = parseEventDateTime(event.End)
+	if err != nil {
+		return start, end, allDay, errors.Wrap(err, "failed to parse end")
+	}
+	return start, end, allDay, nil
+}
+
+// parseEventDateTime parses the DateTime of dt, or its Date if dt is a
+// whole day
+func parseEventDateTime(dt *calendar.EventDateTime) (time.Time, bool, error) {
+	if dt.DateTime == "" && dt.Date != "" {
+		t, err := time.Parse(allDayLayout, dt.Date)
+		return t, true, errors.Wrap(err, "failed to parse date")
+	}
+	t, err := time.Parse(time.RFC3339, dt.DateTime)
+	return t, false, errors.Wrap(err, "failed to parse date/time")
+}
+
+// sortByStart sorts events by their start time, keeping the order of
+// events that start at the same time
+func sortByStart(events []*calendar.Event) error {
+	starts := make(map[*calendar.Event]time.Time, len(events))
+	for _, event := range events {
+		start, _, _, err := eventTimes(event)
+		if err != nil {
+			return err
+		}
+		starts[event] = start
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return starts[events[i]].Before(starts[events[j]])
+	})
+	return nil
+}
+
+// displayTime returns t, a time of event, in the time zone it is shown
+// in: DisplayLocation if set, or else the time zone of event. New sets
+// DisplayLocation, so the latter needs it to be cleared. Times of
+// all-day events are dates, and must not be passed here
+func (b *Bot) displayTime(event *calendar.Event, t time.Time) time.Time {
+	if b.DisplayLocation != nil {
+		return t.In(b.DisplayLocation)
+	}
+	if event != nil && event.Start != nil && event.Start.TimeZone != "" {
+		if loc, err := time.LoadLocation(event.Start.TimeZone); err == nil {
+			return t.In(loc)
+		}
+	}
+	return t
+}
+
+// withoutOngoing returns the events in `events` that don't start before
+// t. All-day events are kept, see SkipAllDay
+func withoutOngoing(events []*calendar.Event, t time.Time) ([]*calendar.Event, error) {
+	list := make([]*calendar.Event, 0, len(events))
+	for _, event := range events {
+		start, _, allDay, err := eventTimes(event)
+		if err != nil {
+			return nil, err
+		}
+		if allDay || !start.Before(t) {
+			list = append(list, event)
+		}
+	}
+	return list, nil
+}
+
+// withoutAllDay returns the events in `events` that are not all-day
+// events
+func withoutAllDay(events []*calendar.Event) []*calendar.Event {
+	list := make([]*calendar.Event, 0, len(events))
+	for _, event := range events {
+		if !isAllDay(event) {
+			list = append(list, event)
+		}
+	}
+	return list
+}
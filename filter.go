@@ -0,0 +1,182 @@
+package calendarbot
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+// EventFilter decides which events the bot notifies about, see
+// Bot.Filters
+type EventFilter interface {
+	Keep(*calendar.Event) bool
+}
+
+// EventFilterFunc is a function that can be used as an EventFilter
+type EventFilterFunc func(*calendar.Event) bool
+
+func (f EventFilterFunc) Keep(event *calendar.Event) bool {
+	return f(event)
+}
+
+// FilterOp tells how a CompositeFilter combines its filters
+type FilterOp int
+
+const (
+	FilterAnd FilterOp = iota // Keep events that all filters keep
+	FilterOr                  // Keep events that any filter keeps
+)
+
+// CompositeFilter combines Filters with Op. Composite filters can be
+// nested, e.g.
+//
+//	&CompositeFilter{Op: FilterOr, Filters: []EventFilter{
+//		AttendeeDomain("example.com"),
+//		&CompositeFilter{Filters: []EventFilter{Busy(), MinDuration(time.Hour)}},
+//	}}
+//
+// An empty FilterAnd keeps every event, an empty FilterOr none
+type CompositeFilter struct {
+	Op      FilterOp
+	Filters []EventFilter
+}
+
+func (c *CompositeFilter) Keep(event *calendar.Event) bool {
+	for _, f := range c.Filters {
+		if f.Keep(event) == (c.Op == FilterOr) {
+			return c.Op == FilterOr
+		}
+	}
+	return c.Op == FilterAnd
+}
+
+// Not keeps the events that f drops
+func Not(f EventFilter) EventFilter {
+	return EventFilterFunc(func(event *calendar.Event) bool {
+		return !f.Keep(event)
+	})
+}
+
+// SummaryMatches keeps events whose title matches re
+func SummaryMatches(re *regexp.Regexp) EventFilter {
+	return EventFilterFunc(func(event *calendar.Event) bool {
+		return re.MatchString(event.Summary)
+	})
+}
+
+// HasAttendee keeps events that `email` is invited to. Attendees that
+// Google left out are only seen with Bot.FetchOmittedAttendees
+func HasAttendee(email string) EventFilter {
+	return EventFilterFunc(func(event *calendar.Event) bool {
+		for _, attendee := range event.Attendees {
+			if strings.EqualFold(attendee.Email, email) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// AttendeeDomain keeps events with at least one attendee from `domain`,
+// not counting resources such as meeting rooms. See HasAttendee
+func AttendeeDomain(domain string) EventFilter {
+	suffix := "@" + strings.ToLower(domain)
+	return EventFilterFunc(func(event *calendar.Event) bool {
+		for _, attendee := range event.Attendees {
+			if !attendee.Resource && strings.HasSuffix(strings.ToLower(attendee.Email), suffix) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// MinDuration keeps events lasting at least d. All-day events last a
+// day for each date they cover
+func MinDuration(d time.Duration) EventFilter {
+	return EventFilterFunc(func(event *calendar.Event) bool {
+		start, end, _, err := eventTimes(event)
+		return err == nil && end.Sub(start) >= d
+	})
+}
+
+// MaxDuration keeps events lasting at most d, see MinDuration
+func MaxDuration(d time.Duration) EventFilter {
+	return EventFilterFunc(func(event *calendar.Event) bool {
+		start, end, _, err := eventTimes(event)
+		return err == nil && end.Sub(start) <= d
+	})
+}
+
+// Busy keeps events that block time, dropping those marked as "free"
+func Busy() EventFilter {
+	return EventFilterFunc(func(event *calendar.Event) bool {
+		return event.Transparency != "transparent"
+	})
+}
+
+// NotAllDay drops all-day events, see also Bot.SkipAllDay
+func NotAllDay() EventFilter {
+	return EventFilterFunc(func(event *calendar.Event) bool {
+		return !isAllDay(event)
+	})
+}
+
+// filterEvents returns the events in `events` that all of Filters keep,
+// leaving out MuteEventIDs, the event types that keepEventType doesn't
+// keep and the titles that summaryFilters don't keep
+func (b *Bot) filterEvents(events []*calendar.Event) []*calendar.Event {
+	all := CompositeFilter{Op: FilterAnd, Filters: append(b.summaryFilters(), b.Filters...)}
+	muted := make(map[string]bool, len(b.MuteEventIDs))
+	for _, id := range b.MuteEventIDs {
+		muted[id] = true
+	}
+	list := make([]*calendar.Event, 0, len(events))
+	for _, event := range events {
+		// Muting a recurring event mutes all of its instances
+		if muted[event.Id] || event.RecurringEventId != "" && muted[event.RecurringEventId] {
+			continue
+		}
+		if b.keepEventType(event) && all.Keep(event) {
+			list = append(list, event)
+		}
+	}
+	return list
+}
+
+// keepEventType reports whether events of the type of event are
+// notified about: working-location events only with ShowWorkingLocation,
+// out-of-office and focus-time events unless SkipOutOfOffice or
+// SkipFocusTime is set
+func (b *Bot) keepEventType(event *calendar.Event) bool {
+	switch event.EventType {
+	case eventTypeWorkingLocation:
+		return b.ShowWorkingLocation
+	case eventTypeOutOfOffice:
+		return !b.SkipOutOfOffice
+	case eventTypeFocusTime:
+		return !b.SkipFocusTime
+	default:
+		return true
+	}
+}
+
+// summaryFilters returns the filters for IncludePatterns and
+// ExcludePatterns: a title must match one of the former, unless there
+// are none, and none of the latter
+func (b *Bot) summaryFilters() []EventFilter {
+	var filters []EventFilter
+	if len(b.IncludePatterns) > 0 {
+		include := &CompositeFilter{Op: FilterOr}
+		for _, re := range b.IncludePatterns {
+			include.Filters = append(include.Filters, SummaryMatches(re))
+		}
+		filters = append(filters, include)
+	}
+	for _, re := range b.ExcludePatterns {
+		filters = append(filters, Not(SummaryMatches(re)))
+	}
+	return filters
+}
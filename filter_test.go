@@ -0,0 +1,292 @@
+package calendarbot
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+func TestCompositeFilter(t *testing.T) {
+	event := func(id, start, end, transparency string, attendees ...string) *calendar.Event {
+		e := testEvent(id, start, end)
+		e.Transparency = transparency
+		for _, email := range attendees {
+			e.Attendees = append(e.Attendees, &calendar.EventAttendee{Email: email})
+		}
+		return e
+	}
+	events := []*calendar.Event{
+		event("standup", "2017-01-10T09:00:00Z", "2017-01-10T09:15:00Z", "", "alice@example.com", "bob@example.com"),
+		event("customer call", "2017-01-10T10:00:00Z", "2017-01-10T11:00:00Z", "", "carol@Customer.com"),
+		event("focus", "2017-01-10T13:00:00Z", "2017-01-10T16:00:00Z", "transparent"),
+		event("planning", "2017-01-10T16:00:00Z", "2017-01-10T17:30:00Z", "opaque", "alice@example.com"),
+		testAllDayEvent("holiday", "2017-01-10", "2017-01-11"),
+	}
+
+	tests := []struct {
+		name   string
+		filter EventFilter
+		expect []string
+	}{
+		{"empty and", &CompositeFilter{}, []string{"standup", "customer call", "focus", "planning", "holiday_20170110"}},
+		{"empty or", &CompositeFilter{Op: FilterOr}, nil},
+		{"busy and long", &CompositeFilter{Filters: []EventFilter{
+			Busy(),
+			MinDuration(time.Hour),
+			NotAllDay(),
+		}}, []string{"customer call", "planning"}},
+		{"customer or short", &CompositeFilter{Op: FilterOr, Filters: []EventFilter{
+			AttendeeDomain("customer.com"),
+			MaxDuration(15 * time.Minute),
+		}}, []string{"standup", "customer call"}},
+		{"nested", &CompositeFilter{Op: FilterOr, Filters: []EventFilter{
+			SummaryMatches(regexp.MustCompile(`^focus`)),
+			&CompositeFilter{Filters: []EventFilter{
+				HasAttendee("Alice@example.com"),
+				Not(SummaryMatches(regexp.MustCompile(`standup`))),
+			}},
+		}}, []string{"focus", "planning"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got []string
+			for _, event := range events {
+				if test.filter.Keep(event) {
+					got = append(got, event.Id)
+				}
+			}
+			if !reflect.DeepEqual(got, test.expect) {
+				t.Errorf("expected %q, got %q", test.expect, got)
+			}
+		})
+	}
+}
+
+func TestFilters(t *testing.T) {
+	start := mustParseTime(t, "2017-01-10T08:00:00Z")
+	free := testEvent("free", "2017-01-10T09:00:00Z", "2017-01-10T10:00:00Z")
+	free.Transparency = "transparent"
+	cal := &fakeCalendar{events: []*calendar.Event{
+		free,
+		testEvent("busy", "2017-01-10T11:00:00Z", "2017-01-10T12:00:00Z"),
+		testEvent("lunch", "2017-01-10T12:00:00Z", "2017-01-10T13:00:00Z"),
+	}}
+	b := newTestBot()
+	b.Filters = []EventFilter{Busy(), Not(SummaryMatches(regexp.MustCompile(`lunch`)))}
+
+	n, err := b.upcomingAgenda(cal.context(), start, 12*time.Hour)
+	if err != nil {
+		t.Fatalf("upcomingAgenda failed: %s", err)
+	}
+	var got []string
+	for _, event := range n.Events {
+		got = append(got, event.Id)
+	}
+	if expect := []string{"busy"}; !reflect.DeepEqual(got, expect) {
+		t.Errorf("expected %q, got %q", expect, got)
+	}
+}
+
+func TestSummaryPatterns(t *testing.T) {
+	tests := []struct {
+		name    string
+		include []string
+		exclude []string
+		expect  []string
+	}{
+		{"none", nil, nil, []string{"Standup", "Lunch", "Team standup lunch", "Retro"}},
+		{"include", []string{`(?i)standup`}, nil, []string{"Standup", "Team standup lunch"}},
+		{"exclude", nil, []string{`Lunch`}, []string{"Standup", "Team standup lunch", "Retro"}},
+		{"exclude wins", []string{`(?i)standup`}, []string{`(?i)lunch`}, []string{"Standup"}},
+		{"several", []string{`^Standup$`, `Retro`}, nil, []string{"Standup", "Retro"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := newTestBot()
+			for _, p := range test.include {
+				b.IncludePatterns = append(b.IncludePatterns, regexp.MustCompile(p))
+			}
+			for _, p := range test.exclude {
+				b.ExcludePatterns = append(b.ExcludePatterns, regexp.MustCompile(p))
+			}
+
+			// The agenda
+			start := mustParseTime(t, "2017-01-10T08:00:00Z")
+			cal := &fakeCalendar{}
+			for i, summary := range []string{"Standup", "Lunch", "Team standup lunch", "Retro"} {
+				at := start.Add(time.Duration(i+1) * time.Hour)
+				cal.events = append(cal.events, testEvent(summary, at.Format(time.RFC3339), at.Add(time.Hour).Format(time.RFC3339)))
+			}
+			n, err := b.upcomingAgenda(cal.context(), start, 12*time.Hour)
+			if err != nil {
+				t.Fatalf("upcomingAgenda failed: %s", err)
+			}
+			var got []string
+			for _, event := range n.Events {
+				got = append(got, event.Summary)
+			}
+			if !reflect.DeepEqual(got, test.expect) {
+				t.Errorf("expected agenda %q, got %q", test.expect, got)
+			}
+
+			// The reminders
+			now := time.Now().UTC().Truncate(time.Second)
+			for i, event := range cal.events {
+				at := now.Add(time.Duration(i+1) * time.Minute)
+				event.Start.DateTime = at.Format(time.RFC3339)
+				event.End.DateTime = at.Add(time.Hour).Format(time.RFC3339)
+			}
+			rec := &recordingNotifier{}
+			b.Notifier = rec
+			if err := b.NotifyIndividualEvents(cal.context(), now, 10*time.Minute); err != nil {
+				t.Fatalf("NotifyIndividualEvents failed: %s", err)
+			}
+			got = nil
+			for _, ids := range rec.eventIDs() {
+				got = append(got, ids...)
+			}
+			if !reflect.DeepEqual(got, test.expect) {
+				t.Errorf("expected reminders %q, got %q", test.expect, got)
+			}
+		})
+	}
+}
+
+func TestFetchOmittedAttendees(t *testing.T) {
+	start := time.Now().Add(10 * time.Minute).UTC()
+	attendees := func(emails ...string) []*calendar.EventAttendee {
+		var list []*calendar.EventAttendee
+		for _, email := range emails {
+			list = append(list, &calendar.EventAttendee{Email: email})
+		}
+		return list
+	}
+	// Google only returned one of the attendees
+	listed := func(id string) *calendar.Event {
+		event := testEvent(id, start.Format(time.RFC3339), start.Add(time.Hour).Format(time.RFC3339))
+		event.Attendees = attendees("bob@example.com")
+		event.AttendeesOmitted = true
+		return event
+	}
+	full := testEvent("a", start.Format(time.RFC3339), start.Add(time.Hour).Format(time.RFC3339))
+	full.Attendees = attendees("bob@example.com", "carol@example.com")
+
+	tests := []struct {
+		name    string
+		fetch   bool
+		full    map[string]*calendar.Event
+		expect  []string // Reminded event IDs
+		fetched []string // events.get paths
+		warned  bool
+	}{
+		{"listed only", false, map[string]*calendar.Event{"a": full}, nil, nil, false},
+		{"fetch", true, map[string]*calendar.Event{"a": full}, []string{"a"}, []string{"/calendar/v3/calendars/primary/events/a"}, false},
+		{"fetch fails", true, map[string]*calendar.Event{"a": nil}, nil, []string{"/calendar/v3/calendars/primary/events/a"}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			complete := testEvent("b", start.Format(time.RFC3339), start.Add(time.Hour).Format(time.RFC3339))
+			complete.Attendees = attendees("carol@example.com")
+			cal := &fakeCalendar{events: []*calendar.Event{listed("a"), complete}, full: test.full}
+			notifier := &recordingNotifier{}
+			logger := &recordingLogger{}
+			b := newTestBot()
+			b.FetchOmittedAttendees = test.fetch
+			b.Filters = []EventFilter{HasAttendee("carol@example.com")}
+			b.Logger = logger
+			b.Notifier = notifier
+
+			if err := b.NotifyIndividualEvents(cal.context(), time.Now(), time.Hour); err != nil {
+				t.Fatalf("NotifyIndividualEvents failed: %s", err)
+			}
+			var got []string
+			for _, ids := range notifier.eventIDs() {
+				got = append(got, ids...)
+			}
+			if expect := append(test.expect, "b"); !reflect.DeepEqual(got, expect) {
+				t.Errorf("expected reminders for %q, got %q", expect, got)
+			}
+
+			var fetched []string
+			for _, r := range cal.requests {
+				if !strings.HasSuffix(r.URL.Path, "/events") {
+					fetched = append(fetched, r.URL.Path)
+				}
+			}
+			if !reflect.DeepEqual(fetched, test.fetched) {
+				t.Errorf("expected requests %q, got %q", test.fetched, fetched)
+			}
+			if warned := len(logger.messages) > 0; warned != test.warned {
+				t.Errorf("expected warning %t, got %q", test.warned, logger.messages)
+			}
+		})
+	}
+}
+
+func TestMuteEventIDs(t *testing.T) {
+	instance := func(series, id string, at time.Time) *calendar.Event {
+		event := testEvent(id, at.Format(time.RFC3339), at.Add(30*time.Minute).Format(time.RFC3339))
+		event.RecurringEventId = series
+		return event
+	}
+
+	tests := []struct {
+		name   string
+		mute   []string
+		expect []string
+	}{
+		{"none", nil, []string{"standup_1", "retro_1", "single", "standup_2"}},
+		{"series", []string{"standup"}, []string{"retro_1", "single"}},
+		{"instance", []string{"standup_2"}, []string{"standup_1", "retro_1", "single"}},
+		{"single", []string{"single", "unknown"}, []string{"standup_1", "retro_1", "standup_2"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			events := func(start time.Time) []*calendar.Event {
+				return []*calendar.Event{
+					instance("standup", "standup_1", start.Add(time.Minute)),
+					instance("retro", "retro_1", start.Add(2*time.Minute)),
+					testEvent("single", start.Add(3*time.Minute).Format(time.RFC3339), start.Add(time.Hour).Format(time.RFC3339)),
+					instance("standup", "standup_2", start.Add(4*time.Minute)),
+				}
+			}
+			b := newTestBot()
+			b.MuteEventIDs = test.mute
+
+			// The agenda
+			start := mustParseTime(t, "2017-01-10T08:00:00Z")
+			cal := &fakeCalendar{events: events(start)}
+			n, err := b.upcomingAgenda(cal.context(), start, 12*time.Hour)
+			if err != nil {
+				t.Fatalf("upcomingAgenda failed: %s", err)
+			}
+			var got []string
+			for _, event := range n.Events {
+				got = append(got, event.Id)
+			}
+			if !reflect.DeepEqual(got, test.expect) {
+				t.Errorf("expected agenda %q, got %q", test.expect, got)
+			}
+
+			// The reminders
+			now := time.Now().UTC().Truncate(time.Second)
+			cal = &fakeCalendar{events: events(now)}
+			rec := &recordingNotifier{}
+			b.Notifier = rec
+			if err := b.NotifyIndividualEvents(cal.context(), now, 10*time.Minute); err != nil {
+				t.Fatalf("NotifyIndividualEvents failed: %s", err)
+			}
+			got = nil
+			for _, ids := range rec.eventIDs() {
+				got = append(got, ids...)
+			}
+			if !reflect.DeepEqual(got, test.expect) {
+				t.Errorf("expected reminders %q, got %q", test.expect, got)
+			}
+		})
+	}
+}
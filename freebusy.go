@@ -0,0 +1,126 @@
+package calendarbot
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lestrrat/slack"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"google.golang.org/api/calendar/v3"
+)
+
+// Conflict is a time another calendar is busy during an event, see
+// Bot.FreeBusyCalendars
+type Conflict struct {
+	Calendar string
+	Start    time.Time
+	End      time.Time
+}
+
+// busyTimes returns the times FreeBusyCalendars are busy between start
+// and end, leaving out calendar `id`, where the events come from. Its
+// own events would always conflict with themselves
+func (b *Bot) busyTimes(ctx context.Context, s *calendar.Service, id string, start, end time.Time) ([]Conflict, error) {
+	req := &calendar.FreeBusyRequest{
+		TimeMin: start.Format(time.RFC3339),
+		TimeMax: end.Format(time.RFC3339),
+	}
+	for _, cal := range b.FreeBusyCalendars {
+		if calendarID(cal) != id {
+			req.Items = append(req.Items, &calendar.FreeBusyRequestItem{Id: calendarID(cal)})
+		}
+	}
+	if len(req.Items) == 0 {
+		return nil, nil
+	}
+
+	res, err := s.Freebusy.Query(req).Context(ctx).Do()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query free/busy")
+	}
+
+	var busy []Conflict
+	for _, item := range req.Items {
+		fb, ok := res.Calendars[item.Id]
+		if !ok {
+			continue
+		}
+		if len(fb.Errors) > 0 {
+			return nil, errors.Errorf("failed to query free/busy of %s: %s", item.Id, fb.Errors[0].Reason)
+		}
+		for _, period := range fb.Busy {
+			from, err := time.Parse(time.RFC3339, period.Start)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to parse busy start")
+			}
+			to, err := time.Parse(time.RFC3339, period.End)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to parse busy end")
+			}
+			busy = append(busy, Conflict{Calendar: item.Id, Start: from, End: to})
+		}
+	}
+	return busy, nil
+}
+
+// reminderConflicts returns the busy times of FreeBusyCalendars that
+// overlap with each of the reminders in `due`, by event ID. Conflicts
+// only add to reminders, so failing to look them up is only logged
+func (b *Bot) reminderConflicts(ctx context.Context, s *calendar.Service, id string, due []reminder) map[string][]Conflict {
+	if len(b.FreeBusyCalendars) == 0 || len(due) == 0 {
+		return nil
+	}
+
+	var start, end time.Time
+	spans := make([][2]time.Time, len(due))
+	for i, r := range due {
+		_, to, _, err := eventTimes(r.event)
+		if err != nil {
+			b.Logger.Warningf(ctx, "failed to look up conflicts: %s", err)
+			return nil
+		}
+		spans[i] = [2]time.Time{r.start, to}
+		if start.IsZero() || r.start.Before(start) {
+			start = r.start
+		}
+		if to.After(end) {
+			end = to
+		}
+	}
+
+	busy, err := b.busyTimes(ctx, s, id, start, end)
+	if err != nil {
+		b.Logger.Warningf(ctx, "failed to look up conflicts: %s", err)
+		return nil
+	}
+
+	conflicts := make(map[string][]Conflict)
+	for i, r := range due {
+		for _, c := range busy {
+			if c.Start.Before(spans[i][1]) && spans[i][0].Before(c.End) {
+				conflicts[r.event.Id] = append(conflicts[r.event.Id], c)
+			}
+		}
+	}
+	for _, list := range conflicts {
+		sort.SliceStable(list, func(i, j int) bool {
+			return list[i].Start.Before(list[j].Start)
+		})
+	}
+	return conflicts
+}
+
+// conflictField renders the conflicts of event as a reminder field, e.g.
+// "team@example.com is busy 09:30-10:00"
+func (b *Bot) conflictField(event *calendar.Event, conflicts []Conflict) slack.AttachmentField {
+	lines := make([]string, len(conflicts))
+	for i, c := range conflicts {
+		lines[i] = c.Calendar + " is busy " + b.displayTime(event, c.Start).Format("15:04") + "-" + b.displayTime(event, c.End).Format("15:04")
+	}
+	return slack.AttachmentField{
+		Title: "Conflicts",
+		Value: strings.Join(lines, "\n"),
+	}
+}
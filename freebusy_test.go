@@ -0,0 +1,96 @@
+package calendarbot
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+func TestFreeBusyConflicts(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	at := func(d time.Duration) string {
+		return now.Add(d).Format(time.RFC3339)
+	}
+	busy := func(from, to time.Duration) []*calendar.TimePeriod {
+		return []*calendar.TimePeriod{{Start: at(from), End: at(to)}}
+	}
+
+	tests := []struct {
+		name      string
+		calendars []string
+		freeBusy  *calendar.FreeBusyResponse
+		expect    map[string][]Conflict
+		requests  int
+		warned    bool
+	}{
+		{"disabled", nil, nil, nil, 1, false},
+		{"own calendar only", []string{"primary"}, nil, nil, 1, false},
+		{
+			name:      "conflicts",
+			calendars: []string{"team@example.com", "other@example.com", "Primary"},
+			freeBusy: &calendar.FreeBusyResponse{Calendars: map[string]calendar.FreeBusyCalendar{
+				"team@example.com":  {Busy: busy(30*time.Minute, 90*time.Minute)},
+				"other@example.com": {Busy: busy(2*time.Hour, 3*time.Hour)},
+				"primary":           {Busy: busy(5*time.Minute, 65*time.Minute)},
+			}},
+			expect: map[string][]Conflict{"a": {{
+				Calendar: "team@example.com",
+				Start:    now.Add(30 * time.Minute),
+				End:      now.Add(90 * time.Minute),
+			}}},
+			requests: 2,
+		},
+		{"failing", []string{"team@example.com"}, nil, nil, 2, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cal := &fakeCalendar{
+				events:   []*calendar.Event{testEvent("a", at(5*time.Minute), at(65*time.Minute))},
+				freeBusy: test.freeBusy,
+			}
+			rec := &recordingNotifier{}
+			logger := &recordingLogger{}
+			b := newTestBot()
+			b.FreeBusyCalendars = test.calendars
+			b.Logger = logger
+			b.Notifier = rec
+
+			if err := b.NotifyIndividualEvents(cal.context(), now, 10*time.Minute); err != nil {
+				t.Fatalf("NotifyIndividualEvents failed: %s", err)
+			}
+			if len(cal.requests) != test.requests {
+				t.Errorf("expected %d requests, got %d", test.requests, len(cal.requests))
+			}
+			if len(rec.notifications) != 1 {
+				t.Fatalf("expected 1 notification, got %d", len(rec.notifications))
+			}
+			if got := rec.notifications[0].Conflicts; len(got) != len(test.expect) || len(got) > 0 && !reflect.DeepEqual(got, test.expect) {
+				t.Errorf("expected conflicts %v, got %v", test.expect, got)
+			}
+
+			var warned bool
+			for _, msg := range logger.messages {
+				warned = warned || strings.HasPrefix(msg, "WARNING failed to look up conflicts")
+			}
+			if warned != test.warned {
+				t.Errorf("expected warning %t, got %q", test.warned, logger.messages)
+			}
+		})
+	}
+}
+
+func TestConflictField(t *testing.T) {
+	event := testEvent("a", "2017-01-10T09:00:00Z", "2017-01-10T10:00:00Z")
+	b := New()
+	f := b.conflictField(event, []Conflict{
+		{Calendar: "team@example.com", Start: mustParseTime(t, "2017-01-10T08:30:00Z"), End: mustParseTime(t, "2017-01-10T09:15:00Z")},
+		{Calendar: "room@example.com", Start: mustParseTime(t, "2017-01-10T09:45:00Z"), End: mustParseTime(t, "2017-01-10T11:00:00Z")},
+	})
+	expect := "team@example.com is busy 08:30-09:15\nroom@example.com is busy 09:45-11:00"
+	if f.Title != "Conflicts" || f.Value != expect {
+		t.Errorf("expected %q, got %+v", expect, f)
+	}
+}
@@ -0,0 +1,43 @@
+package calendarbot
+
+import (
+	"net/http"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"google.golang.org/api/googleapi"
+)
+
+// GroupMemberLister lists the email addresses of the members of a
+// G Suite group, e.g. using the Admin SDK Directory API
+type GroupMemberLister interface {
+	GroupMembers(ctx context.Context, group string) ([]string, error)
+}
+
+// GroupCalendars returns the IDs of the calendars of the members of
+// `group` that the bot can read, to be used as Calendars. Members
+// whose calendar is not shared with the bot are left out
+func (b *Bot) GroupCalendars(ctx context.Context, members GroupMemberLister, group string) ([]string, error) {
+	emails, err := members.GroupMembers(ctx, group)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list members of %s", group)
+	}
+
+	s, err := b.CalendarService(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create calendar service")
+	}
+
+	var ids []string
+	for _, email := range emails {
+		if _, err := s.Calendars.Get(email).Do(); err != nil {
+			if gerr, ok := err.(*googleapi.Error); ok && (gerr.Code == http.StatusNotFound || gerr.Code == http.StatusForbidden) {
+				b.Logger.Debugf(ctx, "calendar of %s is not accessible, skipping", email)
+				continue
+			}
+			return nil, errors.Wrapf(err, "failed to get calendar of %s", email)
+		}
+		ids = append(ids, email)
+	}
+	return ids, nil
+}
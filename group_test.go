@@ -0,0 +1,64 @@
+package calendarbot
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/calendar/v3"
+)
+
+type fakeGroups map[string][]string
+
+func (g fakeGroups) GroupMembers(_ context.Context, group string) ([]string, error) {
+	return g[group], nil
+}
+
+func TestGroupCalendars(t *testing.T) {
+	shared := func(summary, start, end string) *calendar.Event {
+		e := testEvent(summary, start, end)
+		e.Id = "shared"
+		return e
+	}
+	cal := &fakeCalendar{calendars: map[string][]*calendar.Event{
+		"alice@example.com": {
+			testEvent("alice standup", "2017-01-10T09:00:00Z", "2017-01-10T09:15:00Z"),
+			shared("team sync", "2017-01-10T11:00:00Z", "2017-01-10T12:00:00Z"),
+		},
+		"bob@example.com": {
+			testEvent("bob review", "2017-01-10T10:00:00Z", "2017-01-10T11:00:00Z"),
+			shared("team sync", "2017-01-10T11:00:00Z", "2017-01-10T12:00:00Z"),
+		},
+	}}
+	groups := fakeGroups{
+		"team@example.com": {"alice@example.com", "carol@example.com", "bob@example.com"},
+	}
+	b := newTestBot()
+
+	ids, err := b.GroupCalendars(cal.context(), groups, "team@example.com")
+	if err != nil {
+		t.Fatalf("GroupCalendars failed: %s", err)
+	}
+	// carol hasn't shared her calendar
+	expect := []string{"alice@example.com", "bob@example.com"}
+	if !reflect.DeepEqual(ids, expect) {
+		t.Errorf("expected %q, got %q", expect, ids)
+	}
+
+	b.Calendars = ids
+	params, err := b.upcomingMessage(cal.context(), mustParseTime(t, "2017-01-10T08:00:00Z"), 12*time.Hour)
+	if err != nil {
+		t.Fatalf("upcomingMessage failed: %s", err)
+	}
+	var values []string
+	for _, f := range params.Attachments[0].Fields {
+		values = append(values, f.Value[:strings.Index(f.Value, "<")])
+	}
+	// Events are merged by start time, and the shared event shows up once
+	expectValues := []string{"09:00-09:15: ", "10:00-11:00: ", "11:00-12:00: "}
+	if !reflect.DeepEqual(values, expectValues) {
+		t.Errorf("expected %q, got %q", expectValues, values)
+	}
+}
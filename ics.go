@@ -0,0 +1,159 @@
+package calendarbot
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// icsLink returns the URL at which ICSHandler serves event, or an empty
+// string if ICSURL is not set
+func (b *Bot) icsLink(event *calendar.Event) (string, error) {
+	if b.ICSURL == "" {
+		return "", nil
+	}
+	u, err := url.Parse(b.ICSURL)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to parse ICS URL")
+	}
+	q := u.Query()
+	q.Set("event", event.Id)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// ICSHandler returns a http.Handler that serves a single event as an
+// iCalendar file, so that it can be added to another calendar. The
+// event is given by its ID in the `event` parameter, and is looked up
+// in the calendars the agenda covers. Set Bot.ICSURL to where this
+// handler is mounted to link to it from the agenda
+func ICSHandler(b *Bot) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.FormValue("event")
+		if id == "" {
+			http.Error(w, "missing event", http.StatusBadRequest)
+			return
+		}
+
+		event, err := b.findEvent(r, id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if event == nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		buf, err := eventICS(event, time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", id+".ics"))
+		w.Write(buf)
+	})
+}
+
+// findEvent looks up the event `id` in each of calendarIDs, returning
+// nil if none of them has it
+func (b *Bot) findEvent(r *http.Request, id string) (*calendar.Event, error) {
+	s, err := b.CalendarService(r.Context())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create calendar service")
+	}
+
+	for _, calID := range b.calendarIDs() {
+		event, err := s.Events.Get(calID, id).Context(r.Context()).Do()
+		if err == nil {
+			return event, nil
+		}
+		if gerr, ok := err.(*googleapi.Error); ok && gerr.Code == http.StatusNotFound {
+			continue
+		}
+		return nil, errors.Wrapf(err, "failed to get event from %s", calID)
+	}
+	return nil, nil
+}
+
+// icsTimeLayout is the layout of UTC date-times in iCalendar files
+const icsTimeLayout = "20060102T150405Z"
+
+// eventICS renders event as an iCalendar file (RFC 5545), stamped with
+// `now`
+func eventICS(event *calendar.Event, now time.Time) ([]byte, error) {
+	start, end, allDay, err := eventTimes(event)
+	if err != nil {
+		return nil, err
+	}
+
+	uid := event.ICalUID
+	if uid == "" {
+		uid = event.Id
+	}
+
+	var buf bytes.Buffer
+	line := func(s string) {
+		buf.WriteString(foldICSLine(s))
+		buf.WriteString("\r\n")
+	}
+	line("BEGIN:VCALENDAR")
+	line("VERSION:2.0")
+	line("PRODID:-//lestrrat//google-calendarbot//EN")
+	line("BEGIN:VEVENT")
+	line("UID:" + escapeICS(uid))
+	line("DTSTAMP:" + now.UTC().Format(icsTimeLayout))
+	if allDay {
+		line("DTSTART;VALUE=DATE:" + start.Format("20060102"))
+		line("DTEND;VALUE=DATE:" + end.Format("20060102"))
+	} else {
+		line("DTSTART:" + start.UTC().Format(icsTimeLayout))
+		line("DTEND:" + end.UTC().Format(icsTimeLayout))
+	}
+	line("SUMMARY:" + escapeICS(event.Summary))
+	if event.Description != "" {
+		line("DESCRIPTION:" + escapeICS(event.Description))
+	}
+	if event.Location != "" {
+		line("LOCATION:" + escapeICS(event.Location))
+	}
+	if event.HtmlLink != "" {
+		line("URL:" + event.HtmlLink)
+	}
+	line("END:VEVENT")
+	line("END:VCALENDAR")
+	return buf.Bytes(), nil
+}
+
+var icsEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+
+// escapeICS escapes s for use as an iCalendar TEXT value
+func escapeICS(s string) string {
+	return icsEscaper.Replace(s)
+}
+
+// foldICSLine splits s into lines of at most 75 octets, as iCalendar
+// requires, without breaking up UTF-8 characters
+func foldICSLine(s string) string {
+	const max = 75
+	var buf bytes.Buffer
+	n := 0
+	for _, r := range s {
+		size := len(string(r))
+		if n+size > max {
+			buf.WriteString("\r\n ")
+			n = 1 // The leading space counts too
+		}
+		buf.WriteRune(r)
+		n += size
+	}
+	return buf.String()
+}
@@ -0,0 +1,163 @@
+package calendarbot
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+func TestICSLink(t *testing.T) {
+	event := testEvent("a b", "2017-01-10T09:00:00Z", "2017-01-10T10:00:00Z")
+	tests := []struct {
+		url    string
+		expect string
+	}{
+		{"", ""},
+		{"https://bot.example.com/ics", "https://bot.example.com/ics?event=a+b"},
+		{"https://bot.example.com/ics?key=secret", "https://bot.example.com/ics?event=a+b&key=secret"},
+	}
+	for _, test := range tests {
+		t.Run(test.url, func(t *testing.T) {
+			b := New()
+			b.ICSURL = test.url
+			got, err := b.icsLink(event)
+			if err != nil {
+				t.Fatalf("icsLink failed: %s", err)
+			}
+			if got != test.expect {
+				t.Errorf("expected %q, got %q", test.expect, got)
+			}
+		})
+	}
+}
+
+func TestAgendaICSLink(t *testing.T) {
+	b := New()
+	b.ICSURL = "https://bot.example.com/ics"
+	fields, err := b.agendaFields([]*calendar.Event{
+		testEvent("a", "2017-01-10T09:00:00Z", "2017-01-10T10:00:00Z"),
+	})
+	if err != nil {
+		t.Fatalf("agendaFields failed: %s", err)
+	}
+	expect := "09:00-10:00: <https://calendar.google.com/event?eid=a|a> <https://bot.example.com/ics?event=a|Add to calendar>"
+	if len(fields) != 1 || fields[0].Value != expect {
+		t.Errorf("expected %q, got %#v", expect, fields)
+	}
+}
+
+func TestEventICS(t *testing.T) {
+	now := mustParseTime(t, "2017-01-09T12:00:00Z")
+	timed := testEvent("a", "2017-01-10T18:00:00+09:00", "2017-01-10T19:00:00+09:00")
+	timed.ICalUID = "a@google.com"
+	timed.Summary = "Review; budget, Q1"
+	timed.Description = "1. intro\n2. demo"
+	timed.Location = "Room 101"
+
+	tests := []struct {
+		name   string
+		event  *calendar.Event
+		expect []string
+	}{
+		{"timed", timed, []string{
+			"BEGIN:VCALENDAR",
+			"VERSION:2.0",
+			"PRODID:-//lestrrat//google-calendarbot//EN",
+			"BEGIN:VEVENT",
+			"UID:a@google.com",
+			"DTSTAMP:20170109T120000Z",
+			"DTSTART:20170110T090000Z",
+			"DTEND:20170110T100000Z",
+			`SUMMARY:Review\; budget\, Q1`,
+			`DESCRIPTION:1. intro\n2. demo`,
+			"LOCATION:Room 101",
+			"URL:https://calendar.google.com/event?eid=a",
+			"END:VEVENT",
+			"END:VCALENDAR",
+		}},
+		{"all day", testAllDayEvent("holiday", "2017-01-10", "2017-01-11"), []string{
+			"BEGIN:VCALENDAR",
+			"VERSION:2.0",
+			"PRODID:-//lestrrat//google-calendarbot//EN",
+			"BEGIN:VEVENT",
+			"UID:holiday_20170110",
+			"DTSTAMP:20170109T120000Z",
+			"DTSTART;VALUE=DATE:20170110",
+			"DTEND;VALUE=DATE:20170111",
+			"SUMMARY:holiday",
+			"URL:https://calendar.google.com/event?eid=holiday",
+			"END:VEVENT",
+			"END:VCALENDAR",
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			buf, err := eventICS(test.event, now)
+			if err != nil {
+				t.Fatalf("eventICS failed: %s", err)
+			}
+			got := strings.Split(strings.TrimSuffix(string(buf), "\r\n"), "\r\n")
+			if !reflect.DeepEqual(got, test.expect) {
+				t.Errorf("expected %q, got %q", test.expect, got)
+			}
+		})
+	}
+}
+
+func TestFoldICSLine(t *testing.T) {
+	long := "SUMMARY:" + strings.Repeat("é", 40) // 88 octets
+	got := foldICSLine(long)
+	lines := strings.Split(got, "\r\n ")
+	if len(lines) != 2 || len(lines[0]) != 74 || lines[0]+lines[1] != long {
+		t.Errorf("unexpected folding %q", got)
+	}
+	if short := "SUMMARY:standup"; foldICSLine(short) != short {
+		t.Errorf("expected short lines to be left alone")
+	}
+}
+
+func TestICSHandler(t *testing.T) {
+	cal := &fakeCalendar{calendars: map[string][]*calendar.Event{
+		"primary":          {testEvent("mine", "2017-01-10T09:00:00Z", "2017-01-10T10:00:00Z")},
+		"team@example.com": {testEvent("team", "2017-01-10T11:00:00Z", "2017-01-10T12:00:00Z")},
+	}}
+	b := newTestBot()
+	b.Calendars = []string{"primary", "team@example.com"}
+	h := ICSHandler(b)
+
+	tests := []struct {
+		query   string
+		code    int
+		summary string
+	}{
+		{"event=mine", http.StatusOK, "SUMMARY:mine"},
+		{"event=team", http.StatusOK, "SUMMARY:team"},
+		{"event=other", http.StatusNotFound, ""},
+		{"", http.StatusBadRequest, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/ics?"+test.query, nil)
+			req = req.WithContext(cal.context())
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+
+			if w.Code != test.code {
+				t.Fatalf("expected %d, got %d: %s", test.code, w.Code, w.Body.String())
+			}
+			if test.code != http.StatusOK {
+				return
+			}
+			if ct := w.Header().Get("Content-Type"); ct != "text/calendar; charset=utf-8" {
+				t.Errorf("unexpected content type %s", ct)
+			}
+			if !strings.Contains(w.Body.String(), "\r\n"+test.summary+"\r\n") {
+				t.Errorf("expected %q in %q", test.summary, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package calendarbot
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+type idempotencyKeyType struct{}
+
+// withIdempotencyKey returns a context under which the messages posted
+// to Slack carry key as their client_msg_id
+func withIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKeyType{}, key)
+}
+
+// IdempotencyKey returns the key identifying the notification being
+// sent with ctx, or an empty string. Reminders for the same events at
+// the same start times always get the same key, so that a Notifier can
+// tell a retry from a new notification
+func IdempotencyKey(ctx context.Context) string {
+	key, _ := ctx.Value(idempotencyKeyType{}).(string)
+	return key
+}
+
+// reminderKey returns the idempotency key of a batch of reminders sent
+// to the notifier `name` (see Notifiers), formatted like a UUID as Slack
+// expects of a client_msg_id
+func reminderKey(name string, batch []reminder) string {
+	h := sha1.New()
+	if name != "" {
+		fmt.Fprintf(h, "%s\n", name)
+	}
+	for _, r := range batch {
+		fmt.Fprintf(h, "%s@%s\n", r.event.Id, r.start.UTC().Format(time.RFC3339))
+	}
+	sum := h.Sum(nil)
+	return fmt.Sprintf("%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
+
+// isTimeout reports whether err is a request timing out. The request
+// may or may not have been carried out in that case
+func isTimeout(err error) bool {
+	if errors.Cause(err) == context.DeadlineExceeded {
+		return true
+	}
+	nerr, ok := errors.Cause(err).(net.Error)
+	return ok && nerr.Timeout()
+}
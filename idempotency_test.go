@@ -0,0 +1,157 @@
+package calendarbot
+
+import (
+	"net/http"
+	"path"
+	"regexp"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/calendar/v3"
+)
+
+// timeoutError is what a request timing out returns
+type timeoutError struct{}
+
+func (_ timeoutError) Error() string   { return "i/o timeout" }
+func (_ timeoutError) Timeout() bool   { return true }
+func (_ timeoutError) Temporary() bool { return true }
+
+// timeoutTransport lets posts through to Slack, but times out waiting
+// for their response
+type timeoutTransport struct {
+	base http.RoundTripper
+}
+
+func (t timeoutTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	res, err := t.base.RoundTrip(r)
+	if err != nil || path.Base(r.URL.Path) != "chat.postMessage" {
+		return res, err
+	}
+	return nil, timeoutError{}
+}
+
+func TestReminderKey(t *testing.T) {
+	start := mustParseTime(t, "2017-01-10T09:00:00Z")
+	a := reminder{event: testEvent("a", "2017-01-10T09:00:00Z", "2017-01-10T10:00:00Z"), start: start}
+	b := reminder{event: testEvent("b", "2017-01-10T09:00:00Z", "2017-01-10T10:00:00Z"), start: start}
+	moved := reminder{event: a.event, start: start.Add(time.Hour)}
+
+	key := reminderKey("", []reminder{a})
+	if !regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`).MatchString(key) {
+		t.Errorf("expected a UUID, got %q", key)
+	}
+
+	tests := []struct {
+		name   string
+		batch  []reminder
+		expect bool
+	}{
+		{"same", []reminder{a}, true},
+		{"same time elsewhere", []reminder{{event: a.event, start: start.In(time.FixedZone("JST", 9*60*60))}}, true},
+		{"other event", []reminder{b}, false},
+		{"moved", []reminder{moved}, false},
+		{"batch", []reminder{a, b}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if same := reminderKey("", test.batch) == key; same != test.expect {
+				t.Errorf("expected same key to be %t, got %t", test.expect, same)
+			}
+		})
+	}
+}
+
+func TestIsTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 0)
+	defer cancel()
+	<-ctx.Done()
+
+	tests := []struct {
+		name   string
+		err    error
+		expect bool
+	}{
+		{"deadline", ctx.Err(), true},
+		{"net", timeoutError{}, true},
+		{"canceled", context.Canceled, false},
+		{"slack", errPaused, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := isTimeout(test.err); got != test.expect {
+				t.Errorf("expected %t, got %t", test.expect, got)
+			}
+		})
+	}
+}
+
+func TestIdempotentReminders(t *testing.T) {
+	start := time.Now().Add(10 * time.Minute).UTC()
+	events := []*calendar.Event{
+		testEvent("a", start.Format(time.RFC3339), start.Add(time.Hour).Format(time.RFC3339)),
+	}
+
+	tests := []struct {
+		name      string
+		timeout   bool
+		postError string
+		expect    int // posts after retrying
+	}{
+		{"timeout", true, "", 1},
+		{"failed", false, "rate_limited", 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cal := &fakeCalendar{events: events}
+			slackAPI := &fakeSlack{postError: test.postError}
+			b := newTestBot()
+			b.SlackTransport = func(http.RoundTripper) http.RoundTripper {
+				if test.timeout {
+					return timeoutTransport{slackAPI}
+				}
+				return slackAPI
+			}
+
+			if err := b.NotifyIndividualEvents(cal.context(), time.Now(), time.Hour); err == nil {
+				t.Fatal("expected the first attempt to fail")
+			}
+			b.NotifyIndividualEvents(cal.context(), time.Now(), time.Hour)
+
+			posts := slackAPI.posts()
+			if len(posts) != test.expect {
+				t.Fatalf("expected %d posts, got %d", test.expect, len(posts))
+			}
+			key := reminderKey("", []reminder{{event: events[0], start: mustParseTime(t, start.Format(time.RFC3339))}})
+			for _, post := range posts {
+				if id := post.Form.Get("client_msg_id"); id != key {
+					t.Errorf("expected client_msg_id %q, got %q", key, id)
+				}
+			}
+		})
+	}
+}
+
+func TestIdempotencyKeyNotifier(t *testing.T) {
+	start := time.Now().Add(10 * time.Minute).UTC()
+	cal := &fakeCalendar{events: []*calendar.Event{
+		testEvent("a", start.Format(time.RFC3339), start.Add(time.Hour).Format(time.RFC3339)),
+	}}
+	var keys []string
+	b := newTestBot()
+	b.Notifier = NotifierFunc(func(ctx context.Context, _ *Notification) error {
+		keys = append(keys, IdempotencyKey(ctx))
+		return nil
+	})
+
+	if err := b.NotifyIndividualEvents(cal.context(), time.Now(), time.Hour); err != nil {
+		t.Fatalf("NotifyIndividualEvents failed: %s", err)
+	}
+	if len(keys) != 1 || keys[0] == "" {
+		t.Errorf("expected the notifier to get a key, got %q", keys)
+	}
+	if key := IdempotencyKey(context.Background()); key != "" {
+		t.Errorf("expected no key outside of a notification, got %q", key)
+	}
+}
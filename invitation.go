@@ -0,0 +1,101 @@
+package calendarbot
+
+import (
+	"time"
+
+	"github.com/lestrrat/slack"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"google.golang.org/api/calendar/v3"
+)
+
+// NotifyNewInvitations sends one message to slack for each upcoming
+// event that someone else has invited you to since `since`, which
+// would usually be the time of the previous run. Each invitation is
+// only announced once.
+func (b *Bot) NotifyNewInvitations(ctx context.Context, since time.Time) error {
+	if b.Paused() {
+		return errPaused
+	}
+
+	s, err := b.CalendarService(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to create calendar service")
+	}
+
+	// Recurring events are not expanded, so that a new series is only
+	// announced once
+	events, err := b.listEvents(ctx, b.eventsList(s, calendarID(b.CalendarName)).
+		TimeMin(time.Now().Format(time.RFC3339)).
+		UpdatedMin(since.Format(time.RFC3339)))
+	if err != nil {
+		return errors.Wrap(err, "failed to list events")
+	}
+	events = b.fetchOmittedAttendees(ctx, s, calendarID(b.CalendarName), events)
+
+	for _, event := range b.newInvitations(ctx, b.filterEvents(events), since) {
+		key := "invitation:" + event.Id
+		announced, err := b.seen(ctx, key)
+		if err != nil {
+			return err
+		}
+		if announced {
+			b.debugEvent(ctx, event, "invitation has already been announced, skipping")
+			continue
+		}
+
+		params := b.slackParams(calendarID(b.CalendarName))
+		params.Attachments = []slack.Attachment{
+			slack.Attachment{
+				Fallback:  event.Summary,
+				ThumbURL:  b.SlackThumbURL,
+				Title:     event.Summary,
+				TitleLink: event.HtmlLink,
+			},
+		}
+		if event.Organizer != nil {
+			organizer := event.Organizer.DisplayName
+			if organizer == "" {
+				organizer = event.Organizer.Email
+			}
+			params.Attachments[0].Fields = []slack.AttachmentField{
+				slack.AttachmentField{
+					Title: "Organizer",
+					Value: organizer,
+				},
+			}
+		}
+		if err := b.postSlack(ctx, "New invitation", &params); err != nil {
+			return errors.Wrap(err, "failed to post message to slack")
+		}
+
+		b.Cache.Add(ctx, key, []byte{0x1}, invitationDedupTTL)
+	}
+	return nil
+}
+
+// invitationDedupTTL is how long an announced invitation is remembered
+const invitationDedupTTL = 24 * time.Hour
+
+// newInvitations returns the events in `events` that were created at or
+// after `since` by someone other than the calendar owner. Events with a
+// creation time that can't be parsed are skipped with a warning
+func (b *Bot) newInvitations(ctx context.Context, events []*calendar.Event, since time.Time) []*calendar.Event {
+	var list []*calendar.Event
+	for _, event := range events {
+		if event.Organizer != nil && event.Organizer.Self {
+			continue
+		}
+
+		created, err := time.Parse(time.RFC3339, event.Created)
+		if err != nil {
+			b.Logger.Warningf(ctx, "failed to parse creation time of %s, skipping: %s", event.Id, err)
+			continue
+		}
+		if created.Before(since) {
+			continue
+		}
+		list = append(list, event)
+	}
+	return list
+}
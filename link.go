@@ -0,0 +1,82 @@
+package calendarbot
+
+import (
+	"encoding/base64"
+	"net/url"
+	"strings"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+// InstanceLinkMode tells how links to instances of recurring events are
+// told apart, see Bot.InstanceLinks
+type InstanceLinkMode int
+
+const (
+	InstanceLinkEID    InstanceLinkMode = iota // Point the eid parameter at the instance, adding the date if the eid can't be read
+	InstanceLinkDate                           // Add the date of the instance as a `date` parameter
+	InstanceLinkSeries                         // Use the link Google returns as it is
+)
+
+// instanceDateLayout is the format of instance dates added to links
+const instanceDateLayout = "20060102"
+
+// eventLink returns the link to event. Instances of a recurring event
+// may all carry the link of the series, which makes them impossible to
+// tell apart in a list, so their links are made specific according to
+// InstanceLinks
+func (b *Bot) eventLink(event *calendar.Event) string {
+	if event.HtmlLink == "" || event.RecurringEventId == "" || b.InstanceLinks == InstanceLinkSeries {
+		return event.HtmlLink
+	}
+	u, err := url.Parse(event.HtmlLink)
+	if err != nil {
+		return event.HtmlLink
+	}
+
+	q := u.Query()
+	if b.InstanceLinks == InstanceLinkEID {
+		// The eid is the event ID and the calendar ID, separated by a
+		// space and base64 encoded
+		if fields, ok := decodeEID(q.Get("eid")); ok {
+			if fields[0] == event.Id {
+				return event.HtmlLink
+			}
+			fields[0] = event.Id
+			q.Set("eid", base64.RawURLEncoding.EncodeToString([]byte(strings.Join(fields, " "))))
+			u.RawQuery = q.Encode()
+			return u.String()
+		}
+	}
+
+	dt := event.OriginalStartTime
+	if dt == nil {
+		dt = event.Start
+	}
+	if dt == nil {
+		return event.HtmlLink
+	}
+	t, _, err := parseEventDateTime(dt)
+	if err != nil {
+		return event.HtmlLink
+	}
+	q.Set("date", t.Format(instanceDateLayout))
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// decodeEID splits an eid into the event ID and the rest
+func decodeEID(eid string) ([]string, bool) {
+	if eid == "" {
+		return nil, false
+	}
+	buf, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(eid, "="))
+	if err != nil {
+		return nil, false
+	}
+	fields := strings.SplitN(string(buf), " ", 2)
+	if len(fields) < 2 || fields[0] == "" {
+		return nil, false
+	}
+	return fields, true
+}
@@ -0,0 +1,89 @@
+package calendarbot
+
+import (
+	"encoding/base64"
+	"reflect"
+	"testing"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+func testEID(s string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(s))
+}
+
+// testInstance returns an instance of the recurring event `series`
+// starting at `start`, linked to the series like Google sometimes does
+func testInstance(t *testing.T, series, start string) *calendar.Event {
+	t.Helper()
+	st := mustParseTime(t, start)
+	event := testEvent(series, start, st.Add(time.Hour).Format(time.RFC3339))
+	event.Id = series + "_" + st.Format("20060102T150405Z")
+	event.RecurringEventId = series
+	event.OriginalStartTime = &calendar.EventDateTime{DateTime: start}
+	event.HtmlLink = "https://calendar.google.com/event?eid=" + testEID(series+" me@example.com")
+	return event
+}
+
+func TestEventLink(t *testing.T) {
+	instance := testInstance(t, "standup", "2017-01-10T09:00:00Z")
+
+	moved := testInstance(t, "standup", "2017-01-11T09:00:00Z")
+	moved.Start.DateTime = "2017-01-12T09:00:00Z"
+
+	specific := testInstance(t, "standup", "2017-01-13T09:00:00Z")
+	specific.HtmlLink = "https://calendar.google.com/event?eid=" + testEID(specific.Id+" me@example.com")
+
+	opaque := testInstance(t, "standup", "2017-01-14T09:00:00Z")
+	opaque.HtmlLink = "https://calendar.google.com/event?eid=not-base64!"
+
+	allDay := testAllDayEvent("holiday", "2017-01-16", "2017-01-17")
+	allDay.RecurringEventId = "holiday"
+	allDay.Id = "holiday_20170116"
+
+	single := testEvent("a", "2017-01-10T09:00:00Z", "2017-01-10T10:00:00Z")
+
+	tests := []struct {
+		name   string
+		mode   InstanceLinkMode
+		event  *calendar.Event
+		expect string
+	}{
+		{"eid", InstanceLinkEID, instance, "https://calendar.google.com/event?eid=" + testEID("standup_20170110T090000Z me@example.com")},
+		{"eid of a moved instance", InstanceLinkEID, moved, "https://calendar.google.com/event?eid=" + testEID("standup_20170111T090000Z me@example.com")},
+		{"eid already specific", InstanceLinkEID, specific, specific.HtmlLink},
+		{"unreadable eid", InstanceLinkEID, opaque, "https://calendar.google.com/event?date=20170114&eid=not-base64%21"},
+		{"date", InstanceLinkDate, instance, "https://calendar.google.com/event?date=20170110&eid=" + testEID("standup me@example.com")},
+		{"date of a moved instance", InstanceLinkDate, moved, "https://calendar.google.com/event?date=20170111&eid=" + testEID("standup me@example.com")},
+		{"date of an all-day instance", InstanceLinkDate, allDay, "https://calendar.google.com/event?date=20170116&eid=holiday"},
+		{"series", InstanceLinkSeries, instance, instance.HtmlLink},
+		{"not recurring", InstanceLinkEID, single, single.HtmlLink},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := New()
+			b.InstanceLinks = test.mode
+			if link := b.eventLink(test.event); link != test.expect {
+				t.Errorf("expected %q, got %q", test.expect, link)
+			}
+		})
+	}
+}
+
+func TestAgendaInstanceLinks(t *testing.T) {
+	events := []*calendar.Event{
+		testInstance(t, "standup", "2017-01-10T09:00:00Z"),
+		testInstance(t, "standup", "2017-01-10T15:00:00Z"),
+	}
+
+	b := New()
+	expect := []string{
+		"09:00-10:00: <https://calendar.google.com/event?eid=" + testEID("standup_20170110T090000Z me@example.com") + "|standup>",
+		"15:00-16:00: <https://calendar.google.com/event?eid=" + testEID("standup_20170110T150000Z me@example.com") + "|standup>",
+	}
+	values := fieldValues(t, b, events)
+	if !reflect.DeepEqual(values, expect) {
+		t.Errorf("expected %q, got %q", expect, values)
+	}
+}
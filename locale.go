@@ -0,0 +1,76 @@
+package calendarbot
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Locale selects the language of the text the bot writes, as a language
+// tag such as "en" or "de-AT". Only the base language is used to pick
+// the translation, and unknown languages fall back to English
+type Locale string
+
+const (
+	English Locale = "en"
+	German  Locale = "de"
+)
+
+// pluralForms holds the variants of a message for a count. Both English
+// and German use `one` for exactly 1 and `other` for everything else
+type pluralForms struct {
+	one   string
+	other string
+}
+
+// Message keys used with Bot.translate
+const (
+	msgStartingNow        = "starting_now"
+	msgAboutToStart       = "about_to_start"
+	msgStartsIn           = "starts_in"
+	msgStartsInAbout      = "starts_in_about"
+	msgEventsStartingSoon = "events_starting_soon"
+)
+
+var catalog = map[Locale]map[string]pluralForms{
+	English: {
+		msgStartingNow:        {"This event is starting now", "This event is starting now"},
+		msgAboutToStart:       {"This event is about to start", "This event is about to start"},
+		msgStartsIn:           {"This event starts in %d minute", "This event starts in %d minutes"},
+		msgStartsInAbout:      {"This event starts in about %d minute", "This event starts in about %d minutes"},
+		msgEventsStartingSoon: {"%d event is starting soon", "%d events are starting soon"},
+	},
+	German: {
+		msgStartingNow:        {"Dieser Termin beginnt jetzt", "Dieser Termin beginnt jetzt"},
+		msgAboutToStart:       {"Dieser Termin beginnt gleich", "Dieser Termin beginnt gleich"},
+		msgStartsIn:           {"Dieser Termin beginnt in %d Minute", "Dieser Termin beginnt in %d Minuten"},
+		msgStartsInAbout:      {"Dieser Termin beginnt in etwa %d Minute", "Dieser Termin beginnt in etwa %d Minuten"},
+		msgEventsStartingSoon: {"%d Termin beginnt bald", "%d Termine beginnen bald"},
+	},
+}
+
+// base returns the language part of the tag, e.g. "de" for "de-AT"
+func (l Locale) base() Locale {
+	s := strings.ToLower(string(l))
+	if i := strings.IndexAny(s, "-_"); i >= 0 {
+		s = s[:i]
+	}
+	return Locale(s)
+}
+
+// translate renders the message `key` for the count `n` in the bot's
+// Locale
+func (b *Bot) translate(key string, n int) string {
+	messages, ok := catalog[b.Locale.base()]
+	if !ok {
+		messages = catalog[English]
+	}
+	forms := messages[key]
+	format := forms.other
+	if n == 1 {
+		format = forms.one
+	}
+	if !strings.Contains(format, "%d") {
+		return format
+	}
+	return fmt.Sprintf(format, n)
+}
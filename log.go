@@ -0,0 +1,38 @@
+package calendarbot
+
+import (
+	"fmt"
+	"unicode/utf8"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/calendar/v3"
+)
+
+// Logger receives diagnostic messages from the bot. The method set
+// follows google.golang.org/appengine/log, so adapting either that
+// package or a standard library logger only takes a few lines.
+type Logger interface {
+	Debugf(context.Context, string, ...interface{})
+	Warningf(context.Context, string, ...interface{})
+}
+
+type nullLogger struct{}
+
+func (_ nullLogger) Debugf(_ context.Context, _ string, _ ...interface{})   {}
+func (_ nullLogger) Warningf(_ context.Context, _ string, _ ...interface{}) {}
+
+// RedactLength replaces s with its length, keeping event titles and
+// descriptions out of the logs. It is the default Bot.LogRedactor
+func RedactLength(s string) string {
+	return fmt.Sprintf("[%d chars]", utf8.RuneCountInString(s))
+}
+
+// debugEvent logs msg along with the event, passing its summary through
+// LogRedactor first
+func (b *Bot) debugEvent(ctx context.Context, event *calendar.Event, msg string) {
+	redact := b.LogRedactor
+	if redact == nil {
+		redact = RedactLength
+	}
+	b.Logger.Debugf(ctx, "%s: %s %s", msg, event.Id, redact(event.Summary))
+}
@@ -0,0 +1,123 @@
+package calendarbot
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/lestrrat/slack"
+	"github.com/pkg/errors"
+)
+
+// slackMetadata is attached to messages with SlackMetadata, so that
+// other Slack apps can react to them. See
+// https://api.slack.com/metadata
+type slackMetadata struct {
+	EventType    string               `json:"event_type"`
+	EventPayload slackMetadataPayload `json:"event_payload"`
+}
+
+type slackMetadataPayload struct {
+	Calendars []string             `json:"calendars"`
+	Events    []slackMetadataEvent `json:"events"`
+}
+
+type slackMetadataEvent struct {
+	ID    string `json:"event_id"`
+	Start string `json:"start"`
+}
+
+// notificationMetadata returns the metadata for n, or nil unless
+// SlackMetadata is set
+func (b *Bot) notificationMetadata(n *Notification) (*slackMetadata, error) {
+	if !b.SlackMetadata {
+		return nil, nil
+	}
+
+	meta := &slackMetadata{
+		EventType: "calendar_agenda",
+		EventPayload: slackMetadataPayload{
+			Calendars: b.calendarIDs(),
+			Events:    make([]slackMetadataEvent, 0, len(n.Events)),
+		},
+	}
+	if n.Kind == ReminderNotification {
+		meta.EventType = "calendar_reminder"
+		// Reminders come from one calendar, whatever the agenda merges
+		if n.Calendar != "" {
+			meta.EventPayload.Calendars = []string{n.Calendar}
+		}
+	}
+	for _, event := range n.Events {
+		start, _, _, err := eventTimes(event)
+		if err != nil {
+			return nil, err
+		}
+		meta.EventPayload.Events = append(meta.EventPayload.Events, slackMetadataEvent{
+			ID:    event.Id,
+			Start: start.Format(time.RFC3339),
+		})
+	}
+	return meta, nil
+}
+
+// withMetadata makes slackcl add meta to the messages it posts. The
+// slack client has no notion of metadata, so it is added to the
+// chat.postMessage request on its way out
+func withMetadata(slackcl *slack.Client, meta *slackMetadata) error {
+	buf, err := json.Marshal(meta)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode metadata")
+	}
+	withPostField(slackcl, "metadata", string(buf))
+	return nil
+}
+
+// withPostField makes slackcl set the form field `name` of the messages
+// it posts to value
+func withPostField(slackcl *slack.Client, name, value string) {
+	var base http.RoundTripper = http.DefaultTransport
+	if slackcl.HTTPClient != nil && slackcl.HTTPClient.Transport != nil {
+		base = slackcl.HTTPClient.Transport
+	}
+	slackcl.HTTPClient = &http.Client{Transport: &postFieldTransport{
+		base:  base,
+		name:  name,
+		value: value,
+	}}
+}
+
+type postFieldTransport struct {
+	base  http.RoundTripper
+	name  string
+	value string
+}
+
+func (t *postFieldTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if !strings.HasSuffix(r.URL.Path, "/chat.postMessage") || r.Body == nil {
+		return t.base.RoundTrip(r)
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read request")
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse request")
+	}
+	form.Set(t.name, t.value)
+	body = []byte(form.Encode())
+
+	// RoundTrippers must not modify the request
+	r2 := new(http.Request)
+	*r2 = *r
+	r2.Body = ioutil.NopCloser(bytes.NewReader(body))
+	r2.ContentLength = int64(len(body))
+	return t.base.RoundTrip(r2)
+}
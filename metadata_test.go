@@ -0,0 +1,108 @@
+package calendarbot
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+func TestSlackMetadata(t *testing.T) {
+	start := mustParseTime(t, "2017-01-10T08:00:00Z")
+	events := []*calendar.Event{
+		testEvent("a", "2017-01-10T09:00:00Z", "2017-01-10T10:00:00Z"),
+		testAllDayEvent("holiday", "2017-01-10", "2017-01-11"),
+	}
+
+	tests := []struct {
+		name    string
+		enabled bool
+		expect  *slackMetadata
+	}{
+		{name: "disabled"},
+		{
+			name:    "enabled",
+			enabled: true,
+			expect: &slackMetadata{
+				EventType: "calendar_agenda",
+				EventPayload: slackMetadataPayload{
+					Calendars: []string{"primary"},
+					Events: []slackMetadataEvent{
+						{ID: "holiday_20170110", Start: "2017-01-10T00:00:00Z"},
+						{ID: "a", Start: "2017-01-10T09:00:00Z"},
+					},
+				},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cal := &fakeCalendar{events: events}
+			slackAPI := &fakeSlack{}
+			b := newTestBot()
+			b.SlackMetadata = test.enabled
+			b.SlackTransport = slackAPI.transport
+
+			if err := b.NotifyUpcomingEvents(cal.context(), start, 24*time.Hour); err != nil {
+				t.Fatalf("NotifyUpcomingEvents failed: %s", err)
+			}
+			posts := slackAPI.posts()
+			if len(posts) != 1 {
+				t.Fatalf("expected 1 post, got %d", len(posts))
+			}
+			form := posts[0].Form
+			if form.Get("attachments") == "" {
+				t.Errorf("expected the rest of the message to be intact, got %v", form)
+			}
+
+			raw, ok := form["metadata"]
+			if test.expect == nil {
+				if ok {
+					t.Errorf("expected no metadata, got %s", raw)
+				}
+				return
+			}
+			var meta slackMetadata
+			if err := json.Unmarshal([]byte(form.Get("metadata")), &meta); err != nil {
+				t.Fatalf("failed to decode metadata: %s", err)
+			}
+			if !reflect.DeepEqual(&meta, test.expect) {
+				t.Errorf("expected %#v, got %#v", test.expect, &meta)
+			}
+		})
+	}
+}
+
+func TestSlackMetadataReminder(t *testing.T) {
+	start := time.Now().Add(10 * time.Minute).UTC().Truncate(time.Second)
+	cal := &fakeCalendar{events: []*calendar.Event{
+		testEvent("a", start.Format(time.RFC3339), start.Add(time.Hour).Format(time.RFC3339)),
+	}}
+	slackAPI := &fakeSlack{}
+	b := newTestBot()
+	b.Calendars = []string{"team@example.com", "primary"}
+	b.SlackMetadata = true
+	b.SlackTransport = slackAPI.transport
+
+	if err := b.NotifyIndividualEvents(cal.context(), time.Now(), time.Hour); err != nil {
+		t.Fatalf("NotifyIndividualEvents failed: %s", err)
+	}
+	posts := slackAPI.posts()
+	if len(posts) != 1 {
+		t.Fatalf("expected 1 post, got %d", len(posts))
+	}
+	var meta slackMetadata
+	if err := json.Unmarshal([]byte(posts[0].Form.Get("metadata")), &meta); err != nil {
+		t.Fatalf("failed to decode metadata: %s", err)
+	}
+	expect := []slackMetadataEvent{{ID: "a", Start: start.Format(time.RFC3339)}}
+	if meta.EventType != "calendar_reminder" || !reflect.DeepEqual(meta.EventPayload.Events, expect) {
+		t.Errorf("unexpected metadata %#v", meta)
+	}
+	// Only the calendar the reminder is from, not the agenda's
+	if expect := []string{"primary"}; !reflect.DeepEqual(meta.EventPayload.Calendars, expect) {
+		t.Errorf("expected calendars %q, got %q", expect, meta.EventPayload.Calendars)
+	}
+}
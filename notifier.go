@@ -0,0 +1,234 @@
+package calendarbot
+
+import (
+	"sort"
+	"time"
+
+	"github.com/lestrrat/slack"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"google.golang.org/api/calendar/v3"
+)
+
+// NotificationKind tells what a Notification is about
+type NotificationKind int
+
+const (
+	AgendaNotification   NotificationKind = iota // Events between Start and End, see NotifyUpcomingEvents
+	ReminderNotification                         // Events that are about to start, see NotifyIndividualEvents
+)
+
+// Notification is sent to a Notifier
+type Notification struct {
+	Kind      NotificationKind
+	Title     string // Agenda header, describing all events before any routing
+	Text      string // Reminder text, e.g. "This event starts in 10 minutes"
+	Calendar  string // ID of the calendar the events come from, empty if there are several
+	Start     time.Time
+	End       time.Time
+	Events    []*calendar.Event
+	Conflicts map[string][]Conflict // Busy times in FreeBusyCalendars during reminded events, by event ID
+
+	reminderText func([]*calendar.Event) string // Renders Text for a share of Events, see RoutingNotifier
+}
+
+// Notifier delivers notifications, see Bot.Notifier
+type Notifier interface {
+	Notify(context.Context, *Notification) error
+}
+
+// NotifierFunc is a function that can be used as a Notifier
+type NotifierFunc func(context.Context, *Notification) error
+
+func (f NotifierFunc) Notify(ctx context.Context, n *Notification) error {
+	return f(ctx, n)
+}
+
+// NotifierRule sends the events for which Match returns true to
+// Notifier
+type NotifierRule struct {
+	Match    func(*calendar.Event) bool
+	Notifier Notifier
+}
+
+// RoutingNotifier splits notifications by event. Each event goes to
+// the first rule that matches it, or to Default if none do. Events
+// that don't match any rule are dropped if Default is nil. Each
+// notifier receives at most one notification, with its share of the
+// events in their original order. The text of reminders is worded
+// for that share.
+type RoutingNotifier struct {
+	Rules   []NotifierRule
+	Default Notifier
+}
+
+func (r *RoutingNotifier) Notify(ctx context.Context, n *Notification) error {
+	shares := make([][]*calendar.Event, len(r.Rules)+1) // The last one is Default's
+	for _, event := range n.Events {
+		i := len(r.Rules)
+		for j, rule := range r.Rules {
+			if rule.Match(event) {
+				i = j
+				break
+			}
+		}
+		shares[i] = append(shares[i], event)
+	}
+
+	var err error
+	for i, events := range shares {
+		if len(events) == 0 {
+			continue
+		}
+
+		notifier := r.Default
+		if i < len(r.Rules) {
+			notifier = r.Rules[i].Notifier
+		}
+		if notifier == nil {
+			continue
+		}
+
+		share := *n
+		share.Events = events
+		if n.reminderText != nil {
+			share.Text = n.reminderText(events)
+		}
+		// Keep going, so that one failing notifier doesn't affect the others
+		if nerr := notifier.Notify(ctx, &share); nerr != nil && err == nil {
+			err = errors.Wrapf(nerr, "failed to notify rule %d", i)
+		}
+	}
+	return err
+}
+
+// SlackNotifier returns a Notifier that posts to Slack, which is what
+// the bot does if Notifier is not set
+func (b *Bot) SlackNotifier() Notifier {
+	return slackNotifier{bot: b}
+}
+
+type slackNotifier struct {
+	bot *Bot
+}
+
+func (s slackNotifier) Notify(ctx context.Context, n *Notification) error {
+	b := s.bot
+	switch n.Kind {
+	case AgendaNotification:
+		params, err := b.slackAgenda(n)
+		if err != nil {
+			return err
+		}
+		meta, err := b.notificationMetadata(n)
+		if err != nil {
+			return err
+		}
+		return b.postSlackReaction(ctx, "", params, meta, b.AgendaReaction)
+	case ReminderNotification:
+		// A batch of reminders, see BatchWindow. Routing and threads
+		// are per event, so then each event is posted on its own
+		if len(n.Events) > 1 && !b.RouteToOrganizer && !b.ThreadReminders {
+			params := b.slackParams(n.Calendar)
+			for _, event := range n.Events {
+				start, _, _, err := eventTimes(event)
+				if err != nil {
+					return err
+				}
+				attachment := b.reminderAttachment(event, start)
+				attachment.Color = b.reminderColor(ctx, event, start, time.Now())
+				if conflicts := n.Conflicts[event.Id]; len(conflicts) > 0 {
+					attachment.Fields = append(attachment.Fields, b.conflictField(event, conflicts))
+				}
+				params.Attachments = append(params.Attachments, attachment)
+			}
+			meta, err := b.notificationMetadata(n)
+			if err != nil {
+				return err
+			}
+			return b.postSlackMetadata(ctx, n.Text, &params, meta)
+		}
+
+		for _, event := range n.Events {
+			start, _, _, err := eventTimes(event)
+			if err != nil {
+				return err
+			}
+
+			single := *n
+			single.Events = []*calendar.Event{event}
+			if len(n.Events) > 1 && n.reminderText != nil {
+				single.Text = n.reminderText(single.Events)
+			}
+			meta, err := b.notificationMetadata(&single)
+			if err != nil {
+				return err
+			}
+
+			attachment := b.reminderAttachment(event, start)
+			attachment.Color = b.reminderColor(ctx, event, start, time.Now())
+			if conflicts := n.Conflicts[event.Id]; len(conflicts) > 0 {
+				attachment.Fields = append(attachment.Fields, b.conflictField(event, conflicts))
+			}
+			params := b.slackParams(n.Calendar)
+			params.Attachments = []slack.Attachment{attachment}
+			if err := b.postReminder(ctx, event, single.Text, &params, meta); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return errors.Errorf("unknown notification kind %d", n.Kind)
+	}
+}
+
+// notifier returns the notifiers in Notifiers as one, or else Notifier,
+// or the Slack notifier if neither is set
+func (b *Bot) notifier() Notifier {
+	if len(b.Notifiers) > 0 {
+		return fanoutNotifier(b.namedNotifiers())
+	}
+	if b.Notifier != nil {
+		return b.Notifier
+	}
+	return b.SlackNotifier()
+}
+
+type namedNotifier struct {
+	name     string
+	notifier Notifier
+}
+
+// namedNotifiers returns Notifiers ordered by name, or notifier() named
+// "" if Notifiers is not set
+func (b *Bot) namedNotifiers() []namedNotifier {
+	if len(b.Notifiers) == 0 {
+		return []namedNotifier{{"", b.notifier()}}
+	}
+
+	names := make([]string, 0, len(b.Notifiers))
+	for name := range b.Notifiers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	list := make([]namedNotifier, len(names))
+	for i, name := range names {
+		list[i] = namedNotifier{name, b.Notifiers[name]}
+	}
+	return list
+}
+
+// fanoutNotifier sends each notification to all of its notifiers
+type fanoutNotifier []namedNotifier
+
+func (f fanoutNotifier) Notify(ctx context.Context, n *Notification) error {
+	var err error
+	for _, nn := range f {
+		// Keep going, so that one failing notifier doesn't affect the others
+		if nerr := nn.notifier.Notify(ctx, n); nerr != nil && err == nil {
+			err = errors.Wrapf(nerr, "failed to notify %s", nn.name)
+		}
+	}
+	return err
+}
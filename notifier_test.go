@@ -0,0 +1,260 @@
+package calendarbot
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"google.golang.org/api/calendar/v3"
+)
+
+// recordingNotifier remembers the IDs of the events it was sent
+type recordingNotifier struct {
+	notifications []*Notification
+	err           error
+}
+
+func (r *recordingNotifier) Notify(_ context.Context, n *Notification) error {
+	r.notifications = append(r.notifications, n)
+	return r.err
+}
+
+func (r *recordingNotifier) eventIDs() [][]string {
+	var ids [][]string
+	for _, n := range r.notifications {
+		var list []string
+		for _, event := range n.Events {
+			list = append(list, event.Id)
+		}
+		ids = append(ids, list)
+	}
+	return ids
+}
+
+func TestRoutingNotifier(t *testing.T) {
+	events := []*calendar.Event{
+		testEvent("[ops] deploy", "2017-01-10T09:00:00Z", "2017-01-10T10:00:00Z"),
+		testEvent("lunch", "2017-01-10T12:00:00Z", "2017-01-10T13:00:00Z"),
+		testEvent("[hr] interview", "2017-01-10T13:00:00Z", "2017-01-10T14:00:00Z"),
+		testEvent("[ops] retro", "2017-01-10T15:00:00Z", "2017-01-10T16:00:00Z"),
+	}
+	prefix := func(p string) func(*calendar.Event) bool {
+		return func(event *calendar.Event) bool {
+			return strings.HasPrefix(event.Summary, p)
+		}
+	}
+	always := func(*calendar.Event) bool { return true }
+
+	tests := []struct {
+		name        string
+		rules       []func(*calendar.Event) bool
+		withDefault bool
+		expect      [][][]string // Per rule, then the default
+	}{
+		{
+			name:        "split with default",
+			rules:       []func(*calendar.Event) bool{prefix("[ops]"), prefix("[hr]")},
+			withDefault: true,
+			expect: [][][]string{
+				{{"[ops] deploy", "[ops] retro"}},
+				{{"[hr] interview"}},
+				{{"lunch"}},
+			},
+		},
+		{
+			name:  "without default",
+			rules: []func(*calendar.Event) bool{prefix("[ops]")},
+			expect: [][][]string{
+				{{"[ops] deploy", "[ops] retro"}},
+				nil,
+			},
+		},
+		{
+			name:        "first match wins",
+			rules:       []func(*calendar.Event) bool{prefix("[hr]"), always, prefix("[ops]")},
+			withDefault: true,
+			expect: [][][]string{
+				{{"[hr] interview"}},
+				{{"[ops] deploy", "lunch", "[ops] retro"}},
+				nil,
+				nil,
+			},
+		},
+		{
+			name:        "default only",
+			withDefault: true,
+			expect: [][][]string{
+				{{"[ops] deploy", "lunch", "[hr] interview", "[ops] retro"}},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var recorders []*recordingNotifier
+			r := &RoutingNotifier{}
+			for _, match := range test.rules {
+				rec := &recordingNotifier{}
+				recorders = append(recorders, rec)
+				r.Rules = append(r.Rules, NotifierRule{Match: match, Notifier: rec})
+			}
+			def := &recordingNotifier{}
+			recorders = append(recorders, def)
+			if test.withDefault {
+				r.Default = def
+			}
+
+			n := &Notification{Kind: AgendaNotification, Title: "agenda", Events: events}
+			if err := r.Notify(context.Background(), n); err != nil {
+				t.Fatalf("Notify failed: %s", err)
+			}
+			var got [][][]string
+			for _, rec := range recorders {
+				got = append(got, rec.eventIDs())
+				for _, sent := range rec.notifications {
+					if sent.Title != "agenda" || sent.Kind != AgendaNotification {
+						t.Errorf("expected the notification to be copied, got %#v", sent)
+					}
+				}
+			}
+			if !reflect.DeepEqual(got, test.expect) {
+				t.Errorf("expected %q, got %q", test.expect, got)
+			}
+			if len(n.Events) != len(events) {
+				t.Errorf("expected the original notification to be unchanged")
+			}
+		})
+	}
+}
+
+func TestRoutingNotifierError(t *testing.T) {
+	failing := &recordingNotifier{err: errors.New("unreachable")}
+	def := &recordingNotifier{}
+	r := &RoutingNotifier{
+		Rules: []NotifierRule{
+			{Match: func(e *calendar.Event) bool { return e.Id == "a" }, Notifier: failing},
+		},
+		Default: def,
+	}
+
+	n := &Notification{Events: []*calendar.Event{
+		testEvent("a", "2017-01-10T09:00:00Z", "2017-01-10T10:00:00Z"),
+		testEvent("b", "2017-01-10T10:00:00Z", "2017-01-10T11:00:00Z"),
+	}}
+	err := r.Notify(context.Background(), n)
+	if err == nil || errors.Cause(err) != failing.err {
+		t.Errorf("expected the notifier's error, got %v", err)
+	}
+	if len(def.notifications) != 1 {
+		t.Errorf("expected the default notifier to be notified despite the error")
+	}
+}
+
+func TestRoutingNotifierReminderText(t *testing.T) {
+	now := mustParseTime(t, "2017-01-10T08:50:00Z")
+	var batch []reminder
+	for _, id := range []string{"a", "b", "c"} {
+		event := testEvent(id, "2017-01-10T09:00:00Z", "2017-01-10T10:00:00Z")
+		batch = append(batch, reminder{event: event, start: mustParseTime(t, event.Start.DateTime)})
+	}
+	n := newTestBot().reminderNotification("primary", batch, "", now)
+	if expect := "3 events are starting soon"; n.Text != expect {
+		t.Errorf("expected %q, got %q", expect, n.Text)
+	}
+
+	single := &recordingNotifier{}
+	rest := &recordingNotifier{}
+	r := &RoutingNotifier{
+		Rules: []NotifierRule{
+			{Match: func(e *calendar.Event) bool { return e.Id == "a" }, Notifier: single},
+		},
+		Default: rest,
+	}
+	if err := r.Notify(context.Background(), n); err != nil {
+		t.Fatalf("Notify failed: %s", err)
+	}
+	if expect := "This event starts in 10 minutes"; len(single.notifications) != 1 || single.notifications[0].Text != expect {
+		t.Errorf("expected %q for the single event, got %+v", expect, single.notifications)
+	}
+	if expect := "2 events are starting soon"; len(rest.notifications) != 1 || rest.notifications[0].Text != expect {
+		t.Errorf("expected %q for the rest, got %+v", expect, rest.notifications)
+	}
+}
+
+func TestBotNotifier(t *testing.T) {
+	start := mustParseTime(t, "2017-01-10T08:00:00Z")
+	cal := &fakeCalendar{events: []*calendar.Event{
+		testEvent("a", "2017-01-10T09:00:00Z", "2017-01-10T10:00:00Z"),
+	}}
+	var got []*Notification
+	b := newTestBot()
+	b.Notifier = NotifierFunc(func(_ context.Context, n *Notification) error {
+		got = append(got, n)
+		return nil
+	})
+
+	if err := b.NotifyUpcomingEvents(cal.context(), start, 12*time.Hour); err != nil {
+		t.Fatalf("NotifyUpcomingEvents failed: %s", err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("expected 1 notification, got %d", len(got))
+	}
+	n := got[0]
+	if n.Kind != AgendaNotification || n.Title != "Upcoming events between 2017 Jan 10 08:00 to 2017 Jan 10 20:00" {
+		t.Errorf("unexpected notification %#v", n)
+	}
+	if !n.Start.Equal(start) || !n.End.Equal(start.Add(12*time.Hour)) || len(n.Events) != 1 {
+		t.Errorf("unexpected notification %#v", n)
+	}
+}
+
+func TestNotifiersRetryFailed(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	cal := &fakeCalendar{events: []*calendar.Event{
+		testEvent("a", now.Add(5*time.Minute).Format(time.RFC3339), now.Add(time.Hour).Format(time.RFC3339)),
+	}}
+	chat := &recordingNotifier{}
+	mail := &recordingNotifier{err: errors.New("mail server down")}
+	b := newTestBot()
+	b.Notifiers = map[string]Notifier{"chat": chat, "mail": mail}
+
+	err := b.NotifyIndividualEvents(cal.context(), now, 10*time.Minute)
+	if err == nil || !strings.Contains(err.Error(), "failed to notify mail: mail server down") {
+		t.Fatalf("expected the mail error, got %v", err)
+	}
+
+	// Only the failed notifier is tried again
+	mail.err = nil
+	for i := 0; i < 2; i++ {
+		if err := b.NotifyIndividualEvents(cal.context(), now, 10*time.Minute); err != nil {
+			t.Fatalf("NotifyIndividualEvents failed: %s", err)
+		}
+	}
+	if expect := [][]string{{"a"}}; !reflect.DeepEqual(chat.eventIDs(), expect) {
+		t.Errorf("expected chat to get %q, got %q", expect, chat.eventIDs())
+	}
+	if expect := [][]string{{"a"}, {"a"}}; !reflect.DeepEqual(mail.eventIDs(), expect) {
+		t.Errorf("expected mail to get %q, got %q", expect, mail.eventIDs())
+	}
+}
+
+func TestNotifiersAgenda(t *testing.T) {
+	start := mustParseTime(t, "2017-01-10T08:00:00Z")
+	cal := &fakeCalendar{events: []*calendar.Event{
+		testEvent("a", "2017-01-10T09:00:00Z", "2017-01-10T10:00:00Z"),
+	}}
+	chat := &recordingNotifier{}
+	mail := &recordingNotifier{err: errors.New("mail server down")}
+	b := newTestBot()
+	b.Notifiers = map[string]Notifier{"chat": chat, "mail": mail}
+
+	err := b.NotifyUpcomingEvents(cal.context(), start, 12*time.Hour)
+	if err == nil || !strings.Contains(err.Error(), "failed to notify mail") {
+		t.Fatalf("expected the mail error, got %v", err)
+	}
+	if len(chat.notifications) != 1 || len(mail.notifications) != 1 {
+		t.Errorf("expected 1 notification each, got %d and %d", len(chat.notifications), len(mail.notifications))
+	}
+}
@@ -0,0 +1,57 @@
+package calendarbot
+
+import (
+	"github.com/lestrrat/slack"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+type presenceGetter interface {
+	GetUserPresence(string) (*slack.UserPresence, error)
+}
+
+// activeUser returns the first of `candidates` (Slack user IDs) whose
+// presence is "active", or an empty string if none of them are
+func activeUser(slackcl presenceGetter, candidates []string) (string, error) {
+	for _, user := range candidates {
+		presence, err := slackcl.GetUserPresence(user)
+		if err != nil {
+			return "", errors.Wrapf(missingScope(err, "users.getPresence"), "failed to get presence for %s", user)
+		}
+		if presence.Presence == "active" {
+			return user, nil
+		}
+	}
+	return "", nil
+}
+
+// mention returns the text used to mention the first active user in
+// PresenceCandidates, or an empty string if nobody is around. Failing
+// to look up presence only skips the mention
+func (b *Bot) mention(ctx context.Context) string {
+	if len(b.PresenceCandidates) == 0 {
+		return ""
+	}
+
+	slackcl, err := b.slackClient(ctx)
+	if err != nil {
+		b.Logger.Warningf(ctx, "failed to create slack client for presence lookup: %s", err)
+		return ""
+	}
+
+	getter, ok := slackcl.(presenceGetter)
+	if !ok {
+		b.Logger.Warningf(ctx, "slack client can't look up presence, not mentioning anyone")
+		return ""
+	}
+
+	user, err := activeUser(getter, b.PresenceCandidates)
+	if err != nil {
+		b.Logger.Warningf(ctx, "failed to look up presence: %s", err)
+		return ""
+	}
+	if user == "" {
+		return ""
+	}
+	return "<@" + user + "> "
+}
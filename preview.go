@@ -0,0 +1,65 @@
+package calendarbot
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/lestrrat/slack"
+)
+
+type previewMessage struct {
+	Channel     string             `json:"channel"`
+	Username    string             `json:"username,omitempty"`
+	Text        string             `json:"text"`
+	Attachments []slack.Attachment `json:"attachments"`
+}
+
+// PreviewHandler returns a http.Handler that renders the agenda that
+// NotifyUpcomingEvents would post, without posting it. The agenda
+// covers `window` (a duration such as "24h", 24 hours by default)
+// starting at `start` (RFC3339, now by default). The message is
+// returned as JSON, or with a "204 No Content" if nothing would be posted
+func PreviewHandler(b *Bot) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t := time.Now()
+		if v := r.FormValue("start"); v != "" {
+			var err error
+			if t, err = time.Parse(time.RFC3339, v); err != nil {
+				http.Error(w, "invalid start: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		window := 24 * time.Hour
+		if v := r.FormValue("window"); v != "" {
+			var err error
+			if window, err = time.ParseDuration(v); err != nil || window <= 0 {
+				http.Error(w, "invalid window: "+v, http.StatusBadRequest)
+				return
+			}
+		}
+
+		params, err := b.upcomingMessage(r.Context(), t, window)
+		if IsSuppressed(err) {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if params == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(previewMessage{
+			Channel:     b.SlackChannel,
+			Username:    params.Username,
+			Text:        params.Text,
+			Attachments: params.Attachments,
+		})
+	})
+}
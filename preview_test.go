@@ -0,0 +1,92 @@
+package calendarbot
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+func TestPreviewHandler(t *testing.T) {
+	cal := &fakeCalendar{
+		events: []*calendar.Event{
+			testEvent("standup", "2017-01-10T09:00:00Z", "2017-01-10T09:15:00Z"),
+			testEvent("review", "2017-01-10T14:00:00Z", "2017-01-10T15:00:00Z"),
+		},
+	}
+	b := newTestBot()
+	h := PreviewHandler(b)
+
+	req := httptest.NewRequest("GET", "/preview?window=12h&start=2017-01-10T08:00:00Z", nil)
+	req = req.WithContext(cal.context())
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("expected JSON, got %s", ct)
+	}
+
+	var msg previewMessage
+	if err := json.Unmarshal(w.Body.Bytes(), &msg); err != nil {
+		t.Fatalf("failed to decode response: %s", err)
+	}
+	if msg.Channel != "general" || msg.Username != "calendarbot" {
+		t.Errorf("unexpected channel/username %s/%s", msg.Channel, msg.Username)
+	}
+	if len(msg.Attachments) != 1 {
+		t.Fatalf("expected 1 attachment, got %d", len(msg.Attachments))
+	}
+	a := msg.Attachments[0]
+	if a.Title != "Upcoming events between 2017 Jan 10 08:00 to 2017 Jan 10 20:00" {
+		t.Errorf("unexpected title %q", a.Title)
+	}
+	if len(a.Fields) != 2 || a.Fields[0].Value != "09:00-09:15: <https://calendar.google.com/event?eid=standup|standup>" {
+		t.Errorf("unexpected fields %#v", a.Fields)
+	}
+
+	if len(cal.requests) != 1 {
+		t.Fatalf("expected 1 calendar request, got %d", len(cal.requests))
+	}
+	q := cal.requests[0].URL.Query()
+	if q.Get("timeMin") != "2017-01-10T08:00:00Z" || q.Get("timeMax") != "2017-01-10T20:00:00Z" {
+		t.Errorf("unexpected time range %s - %s", q.Get("timeMin"), q.Get("timeMax"))
+	}
+}
+
+func TestPreviewHandlerNoEvents(t *testing.T) {
+	cal := &fakeCalendar{}
+	h := PreviewHandler(newTestBot())
+
+	req := httptest.NewRequest("GET", "/preview", nil)
+	req = req.WithContext(cal.context())
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNoContent {
+		t.Errorf("expected 204, got %d", w.Code)
+	}
+}
+
+func TestPreviewHandlerBadRequest(t *testing.T) {
+	cal := &fakeCalendar{}
+	h := PreviewHandler(newTestBot())
+
+	for _, q := range []string{"window=tomorrow", "window=-1h", "start=today"} {
+		req := httptest.NewRequest("GET", "/preview?"+q, nil)
+		req = req.WithContext(cal.context())
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", q, w.Code)
+		}
+	}
+	if len(cal.requests) != 0 {
+		t.Errorf("expected no calendar requests, got %d", len(cal.requests))
+	}
+}
@@ -0,0 +1,216 @@
+package calendarbot
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/lestrrat/slack"
+	"google.golang.org/api/calendar/v3"
+)
+
+// reminderAttachment creates the attachment describing a single event,
+// as posted by NotifyIndividualEvents
+func (b *Bot) reminderAttachment(event *calendar.Event, start time.Time) slack.Attachment {
+	when := "All day"
+	if !isAllDay(event) {
+		when = b.displayTime(event, start).Format("15:04")
+	}
+	fields := []slack.AttachmentField{
+		slack.AttachmentField{
+			Title: "Start Time",
+			Value: when,
+		},
+	}
+	if event.Location != "" {
+		fields = append(fields, slack.AttachmentField{
+			Title: "Location",
+			Value: locationText(event.Location),
+		})
+	}
+	if link := joinURL(event); link != "" {
+		fields = append(fields, slack.AttachmentField{
+			Title: "Video Call",
+			Value: "<" + link + "|Join>",
+		})
+	}
+	var markdownIn []string
+	if txt := event.Description; txt != "" {
+		if b.DescriptionAsCodeBlock {
+			txt = codeFence + "\n" + txt + "\n" + codeFence
+			markdownIn = []string{"fields"}
+		}
+		fields = append(fields, slack.AttachmentField{
+			Title: "Description",
+			Value: txt,
+		})
+	}
+	if txt := attendeeSummary(event.Attendees, b.MaxAttendeeNames, event.AttendeesOmitted); txt != "" {
+		fields = append(fields, slack.AttachmentField{
+			Title: "Attendees",
+			Value: txt,
+		})
+	}
+
+	title := event.Summary
+	if isOutOfOffice(event) {
+		title += " (out of office)"
+	}
+	if isFocusTime(event) {
+		title += " (focus time)"
+	}
+	if b.ShowHiddenInvitations && pendingResponse(event) {
+		title += " (pending response)"
+	}
+	if b.MarkSoleAttendee && soleRequiredAttendee(event) {
+		title += " (you're expected)"
+	}
+
+	var footer string
+	if b.ShowEventID {
+		footer = "Event ID: " + event.Id
+	}
+
+	return slack.Attachment{
+		Fallback:   event.Summary,
+		Fields:     capFields(fields, b.MaxFields, b.MaxFieldsSize),
+		Footer:     footer,
+		MarkdownIn: markdownIn,
+		ThumbURL:   b.SlackThumbURL,
+		Title:      title,
+		TitleLink:  b.eventLink(event),
+	}
+}
+
+// codeFence marks the start and end of a code block in Slack
+const codeFence = "```"
+
+// capFields keeps at most max fields, and at most size characters of
+// titles and values in total. Cut values end in "…", and a last field
+// tells how many fields were left out. Zero means no limit
+func capFields(fields []slack.AttachmentField, max, size int) []slack.AttachmentField {
+	if max > 0 && len(fields) > max {
+		// Make room for the indicator, but show at least one field
+		keep := max - 1
+		if keep < 1 {
+			keep = 1
+		}
+		// Limit the capacity so that appending doesn't overwrite fields
+		return append(capFields(fields[:keep:keep], 0, size), omittedField(len(fields)-keep))
+	}
+	if size <= 0 {
+		return fields
+	}
+
+	var capped []slack.AttachmentField
+	for i, f := range fields {
+		n := utf8.RuneCountInString(f.Title) + utf8.RuneCountInString(f.Value)
+		if n <= size {
+			capped = append(capped, f)
+			size -= n
+			continue
+		}
+		if left := size - utf8.RuneCountInString(f.Title); left > 0 {
+			f.Value = truncateValue(f.Value, left)
+			capped = append(capped, f)
+			i++
+		}
+		if omitted := len(fields) - i; omitted > 0 {
+			capped = append(capped, omittedField(omitted))
+		}
+		break
+	}
+	return capped
+}
+
+func omittedField(n int) slack.AttachmentField {
+	if n == 1 {
+		return slack.AttachmentField{Value: "(1 more field not shown)"}
+	}
+	return slack.AttachmentField{Value: fmt.Sprintf("(%d more fields not shown)", n)}
+}
+
+// truncateValue cuts v to n characters, including the trailing "…". A
+// code block stays closed
+func truncateValue(v string, n int) string {
+	if utf8.RuneCountInString(v) <= n {
+		return v
+	}
+	open, close := "", ""
+	if strings.HasPrefix(v, codeFence+"\n") && strings.HasSuffix(v, "\n"+codeFence) {
+		open, close = codeFence+"\n", "\n"+codeFence
+		v = strings.TrimSuffix(strings.TrimPrefix(v, open), close)
+		n -= len(open) + len(close)
+	}
+	if n <= 1 {
+		return "…"
+	}
+	return open + string([]rune(v)[:n-1]) + "…" + close
+}
+
+// leadText tells how long it is until an event starts, rounded to the
+// nearest RoundLeadTo, in the bot's Locale
+func (b *Bot) leadText(diff time.Duration) string {
+	// See StartTolerance
+	if diff < 0 {
+		return b.translate(msgStartingNow, 0)
+	}
+
+	if b.RoundLeadTo <= 0 {
+		return b.translate(msgStartsIn, int(diff.Minutes()))
+	}
+
+	rounded := (diff + b.RoundLeadTo/2) / b.RoundLeadTo * b.RoundLeadTo
+	switch {
+	case rounded <= 0:
+		return b.translate(msgAboutToStart, 0)
+	case rounded.Minutes() == float64(int(diff.Minutes())):
+		return b.translate(msgStartsIn, int(rounded.Minutes()))
+	default:
+		return b.translate(msgStartsInAbout, int(rounded.Minutes()))
+	}
+}
+
+// attendeeSummary lists the names of the attendees, showing at most
+// `max` of them followed by "+N more". Resources such as meeting rooms
+// are not included. If `omitted` is set, Google left out some of the
+// attendees (see Bot.MaxAttendees), so the list is marked as partial
+func attendeeSummary(attendees []*calendar.EventAttendee, max int, omitted bool) string {
+	var names []string
+	for _, attendee := range attendees {
+		if attendee.Resource {
+			continue
+		}
+		name := attendee.DisplayName
+		if name == "" {
+			name = attendee.Email
+		}
+		names = append(names, name)
+	}
+
+	var buf bytes.Buffer
+	for i, name := range names {
+		if max > 0 && i == max {
+			if omitted {
+				fmt.Fprintf(&buf, " +%d or more", len(names)-max)
+			} else {
+				fmt.Fprintf(&buf, " +%d more", len(names)-max)
+			}
+			return buf.String()
+		}
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		buf.WriteString(name)
+	}
+	if omitted {
+		if buf.Len() > 0 {
+			buf.WriteString(" and others")
+		} else {
+			buf.WriteString("Others")
+		}
+	}
+	return buf.String()
+}
@@ -0,0 +1,486 @@
+package calendarbot
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/lestrrat/slack"
+	"google.golang.org/api/calendar/v3"
+)
+
+func testAttendees(names ...string) []*calendar.EventAttendee {
+	list := make([]*calendar.EventAttendee, len(names))
+	for i, name := range names {
+		list[i] = &calendar.EventAttendee{
+			DisplayName: name,
+			Email:       name + "@example.com",
+		}
+	}
+	return list
+}
+
+func TestAttendeeSummary(t *testing.T) {
+	room := &calendar.EventAttendee{
+		DisplayName: "Room 101",
+		Email:       "room101@resource.example.com",
+		Resource:    true,
+	}
+	anonymous := &calendar.EventAttendee{Email: "anon@example.com"}
+
+	tests := []struct {
+		name      string
+		attendees []*calendar.EventAttendee
+		max       int
+		omitted   bool
+		expect    string
+	}{
+		{
+			name:   "no attendees",
+			max:    3,
+			expect: "",
+		},
+		{
+			name:      "below limit",
+			attendees: testAttendees("alice", "bob"),
+			max:       3,
+			expect:    "alice, bob",
+		},
+		{
+			name:      "at limit",
+			attendees: testAttendees("alice", "bob", "carol"),
+			max:       3,
+			expect:    "alice, bob, carol",
+		},
+		{
+			name:      "above limit",
+			attendees: testAttendees("alice", "bob", "carol", "dave", "eve"),
+			max:       3,
+			expect:    "alice, bob, carol +2 more",
+		},
+		{
+			name:      "no limit",
+			attendees: testAttendees("alice", "bob", "carol", "dave", "eve"),
+			max:       0,
+			expect:    "alice, bob, carol, dave, eve",
+		},
+		{
+			name:      "resources and missing names",
+			attendees: append(testAttendees("alice"), room, anonymous),
+			max:       3,
+			expect:    "alice, anon@example.com",
+		},
+		{
+			name:      "omitted below limit",
+			attendees: testAttendees("alice", "bob"),
+			max:       3,
+			omitted:   true,
+			expect:    "alice, bob and others",
+		},
+		{
+			name:      "omitted above limit",
+			attendees: testAttendees("alice", "bob", "carol", "dave", "eve"),
+			max:       3,
+			omitted:   true,
+			expect:    "alice, bob, carol +2 or more",
+		},
+		{
+			name:      "omitted resources only",
+			attendees: []*calendar.EventAttendee{room},
+			max:       3,
+			omitted:   true,
+			expect:    "Others",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := attendeeSummary(test.attendees, test.max, test.omitted); got != test.expect {
+				t.Errorf("expected %q, got %q", test.expect, got)
+			}
+		})
+	}
+}
+
+func TestReminderAttachmentAttendees(t *testing.T) {
+	b := New()
+	b.MaxAttendeeNames = 2
+
+	event := testEvent("a", "2017-01-10T09:00:00Z", "2017-01-10T10:00:00Z")
+	event.Attendees = testAttendees("alice", "bob", "carol")
+
+	attachment := b.reminderAttachment(event, mustParseTime(t, event.Start.DateTime))
+	f, ok := attachmentField(attachment, "Attendees")
+	if !ok {
+		t.Fatalf("attendees field not found")
+	}
+	if f.Value != "alice, bob +1 more" {
+		t.Errorf("unexpected attendee summary %q", f.Value)
+	}
+}
+
+func attachmentField(a slack.Attachment, title string) (slack.AttachmentField, bool) {
+	for _, f := range a.Fields {
+		if f.Title == title {
+			return f, true
+		}
+	}
+	return slack.AttachmentField{}, false
+}
+
+func TestReminderAttachmentDescription(t *testing.T) {
+	event := testEvent("a", "2017-01-10T09:00:00Z", "2017-01-10T10:00:00Z")
+	event.Description = "1. intro\n2. demo"
+
+	tests := []struct {
+		name       string
+		codeBlock  bool
+		expect     string
+		markdownIn []string
+	}{
+		{
+			name:   "plain",
+			expect: "1. intro\n2. demo",
+		},
+		{
+			name:       "code block",
+			codeBlock:  true,
+			expect:     "```\n1. intro\n2. demo\n```",
+			markdownIn: []string{"fields"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := New()
+			b.DescriptionAsCodeBlock = test.codeBlock
+
+			attachment := b.reminderAttachment(event, mustParseTime(t, event.Start.DateTime))
+			f, ok := attachmentField(attachment, "Description")
+			if !ok {
+				t.Fatalf("description field not found")
+			}
+			if f.Value != test.expect {
+				t.Errorf("expected %q, got %q", test.expect, f.Value)
+			}
+			if !reflect.DeepEqual(attachment.MarkdownIn, test.markdownIn) {
+				t.Errorf("expected mrkdwn_in %q, got %q", test.markdownIn, attachment.MarkdownIn)
+			}
+		})
+	}
+}
+
+func TestReminderAttachmentEventID(t *testing.T) {
+	event := testEvent("a", "2017-01-10T09:00:00Z", "2017-01-10T10:00:00Z")
+	event.Id = "3f9a0c2b7d_20170110T090000Z"
+
+	b := New()
+	attachment := b.reminderAttachment(event, mustParseTime(t, event.Start.DateTime))
+	if attachment.Footer != "" {
+		t.Errorf("expected no footer, got %q", attachment.Footer)
+	}
+
+	b.ShowEventID = true
+	attachment = b.reminderAttachment(event, mustParseTime(t, event.Start.DateTime))
+	if attachment.Footer != "Event ID: 3f9a0c2b7d_20170110T090000Z" {
+		t.Errorf("expected event ID in footer, got %q", attachment.Footer)
+	}
+}
+
+func TestLeadText(t *testing.T) {
+	tests := []struct {
+		diff   time.Duration
+		round  time.Duration
+		expect string
+	}{
+		{13 * time.Minute, 0, "This event starts in 13 minutes"},
+		{-5 * time.Second, 0, "This event is starting now"},
+		{-5 * time.Second, 5 * time.Minute, "This event is starting now"},
+		{13*time.Minute + 40*time.Second, 0, "This event starts in 13 minutes"},
+		{13 * time.Minute, 5 * time.Minute, "This event starts in about 15 minutes"},
+		{12 * time.Minute, 5 * time.Minute, "This event starts in about 10 minutes"},
+		{15 * time.Minute, 5 * time.Minute, "This event starts in 15 minutes"},
+		{12*time.Minute + 30*time.Second, 5 * time.Minute, "This event starts in about 15 minutes"},
+		{2 * time.Minute, 5 * time.Minute, "This event is about to start"},
+		{3 * time.Minute, 5 * time.Minute, "This event starts in about 5 minutes"},
+		{22 * time.Minute, 15 * time.Minute, "This event starts in about 15 minutes"},
+		{23 * time.Minute, 15 * time.Minute, "This event starts in about 30 minutes"},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%s by %s", test.diff, test.round), func(t *testing.T) {
+			b := New()
+			b.RoundLeadTo = test.round
+			if got := b.leadText(test.diff); got != test.expect {
+				t.Errorf("expected %q, got %q", test.expect, got)
+			}
+		})
+	}
+}
+
+func TestLeadTextLocale(t *testing.T) {
+	tests := []struct {
+		locale Locale
+		diff   time.Duration
+		round  time.Duration
+		expect string
+	}{
+		{"", 90 * time.Minute, 0, "This event starts in 90 minutes"},
+		{English, 1 * time.Minute, 0, "This event starts in 1 minute"},
+		{English, 0, 0, "This event starts in 0 minutes"},
+		{English, -5 * time.Second, 0, "This event is starting now"},
+		{German, 90 * time.Minute, 0, "Dieser Termin beginnt in 90 Minuten"},
+		{German, 1 * time.Minute, 0, "Dieser Termin beginnt in 1 Minute"},
+		{German, 13 * time.Minute, 5 * time.Minute, "Dieser Termin beginnt in etwa 15 Minuten"},
+		{German, 2 * time.Minute, 5 * time.Minute, "Dieser Termin beginnt gleich"},
+		{German, -5 * time.Second, 0, "Dieser Termin beginnt jetzt"},
+		{"de-AT", 5 * time.Minute, 0, "Dieser Termin beginnt in 5 Minuten"},
+		{"fr", 5 * time.Minute, 0, "This event starts in 5 minutes"},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%s %s by %s", test.locale, test.diff, test.round), func(t *testing.T) {
+			b := New()
+			b.Locale = test.locale
+			b.RoundLeadTo = test.round
+			if got := b.leadText(test.diff); got != test.expect {
+				t.Errorf("expected %q, got %q", test.expect, got)
+			}
+		})
+	}
+}
+
+func TestReminderAttachmentAllDay(t *testing.T) {
+	b := New()
+	event := testAllDayEvent("holiday", "2017-01-10", "2017-01-11")
+	start, _, _, err := eventTimes(event)
+	if err != nil {
+		t.Fatalf("eventTimes failed: %s", err)
+	}
+	if f, _ := attachmentField(b.reminderAttachment(event, start), "Start Time"); f.Value != "All day" {
+		t.Errorf("expected \"All day\", got %q", f.Value)
+	}
+}
+
+func TestCapFields(t *testing.T) {
+	fields := []slack.AttachmentField{
+		{Title: "Start Time", Value: "09:00"},
+		{Title: "Description", Value: "1. intro\n2. demo"},
+		{Title: "Attendees", Value: "Alice, Bob"},
+	}
+	tests := []struct {
+		name   string
+		max    int
+		size   int
+		expect []slack.AttachmentField
+	}{
+		{"no limit", 0, 0, fields},
+		{"within limits", 3, 100, fields},
+		{"too many", 2, 0, []slack.AttachmentField{
+			{Title: "Start Time", Value: "09:00"},
+			{Value: "(2 more fields not shown)"},
+		}},
+		{"one", 1, 0, []slack.AttachmentField{
+			{Title: "Start Time", Value: "09:00"},
+			{Value: "(2 more fields not shown)"},
+		}},
+		{"too long", 0, 28, []slack.AttachmentField{
+			{Title: "Start Time", Value: "09:00"},
+			{Title: "Description", Value: "1…"},
+			{Value: "(1 more field not shown)"},
+		}},
+		{"title too long", 0, 20, []slack.AttachmentField{
+			{Title: "Start Time", Value: "09:00"},
+			{Value: "(2 more fields not shown)"},
+		}},
+		{"both", 2, 12, []slack.AttachmentField{
+			{Title: "Start Time", Value: "0…"},
+			{Value: "(2 more fields not shown)"},
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := capFields(fields, test.max, test.size); !reflect.DeepEqual(got, test.expect) {
+				t.Errorf("expected %#v, got %#v", test.expect, got)
+			}
+		})
+	}
+}
+
+func TestReminderAttachmentMaxFields(t *testing.T) {
+	event := testEvent("a", "2017-01-10T09:00:00Z", "2017-01-10T10:00:00Z")
+	event.Description = strings.Repeat("agenda item\n", 500)
+	event.Attendees = []*calendar.EventAttendee{{Email: "alice@example.com"}}
+
+	b := New()
+	b.DescriptionAsCodeBlock = true
+	b.MaxFields = 2
+	b.MaxFieldsSize = 100
+	a := b.reminderAttachment(event, mustParseTime(t, "2017-01-10T09:00:00Z"))
+	if len(a.Fields) != 2 {
+		t.Fatalf("expected 2 fields, got %#v", a.Fields)
+	}
+	if a.Fields[1].Value != "(2 more fields not shown)" {
+		t.Errorf("expected the omitted fields to be counted, got %q", a.Fields[1].Value)
+	}
+
+	b.MaxFields = 0
+	a = b.reminderAttachment(event, mustParseTime(t, "2017-01-10T09:00:00Z"))
+	f, _ := attachmentField(a, "Description")
+	if n := utf8.RuneCountInString("Start Time09:00Description" + f.Value); n != 100 {
+		t.Errorf("expected 100 characters, got %d", n)
+	}
+	if !strings.HasPrefix(f.Value, "```\n") || !strings.HasSuffix(f.Value, "…\n```") {
+		t.Errorf("expected a closed code block, got %q", f.Value)
+	}
+	if _, ok := attachmentField(a, "Attendees"); ok {
+		t.Errorf("expected attendees to be left out")
+	}
+}
+
+func TestReminderAttachmentPendingResponse(t *testing.T) {
+	event := testEvent("a", "2017-01-10T09:00:00Z", "2017-01-10T10:00:00Z")
+	event.Attendees = []*calendar.EventAttendee{
+		{Email: "me@example.com", Self: true, ResponseStatus: "needsAction"},
+	}
+
+	tests := []struct {
+		show   bool
+		expect string
+	}{
+		{false, "a"},
+		{true, "a (pending response)"},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprint(test.show), func(t *testing.T) {
+			b := New()
+			b.ShowHiddenInvitations = test.show
+			if got := b.reminderAttachment(event, mustParseTime(t, "2017-01-10T09:00:00Z")).Title; got != test.expect {
+				t.Errorf("expected title %q, got %q", test.expect, got)
+			}
+		})
+	}
+}
+
+func TestSoleRequiredAttendee(t *testing.T) {
+	me := &calendar.EventAttendee{Email: "me@example.com", Self: true}
+	organizer := &calendar.EventAttendee{Email: "boss@example.com", Organizer: true}
+	room := &calendar.EventAttendee{Email: "room@resource.calendar.google.com", Resource: true}
+	tests := []struct {
+		name      string
+		attendees []*calendar.EventAttendee
+		omitted   bool
+		expect    string
+	}{
+		{"required solo", []*calendar.EventAttendee{organizer, me, room}, false, "a (you're expected)"},
+		{"optional others", []*calendar.EventAttendee{organizer, me, {Email: "cc@example.com", Optional: true}}, false, "a (you're expected)"},
+		{"optional", []*calendar.EventAttendee{organizer, {Email: "me@example.com", Self: true, Optional: true}}, false, "a"},
+		{"group", []*calendar.EventAttendee{organizer, me, {Email: "peer@example.com"}}, false, "a"},
+		{"organizer", []*calendar.EventAttendee{{Email: "me@example.com", Self: true, Organizer: true}, {Email: "peer@example.com"}}, false, "a"},
+		{"not invited", []*calendar.EventAttendee{organizer, {Email: "peer@example.com"}}, false, "a"},
+		{"omitted", []*calendar.EventAttendee{organizer, me}, true, "a"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			event := testEvent("a", "2017-01-10T09:00:00Z", "2017-01-10T10:00:00Z")
+			event.Attendees = test.attendees
+			event.AttendeesOmitted = test.omitted
+
+			b := New()
+			b.MarkSoleAttendee = true
+			if got := b.reminderAttachment(event, mustParseTime(t, "2017-01-10T09:00:00Z")).Title; got != test.expect {
+				t.Errorf("expected title %q, got %q", test.expect, got)
+			}
+
+			// Only when enabled
+			b.MarkSoleAttendee = false
+			if got := b.reminderAttachment(event, mustParseTime(t, "2017-01-10T09:00:00Z")).Title; got != "a" {
+				t.Errorf("expected title %q, got %q", "a", got)
+			}
+		})
+	}
+}
+
+func TestReminderAttachmentLocation(t *testing.T) {
+	tests := []struct {
+		location string
+		expect   string
+		ok       bool
+	}{
+		{"", "", false},
+		{"Room 101", "Room 101", true},
+		{"1-2-3 Shibuya, Tokyo", "1-2-3 Shibuya, Tokyo", true},
+		{"https://meet.google.com/abc-defg-hij", "<https://meet.google.com/abc-defg-hij>", true},
+		{"http://example.com/call", "<http://example.com/call>", true},
+		{"https://example.com/call and room 3", "https://example.com/call and room 3", true},
+		{"meet.google.com/abc-defg-hij", "meet.google.com/abc-defg-hij", true},
+	}
+	for _, test := range tests {
+		t.Run(test.location, func(t *testing.T) {
+			event := testEvent("a", "2017-01-10T09:00:00Z", "2017-01-10T10:00:00Z")
+			event.Location = test.location
+
+			a := New().reminderAttachment(event, mustParseTime(t, "2017-01-10T09:00:00Z"))
+			f, ok := attachmentField(a, "Location")
+			if ok != test.ok || f.Value != test.expect {
+				t.Errorf("expected location %q (%t), got %q (%t)", test.expect, test.ok, f.Value, ok)
+			}
+		})
+	}
+}
+
+func TestReminderAttachmentJoinURL(t *testing.T) {
+	conference := func(entries ...*calendar.EntryPoint) *calendar.ConferenceData {
+		return &calendar.ConferenceData{EntryPoints: entries}
+	}
+	phone := &calendar.EntryPoint{EntryPointType: "phone", Uri: "tel:+1-555-0100"}
+	video := &calendar.EntryPoint{EntryPointType: "video", Uri: "https://zoom.example.com/j/123"}
+
+	tests := []struct {
+		name       string
+		hangout    string
+		conference *calendar.ConferenceData
+		expect     string
+	}{
+		{"neither", "", nil, ""},
+		{"hangout", "https://meet.google.com/abc-defg-hij", nil, "<https://meet.google.com/abc-defg-hij|Join>"},
+		{"conference", "", conference(phone, video), "<https://zoom.example.com/j/123|Join>"},
+		{"both", "https://meet.google.com/abc-defg-hij", conference(video), "<https://meet.google.com/abc-defg-hij|Join>"},
+		{"no video", "", conference(phone), ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			event := testEvent("a", "2017-01-10T09:00:00Z", "2017-01-10T10:00:00Z")
+			event.HangoutLink = test.hangout
+			event.ConferenceData = test.conference
+
+			a := New().reminderAttachment(event, mustParseTime(t, "2017-01-10T09:00:00Z"))
+			f, ok := attachmentField(a, "Video Call")
+			if ok != (test.expect != "") || f.Value != test.expect {
+				t.Errorf("expected %q, got %q (%t)", test.expect, f.Value, ok)
+			}
+		})
+	}
+}
+
+func TestReminderAttachmentEventType(t *testing.T) {
+	tests := []struct {
+		eventType string
+		expect    string
+	}{
+		{"", "a"},
+		{"default", "a"},
+		{"outOfOffice", "a (out of office)"},
+		{"focusTime", "a (focus time)"},
+	}
+	for _, test := range tests {
+		t.Run(test.eventType, func(t *testing.T) {
+			event := testEvent("a", "2017-01-10T09:00:00Z", "2017-01-10T10:00:00Z")
+			event.EventType = test.eventType
+			if got := New().reminderAttachment(event, mustParseTime(t, "2017-01-10T09:00:00Z")).Title; got != test.expect {
+				t.Errorf("expected title %q, got %q", test.expect, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package calendarbot
+
+import (
+	"time"
+
+	"github.com/lestrrat/slack"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"google.golang.org/api/calendar/v3"
+)
+
+// rescheduleGrace is how long an event is remembered after it ends
+const rescheduleGrace = 24 * time.Hour
+
+// NotifyRescheduledEvents posts a message for each event between t and
+// t+delta whose start time changed since a previous call. Events are
+// remembered in the cache until a day after they end; the first call
+// only records their start times.
+//
+// With ChangeNotifyInterval, further changes to the same event are
+// held back until the interval is over, and only its start time at
+// that point is reported.
+//
+// The last start time seen is kept under "start:<id>", so an event
+// moved back to a start time it had earlier is reported like any
+// other change.
+func (b *Bot) NotifyRescheduledEvents(ctx context.Context, t time.Time, delta time.Duration) error {
+	if b.Paused() {
+		return errPaused
+	}
+
+	s, err := b.CalendarService(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to create calendar service")
+	}
+	delta = b.lookahead(ctx, delta)
+
+	events, err := b.listEvents(ctx, b.eventsList(s, calendarID(b.CalendarName)).
+		TimeMin(t.Format(time.RFC3339)).
+		TimeMax(t.Add(delta).Format(time.RFC3339)).
+		SingleEvents(true).
+		OrderBy("startTime"))
+	if err != nil {
+		return errors.Wrap(err, "failed to list events")
+	}
+	events = b.fetchOmittedAttendees(ctx, s, calendarID(b.CalendarName), events)
+
+	now := time.Now()
+	for _, event := range b.filterEvents(events) {
+		start, end, allDay, err := eventTimes(event)
+		if err != nil {
+			return err
+		}
+
+		last, known, err := b.lastStart(ctx, event)
+		if err != nil {
+			return err
+		}
+		if known && last.Equal(start) {
+			continue
+		}
+		if known && b.ChangeNotifyInterval > 0 {
+			throttled, err := b.seen(ctx, "changed:"+event.Id)
+			if err != nil {
+				return err
+			}
+			// The change is picked up again once the interval is over,
+			// unless the event moved back in the meantime
+			if throttled {
+				b.debugEvent(ctx, event, "event changed again too soon, not notifying yet")
+				continue
+			}
+		}
+
+		if known {
+			params := b.slackParams(calendarID(b.CalendarName))
+			params.Attachments = []slack.Attachment{b.rescheduleAttachment(event, start, allDay)}
+			if err := b.postSlack(ctx, "Event rescheduled", &params); err != nil {
+				return errors.Wrap(err, "failed to post message to slack")
+			}
+			if b.ChangeNotifyInterval > 0 {
+				b.Cache.Add(ctx, "changed:"+event.Id, []byte{0x1}, b.ChangeNotifyInterval)
+			}
+			// The reminder was for the old start time, so remind again
+			if err := b.Cache.Remove(ctx, event.Id); err != nil {
+				b.Logger.Warningf(ctx, "failed to forget reminder for %s: %s", event.Id, err)
+			}
+		} else {
+			b.debugEvent(ctx, event, "first time seeing event, remembering its start time")
+		}
+
+		b.rememberStart(ctx, event, start, end.Sub(now)+rescheduleGrace)
+	}
+	return nil
+}
+
+// startKey is the cache key of the last start time seen for event
+func startKey(event *calendar.Event) string {
+	return "start:" + event.Id
+}
+
+// lastStart returns the start time of event remembered by
+// NotifyRescheduledEvents, and whether there is one
+func (b *Bot) lastStart(ctx context.Context, event *calendar.Event) (time.Time, bool, error) {
+	v, err := b.Cache.Get(ctx, startKey(event))
+	if err != nil {
+		if IsCacheMiss(err) {
+			return time.Time{}, false, nil
+		}
+		return time.Time{}, false, errors.Wrap(err, "failed to get start time from cache")
+	}
+	buf, ok := cacheBytes(v)
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	start, err := time.Parse(time.RFC3339, string(buf))
+	if err != nil {
+		// Treat it like a new event rather than failing every run
+		b.Logger.Warningf(ctx, "failed to parse remembered start time of %s: %s", event.Id, err)
+		return time.Time{}, false, nil
+	}
+	return start, true, nil
+}
+
+// rememberStart replaces the start time remembered for event, which
+// also extends how long it is kept when the event moved later
+func (b *Bot) rememberStart(ctx context.Context, event *calendar.Event, start time.Time, ttl time.Duration) {
+	key := startKey(event)
+	b.Cache.Remove(ctx, key)
+	if err := b.Cache.Add(ctx, key, []byte(start.UTC().Format(time.RFC3339)), ttl); err != nil {
+		b.Logger.Warningf(ctx, "failed to remember start time of %s: %s", event.Id, err)
+	}
+}
+
+func (b *Bot) rescheduleAttachment(event *calendar.Event, start time.Time, allDay bool) slack.Attachment {
+	when := start.Format("Mon Jan 2") + ", all day"
+	if !allDay {
+		when = b.displayTime(event, start).Format("Mon Jan 2 15:04")
+	}
+	return slack.Attachment{
+		Fallback:  event.Summary + " was rescheduled to " + when,
+		ThumbURL:  b.SlackThumbURL,
+		Title:     event.Summary,
+		TitleLink: b.eventLink(event),
+		Text:      "Rescheduled to " + when,
+	}
+}
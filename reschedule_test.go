@@ -0,0 +1,123 @@
+package calendarbot
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/lestrrat/slack"
+	"google.golang.org/api/calendar/v3"
+)
+
+func TestNotifyRescheduledEvents(t *testing.T) {
+	b := newTestBot()
+	slackAPI := &fakeSlack{}
+	b.SlackTransport = slackAPI.transport
+	cache := b.Cache.(*mapCache)
+
+	start := time.Now().Add(2 * time.Hour).UTC().Truncate(time.Minute)
+	event := testEvent("a", start.Format(time.RFC3339), start.Add(time.Hour).Format(time.RFC3339))
+	other := testEvent("b", start.Format(time.RFC3339), start.Add(30*time.Minute).Format(time.RFC3339))
+	cal := &fakeCalendar{events: []*calendar.Event{event, other}}
+
+	run := func(name string, expect int) []fakeSlackCall {
+		before := len(slackAPI.posts())
+		if err := b.NotifyRescheduledEvents(cal.context(), time.Now(), 24*time.Hour); err != nil {
+			t.Fatalf("%s: NotifyRescheduledEvents failed: %s", name, err)
+		}
+		posts := slackAPI.posts()[before:]
+		if len(posts) != expect {
+			t.Fatalf("%s: expected %d posts, got %d", name, expect, len(posts))
+		}
+		return posts
+	}
+
+	run("first run", 0)
+	run("unchanged", 0)
+
+	// Both were reminded about at their old start time
+	cache.Add(cal.context(), "a", []byte{0x1}, time.Minute)
+	cache.Add(cal.context(), "b", []byte{0x1}, time.Minute)
+
+	moved := start.Add(90 * time.Minute)
+	event.Start.DateTime = moved.Format(time.RFC3339)
+	event.End.DateTime = moved.Add(time.Hour).Format(time.RFC3339)
+	posts := run("moved", 1)
+
+	if _, ok := cache.data["a"]; ok {
+		t.Errorf("expected the reminder for a to be forgotten")
+	}
+	if _, ok := cache.data["b"]; !ok {
+		t.Errorf("expected the reminder for b to be kept")
+	}
+
+	var attachments []slack.Attachment
+	if err := json.Unmarshal([]byte(posts[0].Form.Get("attachments")), &attachments); err != nil {
+		t.Fatalf("failed to decode attachments: %s", err)
+	}
+	expect := "Rescheduled to " + moved.Format("Mon Jan 2 15:04")
+	if len(attachments) != 1 || attachments[0].Title != "a" || attachments[0].Text != expect {
+		t.Errorf("expected %q for a, got %#v", expect, attachments)
+	}
+
+	run("unchanged after moving", 0)
+
+	// Moving it back is a change like any other
+	event.Start.DateTime = start.Format(time.RFC3339)
+	event.End.DateTime = start.Add(time.Hour).Format(time.RFC3339)
+	run("moved back", 1)
+	run("unchanged after moving back", 0)
+	if got := string(cache.data["start:a"]); got != start.Format(time.RFC3339) {
+		t.Errorf("expected the start time %s to be remembered, got %q", start.Format(time.RFC3339), got)
+	}
+
+	// Entries outlive the event by a day
+	ttl := cache.ttls["start:a"]
+	if ttl < 24*time.Hour || ttl > 27*time.Hour {
+		t.Errorf("unexpected TTL %s", ttl)
+	}
+}
+
+func TestChangeNotifyInterval(t *testing.T) {
+	b := newTestBot()
+	b.ChangeNotifyInterval = time.Hour
+	slackAPI := &fakeSlack{}
+	b.SlackTransport = slackAPI.transport
+	cache := b.Cache.(*mapCache)
+
+	start := time.Now().Add(2 * time.Hour).UTC().Truncate(time.Minute)
+	event := testEvent("a", start.Format(time.RFC3339), start.Add(time.Hour).Format(time.RFC3339))
+	cal := &fakeCalendar{events: []*calendar.Event{event}}
+
+	move := func(d time.Duration) {
+		moved := start.Add(d)
+		event.Start.DateTime = moved.Format(time.RFC3339)
+		event.End.DateTime = moved.Add(time.Hour).Format(time.RFC3339)
+	}
+	run := func(name string, expect int) {
+		before := len(slackAPI.posts())
+		if err := b.NotifyRescheduledEvents(cal.context(), time.Now(), 24*time.Hour); err != nil {
+			t.Fatalf("%s: NotifyRescheduledEvents failed: %s", name, err)
+		}
+		if got := len(slackAPI.posts()) - before; got != expect {
+			t.Fatalf("%s: expected %d posts, got %d", name, expect, got)
+		}
+	}
+
+	run("first run", 0)
+	move(10 * time.Minute)
+	run("first change", 1)
+	if ttl := cache.ttls["changed:a"]; ttl != time.Hour {
+		t.Errorf("expected the throttle to last an hour, got %s", ttl)
+	}
+
+	move(20 * time.Minute)
+	run("rapid change", 0)
+	move(30 * time.Minute)
+	run("another rapid change", 0)
+
+	// Once the interval is over, the latest start time is announced once
+	delete(cache.data, "changed:a")
+	run("after interval", 1)
+	run("no further change", 0)
+}
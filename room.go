@@ -0,0 +1,167 @@
+package calendarbot
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/lestrrat/slack"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// roomConflictDedupTTL is how long an announced room conflict is
+// remembered
+const roomConflictDedupTTL = 24 * time.Hour
+
+// roomConflict is a pair of overlapping events booking the same room
+type roomConflict struct {
+	Room   *calendar.EventAttendee
+	First  roomBooking
+	Second roomBooking
+}
+
+// NotifyRoomConflicts warns about meeting rooms and other resources
+// that are booked by more than one event at the same time, between t
+// and t+delta. Each conflict is only announced once.
+func (b *Bot) NotifyRoomConflicts(ctx context.Context, t time.Time, delta time.Duration) error {
+	if b.Paused() {
+		return errPaused
+	}
+
+	s, err := b.CalendarService(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to create calendar service")
+	}
+	delta = b.lookahead(ctx, delta)
+
+	// MaxAttendees is not applied, as it could leave out the rooms
+	call := s.Events.List(calendarID(b.CalendarName))
+	if b.EventFields != "" {
+		call = call.Fields(googleapi.Field(b.EventFields))
+	}
+	events, err := b.listEvents(ctx, call.
+		TimeMin(t.Format(time.RFC3339)).
+		TimeMax(t.Add(delta).Format(time.RFC3339)).
+		SingleEvents(true).
+		OrderBy("startTime"))
+	if err != nil {
+		return errors.Wrap(err, "failed to list events")
+	}
+
+	conflicts, err := roomConflicts(events)
+	if err != nil {
+		return errors.Wrap(err, "failed to find room conflicts")
+	}
+
+	for _, c := range conflicts {
+		key := "room-conflict:" + c.Room.Email + ":" + c.First.event.Id + ":" + c.Second.event.Id
+		announced, err := b.seen(ctx, key)
+		if err != nil {
+			return err
+		}
+		if announced {
+			continue
+		}
+
+		params := b.slackParams(calendarID(b.CalendarName))
+		params.Attachments = []slack.Attachment{b.roomConflictAttachment(c)}
+		if err := b.postSlack(ctx, "Room double-booked", &params); err != nil {
+			return errors.Wrap(err, "failed to post message to slack")
+		}
+
+		b.Cache.Add(ctx, key, []byte{0x1}, roomConflictDedupTTL)
+	}
+	return nil
+}
+
+func (b *Bot) roomConflictAttachment(c roomConflict) slack.Attachment {
+	room := c.Room.DisplayName
+	if room == "" {
+		room = c.Room.Email
+	}
+
+	fields := make([]slack.AttachmentField, 0, 2)
+	for _, booking := range []roomBooking{c.First, c.Second} {
+		fields = append(fields, slack.AttachmentField{
+			Value: fmt.Sprintf("%s: <%s|%s>", b.timeRange(booking.event, booking.start, booking.end), b.eventLink(booking.event), booking.event.Summary),
+		})
+	}
+	return slack.Attachment{
+		Color:    "warning",
+		Fallback: room + " is double-booked",
+		ThumbURL: b.SlackThumbURL,
+		Title:    room + " is double-booked",
+		Fields:   fields,
+	}
+}
+
+type roomBooking struct {
+	event      *calendar.Event
+	room       *calendar.EventAttendee
+	start, end time.Time
+}
+
+// roomConflicts returns every pair of overlapping events in `events`
+// that have the same resource attendee, ordered by room and start time.
+// Resources that declined an event are not considered booked by it,
+// and all-day events are ignored
+func roomConflicts(events []*calendar.Event) ([]roomConflict, error) {
+	bookings := make(map[string][]roomBooking)
+	var rooms []string
+	for _, event := range events {
+		var booked []*calendar.EventAttendee
+		for _, a := range event.Attendees {
+			if a.Resource && a.ResponseStatus != "declined" {
+				booked = append(booked, a)
+			}
+		}
+		if len(booked) == 0 {
+			continue
+		}
+
+		start, end, allDay, err := eventTimes(event)
+		if err != nil {
+			return nil, err
+		}
+		if allDay {
+			continue
+		}
+
+		for _, a := range booked {
+			if _, ok := bookings[a.Email]; !ok {
+				rooms = append(rooms, a.Email)
+			}
+			bookings[a.Email] = append(bookings[a.Email], roomBooking{
+				event: event,
+				room:  a,
+				start: start,
+				end:   end,
+			})
+		}
+	}
+	sort.Strings(rooms)
+
+	var conflicts []roomConflict
+	for _, room := range rooms {
+		list := bookings[room]
+		sort.SliceStable(list, func(i, j int) bool {
+			return list[i].start.Before(list[j].start)
+		})
+		for i, first := range list {
+			for _, second := range list[i+1:] {
+				if !second.start.Before(first.end) {
+					break
+				}
+				conflicts = append(conflicts, roomConflict{
+					Room:   first.room,
+					First:  first,
+					Second: second,
+				})
+			}
+		}
+	}
+	return conflicts, nil
+}
@@ -0,0 +1,142 @@
+package calendarbot
+
+import (
+	"reflect"
+	"testing"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+func TestRoomConflicts(t *testing.T) {
+	room := func(email, status string) *calendar.EventAttendee {
+		return &calendar.EventAttendee{Email: email, Resource: true, ResponseStatus: status}
+	}
+	booking := func(summary, start, end string, attendees ...*calendar.EventAttendee) *calendar.Event {
+		event := testEvent(summary, start, end)
+		event.Attendees = append([]*calendar.EventAttendee{{Email: "me@example.com", Self: true}}, attendees...)
+		return event
+	}
+	type pair struct{ Room, First, Second string }
+
+	tests := []struct {
+		name   string
+		events []*calendar.Event
+		expect []pair
+	}{
+		{
+			name: "overlapping",
+			events: []*calendar.Event{
+				booking("a", "2017-01-10T09:00:00Z", "2017-01-10T10:00:00Z", room("blue@resource", "accepted")),
+				booking("b", "2017-01-10T09:30:00Z", "2017-01-10T10:30:00Z", room("blue@resource", "accepted")),
+			},
+			expect: []pair{{"blue@resource", "a", "b"}},
+		},
+		{
+			name: "back to back",
+			events: []*calendar.Event{
+				booking("a", "2017-01-10T09:00:00Z", "2017-01-10T10:00:00Z", room("blue@resource", "accepted")),
+				booking("b", "2017-01-10T10:00:00Z", "2017-01-10T11:00:00Z", room("blue@resource", "accepted")),
+			},
+		},
+		{
+			name: "different rooms",
+			events: []*calendar.Event{
+				booking("a", "2017-01-10T09:00:00Z", "2017-01-10T10:00:00Z", room("blue@resource", "accepted")),
+				booking("b", "2017-01-10T09:30:00Z", "2017-01-10T10:30:00Z", room("red@resource", "accepted")),
+			},
+		},
+		{
+			name: "declined by the room",
+			events: []*calendar.Event{
+				booking("a", "2017-01-10T09:00:00Z", "2017-01-10T10:00:00Z", room("blue@resource", "accepted")),
+				booking("b", "2017-01-10T09:30:00Z", "2017-01-10T10:30:00Z", room("blue@resource", "declined")),
+			},
+		},
+		{
+			name: "people are not rooms",
+			events: []*calendar.Event{
+				booking("a", "2017-01-10T09:00:00Z", "2017-01-10T10:00:00Z"),
+				booking("b", "2017-01-10T09:30:00Z", "2017-01-10T10:30:00Z"),
+			},
+		},
+		{
+			name: "all-day events",
+			events: func() []*calendar.Event {
+				a := testAllDayEvent("a", "2017-01-10", "2017-01-11")
+				a.Attendees = []*calendar.EventAttendee{room("blue@resource", "accepted")}
+				return []*calendar.Event{
+					a,
+					booking("b", "2017-01-10T09:30:00Z", "2017-01-10T10:30:00Z", room("blue@resource", "accepted")),
+				}
+			}(),
+		},
+		{
+			name: "several rooms and conflicts",
+			events: []*calendar.Event{
+				booking("a", "2017-01-10T09:00:00Z", "2017-01-10T12:00:00Z", room("red@resource", "accepted"), room("blue@resource", "accepted")),
+				booking("b", "2017-01-10T10:00:00Z", "2017-01-10T11:00:00Z", room("red@resource", "accepted")),
+				booking("c", "2017-01-10T10:30:00Z", "2017-01-10T13:00:00Z", room("red@resource", "needsAction"), room("blue@resource", "accepted")),
+			},
+			expect: []pair{
+				{"blue@resource", "a", "c"},
+				{"red@resource", "a", "b"},
+				{"red@resource", "a", "c"},
+				{"red@resource", "b", "c"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conflicts, err := roomConflicts(test.events)
+			if err != nil {
+				t.Fatalf("roomConflicts failed: %s", err)
+			}
+			var got []pair
+			for _, c := range conflicts {
+				got = append(got, pair{c.Room.Email, c.First.event.Id, c.Second.event.Id})
+			}
+			if !reflect.DeepEqual(got, test.expect) {
+				t.Errorf("expected %v, got %v", test.expect, got)
+			}
+		})
+	}
+}
+
+func TestRoomConflictsMissingEnd(t *testing.T) {
+	event := testEvent("a", "2017-01-10T09:00:00Z", "2017-01-10T10:00:00Z")
+	event.Attendees = []*calendar.EventAttendee{{Email: "blue@resource", Resource: true}}
+	event.End = nil
+	if _, err := roomConflicts([]*calendar.Event{event}); err == nil {
+		t.Errorf("expected an error for an event without an end")
+	}
+}
+
+func TestRoomConflictAttachment(t *testing.T) {
+	b := New()
+	blue := &calendar.EventAttendee{Email: "blue@resource", DisplayName: "Blue Room", Resource: true}
+	first := testEvent("a", "2017-01-10T09:00:00Z", "2017-01-10T10:00:00Z")
+	first.Attendees = []*calendar.EventAttendee{blue}
+	second := testEvent("b", "2017-01-10T09:30:00Z", "2017-01-10T10:30:00Z")
+	second.Attendees = []*calendar.EventAttendee{blue}
+
+	conflicts, err := roomConflicts([]*calendar.Event{first, second})
+	if err != nil || len(conflicts) != 1 {
+		t.Fatalf("expected 1 conflict, got %d (%v)", len(conflicts), err)
+	}
+	a := b.roomConflictAttachment(conflicts[0])
+	if a.Title != "Blue Room is double-booked" {
+		t.Errorf("unexpected title %q", a.Title)
+	}
+	var values []string
+	for _, f := range a.Fields {
+		values = append(values, f.Value)
+	}
+	expect := []string{
+		"09:00-10:00: <https://calendar.google.com/event?eid=a|a>",
+		"09:30-10:30: <https://calendar.google.com/event?eid=b|b>",
+	}
+	if !reflect.DeepEqual(values, expect) {
+		t.Errorf("expected %q, got %q", expect, values)
+	}
+}
@@ -0,0 +1,106 @@
+package calendarbot
+
+import (
+	"strings"
+
+	"github.com/lestrrat/slack"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"google.golang.org/api/calendar/v3"
+)
+
+var errUserNotFound = errors.New("failed to find matching user")
+
+type imOpener interface {
+	GetUsers() ([]slack.User, error)
+	OpenIMChannel(string) (bool, bool, string, error)
+}
+
+// userIM opens a direct message channel with the Slack user whose
+// profile email matches `email`
+func userIM(slackcl imOpener, email string) (string, error) {
+	users, err := slackcl.GetUsers()
+	if err != nil {
+		return "", errors.Wrap(missingScope(err, "users.list"), "failed to list users")
+	}
+
+	for _, user := range users {
+		if user.Deleted || user.IsBot || !strings.EqualFold(user.Profile.Email, email) {
+			continue
+		}
+
+		_, _, chID, err := slackcl.OpenIMChannel(user.ID)
+		if err != nil {
+			return "", errors.Wrapf(missingScope(err, "im.open"), "failed to open direct message with %s", user.ID)
+		}
+		return chID, nil
+	}
+	return "", errUserNotFound
+}
+
+// eventChannel returns the channel to post the reminder for event to.
+// With RouteToOrganizer, this is a direct message with the organizer,
+// falling back to the usual channel if they can't be found on Slack
+func (b *Bot) eventChannel(ctx context.Context, slackcl SlackPoster, event *calendar.Event) (string, error) {
+	if b.RouteToOrganizer && event.Organizer != nil && event.Organizer.Email != "" {
+		if opener, ok := slackcl.(imOpener); ok {
+			chID, err := userIM(opener, event.Organizer.Email)
+			if err == nil {
+				return chID, nil
+			}
+			b.Logger.Warningf(ctx, "failed to reach organizer of %s, using channel %s: %s", event.Id, b.SlackChannel, err)
+		} else {
+			b.Logger.Warningf(ctx, "slack client can't open direct messages, using channel %s", b.SlackChannel)
+		}
+	}
+	return b.resolveChannel(ctx, slackcl)
+}
+
+// postReminder posts the reminder for event, see eventChannel
+func (b *Bot) postReminder(ctx context.Context, event *calendar.Event, txt string, params *slack.PostMessageParameters, meta *slackMetadata) error {
+	slackcl, err := b.slackClient(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to create and authenticate slack client")
+	}
+
+	chID, err := b.eventChannel(ctx, slackcl, event)
+	if err != nil {
+		return errors.Wrap(err, "failed to find channel ID")
+	}
+	if !b.ThreadReminders {
+		_, err = b.postMessage(slackcl, chID, txt, params, meta)
+		if b.archivedFallback(err) {
+			_, _, err = b.postFallback(ctx, slackcl, txt, params)
+		}
+		return err
+	}
+
+	// The first reminder starts the thread
+	parent := b.reminderThread(ctx, event, chID)
+	if parent == "" {
+		ts, err := b.postMessage(slackcl, chID, txt, params, meta)
+		if b.archivedFallback(err) {
+			chID, ts, err = b.postFallback(ctx, slackcl, txt, params)
+		}
+		if err != nil {
+			return err
+		}
+		b.rememberThread(ctx, event, chID, ts)
+		return nil
+	}
+
+	// If it has been deleted, the thread continues under a new header
+	newParent := func() (string, error) {
+		header := slack.NewPostMessageParameters()
+		header.Username, header.IconEmoji = params.Username, params.IconEmoji
+		return b.postMessage(slackcl, chID, "Reminders for "+event.Summary, &header, nil)
+	}
+	ts, err := b.postReply(slackcl, chID, parent, txt, params, meta, newParent)
+	if err != nil {
+		return err
+	}
+	if ts != parent {
+		b.rememberThread(ctx, event, chID, ts)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package calendarbot
+
+import (
+	"time"
+
+	"github.com/lestrrat/slack"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// NotifyPendingResponses sends a nudge to slack for each event starting
+// between t and t+delta that you haven't responded to yet. Each event
+// is only nudged about once.
+func (b *Bot) NotifyPendingResponses(ctx context.Context, t time.Time, delta time.Duration) error {
+	if b.Paused() {
+		return errPaused
+	}
+
+	s, err := b.CalendarService(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to create calendar service")
+	}
+	delta = b.lookahead(ctx, delta)
+
+	events, err := b.listEvents(ctx, b.eventsList(s, calendarID(b.CalendarName)).
+		TimeMin(t.Format(time.RFC3339)).
+		TimeMax(t.Add(delta).Format(time.RFC3339)).
+		SingleEvents(true))
+	if err != nil {
+		return errors.Wrap(err, "failed to list events")
+	}
+	events = b.fetchOmittedAttendees(ctx, s, calendarID(b.CalendarName), events)
+
+	now := time.Now()
+	for _, event := range b.filterEvents(events) {
+		if !pendingResponse(event) {
+			continue
+		}
+
+		key := "rsvp:" + event.Id
+		nudged, err := b.seen(ctx, key)
+		if err != nil {
+			return err
+		}
+		if nudged {
+			b.debugEvent(ctx, event, "already nudged about a response, skipping")
+			continue
+		}
+
+		start, end, allDay, err := eventTimes(event)
+		if err != nil {
+			return err
+		}
+		when := start.Format("Mon Jan 2")
+		if !allDay {
+			when = b.displayTime(event, start).Format("Mon Jan 2 15:04")
+		}
+
+		params := b.slackParams(calendarID(b.CalendarName))
+		params.Attachments = []slack.Attachment{
+			slack.Attachment{
+				Fallback: event.Summary,
+				Fields: []slack.AttachmentField{
+					slack.AttachmentField{
+						Title: "Start Time",
+						Value: when,
+					},
+				},
+				ThumbURL:  b.SlackThumbURL,
+				Title:     event.Summary,
+				TitleLink: b.eventLink(event),
+			},
+		}
+		if err := b.postSlack(ctx, "You haven't responded to this event", &params); err != nil {
+			return errors.Wrap(err, "failed to post message to slack")
+		}
+
+		// There is nothing left to respond to once the event is over
+		ttl := end.Sub(now)
+		if ttl < rsvpMinTTL {
+			ttl = rsvpMinTTL
+		}
+		b.Cache.Add(ctx, key, []byte{0x1}, ttl)
+	}
+	return nil
+}
+
+// rsvpMinTTL is the shortest time a nudge is remembered, for events that
+// end right away
+const rsvpMinTTL = 15 * time.Minute
@@ -0,0 +1,63 @@
+package calendarbot
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/lestrrat/slack"
+	"google.golang.org/api/calendar/v3"
+)
+
+func TestNotifyPendingResponses(t *testing.T) {
+	start := time.Now().Add(2 * time.Hour).UTC().Truncate(time.Minute)
+	event := func(id, status string) *calendar.Event {
+		e := testEvent(id, start.Format(time.RFC3339), start.Add(time.Hour).Format(time.RFC3339))
+		e.Attendees = []*calendar.EventAttendee{
+			{Email: "bob@example.com", ResponseStatus: "needsAction"},
+			{Email: "me@example.com", Self: true, ResponseStatus: status},
+		}
+		return e
+	}
+	cal := &fakeCalendar{events: []*calendar.Event{
+		event("pending", "needsAction"),
+		event("accepted", "accepted"),
+		event("declined", "declined"),
+		event("tentative", "tentative"),
+		testEvent("mine", start.Format(time.RFC3339), start.Add(time.Hour).Format(time.RFC3339)),
+	}}
+	slackAPI := &fakeSlack{}
+	b := newTestBot()
+	b.SlackTransport = slackAPI.transport
+	cache := b.Cache.(*mapCache)
+
+	for i := 0; i < 2; i++ {
+		if err := b.NotifyPendingResponses(cal.context(), time.Now(), 24*time.Hour); err != nil {
+			t.Fatalf("NotifyPendingResponses failed: %s", err)
+		}
+	}
+
+	// Only the event waiting for a response is nudged about, and only once
+	var titles []string
+	for _, post := range slackAPI.posts() {
+		if text := post.Form.Get("text"); text != "You haven't responded to this event" {
+			t.Errorf("unexpected text %q", text)
+		}
+		var attachments []slack.Attachment
+		if err := json.Unmarshal([]byte(post.Form.Get("attachments")), &attachments); err != nil {
+			t.Fatalf("failed to decode attachments: %s", err)
+		}
+		for _, a := range attachments {
+			titles = append(titles, a.Title)
+		}
+	}
+	if expect := []string{"pending"}; !reflect.DeepEqual(titles, expect) {
+		t.Errorf("expected nudges for %q, got %q", expect, titles)
+	}
+
+	// The nudge is remembered until the event is over
+	if ttl := cache.ttls["rsvp:pending"]; ttl < 2*time.Hour || ttl > 3*time.Hour {
+		t.Errorf("unexpected TTL %s", ttl)
+	}
+}
@@ -0,0 +1,77 @@
+package calendarbot
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// MissingScopeError is returned (possibly wrapped, see errors.Cause) when
+// the Slack token is not allowed to call Method. Scope is the OAuth scope
+// the token needs to be granted
+type MissingScopeError struct {
+	Method string
+	Scope  string
+}
+
+func (e *MissingScopeError) Error() string {
+	return fmt.Sprintf("slack token is missing scope %s required by %s", e.Scope, e.Method)
+}
+
+// ArchivedChannelError is returned (possibly wrapped, see errors.Cause)
+// when posting to a channel that has been archived. Channel is its ID
+type ArchivedChannelError struct {
+	Channel string
+}
+
+func (e *ArchivedChannelError) Error() string {
+	return fmt.Sprintf("slack channel %s is archived, unarchive it or post to another channel", e.Channel)
+}
+
+// archivedChannel turns the "is_archived" error Slack returns when
+// posting to channel chID into an ArchivedChannelError, returning other
+// errors unchanged
+func archivedChannel(err error, chID string) error {
+	if err == nil || errors.Cause(err).Error() != "is_archived" {
+		return err
+	}
+	return &ArchivedChannelError{Channel: chID}
+}
+
+// requiredScopes maps the Slack methods the bot calls to the scope they
+// require
+var requiredScopes = map[string]string{
+	"channels.list":     "channels:read",
+	"chat.postMessage":  "chat:write",
+	"groups.list":       "groups:read",
+	"im.open":           "im:write",
+	"reactions.add":     "reactions:write",
+	"users.getPresence": "users:read",
+	"users.list":        "users:read",
+}
+
+// missingScope turns the "missing_scope" error Slack returns from method
+// into a MissingScopeError, returning other errors unchanged
+func missingScope(err error, method string) error {
+	if err == nil || errors.Cause(err).Error() != "missing_scope" {
+		return err
+	}
+	return &MissingScopeError{Method: method, Scope: requiredScopes[method]}
+}
+
+// SelfTest checks that the bot can reach Slack with SlackToken and find
+// SlackChannel, without posting anything. If the token lacks a scope,
+// the cause of the error is a *MissingScopeError. Use TestPost to also
+// check that the bot can post
+func (b *Bot) SelfTest(ctx context.Context) error {
+	slackcl, err := b.slackClient(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to create and authenticate slack client")
+	}
+
+	if _, err := b.resolveChannel(ctx, slackcl); err != nil {
+		return errors.Wrap(err, "failed to find channel ID")
+	}
+	return nil
+}
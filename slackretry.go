@@ -0,0 +1,77 @@
+package calendarbot
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// retryTransport tries Slack API calls again when Slack answers with
+// 429 Too Many Requests or a 5xx error, see Bot.SlackRetries
+type retryTransport struct {
+	base    http.RoundTripper
+	ctx     context.Context // Cuts waiting for the next attempt short
+	logger  Logger
+	retries int
+	backoff time.Duration // Wait before the first retry, doubling with each further one
+}
+
+func (t *retryTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	// The body is read once, so that each attempt can send it again
+	var body []byte
+	if r.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read request")
+		}
+	}
+
+	backoff := t.backoff
+	for attempt := 0; ; attempt++ {
+		// RoundTrippers must not modify the request
+		r2 := new(http.Request)
+		*r2 = *r
+		if body != nil {
+			r2.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+		res, err := t.base.RoundTrip(r2)
+		if err != nil || !retryableStatus(res.StatusCode) || attempt == t.retries {
+			return res, err
+		}
+
+		wait := retryAfter(res.Header.Get("Retry-After"), backoff)
+		io.Copy(ioutil.Discard, res.Body)
+		res.Body.Close()
+		t.logger.Debugf(t.ctx, "slack %s failed with %s, retrying in %s", path.Base(r.URL.Path), res.Status, wait)
+		select {
+		case <-t.ctx.Done():
+			return nil, errors.Wrap(t.ctx.Err(), "failed to wait for slack")
+		case <-time.After(wait):
+		}
+		backoff *= 2
+	}
+}
+
+// retryableStatus reports whether a response with status code may
+// succeed if the request is sent again
+func retryableStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code >= http.StatusInternalServerError
+}
+
+// retryAfter returns the wait asked for by the Retry-After header
+// `header`, or `backoff` if there is none. Slack only sends seconds
+func retryAfter(header string, backoff time.Duration) time.Duration {
+	if secs, err := strconv.Atoi(header); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second
+	}
+	return backoff
+}
@@ -0,0 +1,135 @@
+package calendarbot
+
+import (
+	"net/http"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// flakySlack fails the first calls to each method with status, then
+// lets them through to Slack
+type flakySlack struct {
+	base       http.RoundTripper
+	failures   int // Failed calls per method
+	status     int
+	retryAfter string
+	calls      map[string]int
+}
+
+func (f *flakySlack) RoundTrip(r *http.Request) (*http.Response, error) {
+	method := path.Base(r.URL.Path)
+	f.calls[method]++
+	if f.calls[method] > f.failures {
+		return f.base.RoundTrip(r)
+	}
+	res := jsonResponse(f.status, `{"ok":false}`)
+	if f.retryAfter != "" {
+		res.Header.Set("Retry-After", f.retryAfter)
+	}
+	return res, nil
+}
+
+func TestSlackRetries(t *testing.T) {
+	tests := []struct {
+		name       string
+		retries    int
+		failures   int
+		status     int
+		retryAfter string
+		expectErr  bool
+	}{
+		{"no failures", 2, 0, http.StatusTooManyRequests, "", false},
+		{"rate limited", 2, 2, http.StatusTooManyRequests, "", false},
+		{"server error", 2, 2, http.StatusServiceUnavailable, "", false},
+		{"retry after", 2, 2, http.StatusTooManyRequests, "0", false},
+		{"too many failures", 2, 3, http.StatusTooManyRequests, "", true},
+		{"disabled", 0, 1, http.StatusTooManyRequests, "", true},
+		{"client error", 2, 1, http.StatusBadRequest, "", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			slackAPI := &fakeSlack{}
+			flaky := &flakySlack{
+				base:       slackAPI,
+				failures:   test.failures,
+				status:     test.status,
+				retryAfter: test.retryAfter,
+				calls:      make(map[string]int),
+			}
+			b := newTestBot()
+			b.SlackRetries = test.retries
+			b.SlackRetryBase = time.Millisecond
+			if test.retryAfter != "" {
+				// Only Retry-After keeps the test from waiting
+				b.SlackRetryBase = time.Hour
+			}
+			b.SlackTransport = func(http.RoundTripper) http.RoundTripper { return flaky }
+
+			err := b.TestPost(context.Background(), "hello")
+			if test.expectErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("TestPost failed: %s", err)
+			}
+
+			// Every method needed to post fails the same number of times
+			for _, method := range []string{"auth.test", "chat.postMessage"} {
+				if n := flaky.calls[method]; n != test.failures+1 {
+					t.Errorf("expected %d calls to %s, got %d", test.failures+1, method, n)
+				}
+			}
+			posts := slackAPI.posts()
+			if len(posts) != 1 || posts[0].Form.Get("text") != "hello" {
+				t.Errorf("expected the message to be posted once, got %v", posts)
+			}
+		})
+	}
+}
+
+func TestSlackRetriesCanceled(t *testing.T) {
+	flaky := &flakySlack{
+		base:     &fakeSlack{},
+		failures: 1,
+		status:   http.StatusTooManyRequests,
+		calls:    make(map[string]int),
+	}
+	b := newTestBot()
+	b.SlackRetries = 1
+	b.SlackRetryBase = time.Hour
+	b.SlackTransport = func(http.RoundTripper) http.RoundTripper { return flaky }
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := b.TestPost(ctx, "hello")
+	if err == nil || !strings.Contains(err.Error(), "failed to wait for slack") {
+		t.Errorf("expected waiting to be cut short, got %v", err)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		header string
+		expect time.Duration
+	}{
+		{"", time.Second},
+		{"0", 0},
+		{"30", 30 * time.Second},
+		{"-1", time.Second},
+		{"Wed, 21 Oct 2015 07:28:00 GMT", time.Second},
+	}
+	for _, test := range tests {
+		t.Run(test.header, func(t *testing.T) {
+			if got := retryAfter(test.header, time.Second); got != test.expect {
+				t.Errorf("expected %s, got %s", test.expect, got)
+			}
+		})
+	}
+}
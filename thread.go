@@ -0,0 +1,99 @@
+package calendarbot
+
+import (
+	"strings"
+	"time"
+
+	"github.com/lestrrat/slack"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"google.golang.org/api/calendar/v3"
+)
+
+// isThreadNotFound reports whether err is Slack refusing a reply
+// because the message it belongs to no longer exists
+func isThreadNotFound(err error) bool {
+	return err != nil && errors.Cause(err).Error() == "thread_not_found"
+}
+
+// postReply posts txt in the thread of the message with timestamp
+// `parent`. If that message has been deleted, newParent is called to
+// post a replacement and the reply is posted in its thread instead.
+// It returns the timestamp of the parent the reply ended up under, so
+// that the caller can remember it for further replies
+func (b *Bot) postReply(slackcl SlackPoster, chID, parent, txt string, params *slack.PostMessageParameters, meta *slackMetadata, newParent func() (string, error)) (string, error) {
+	reply := *params
+	reply.ThreadTimestamp = parent
+	_, err := b.postMessage(slackcl, chID, txt, &reply, meta)
+	if err == nil {
+		return parent, nil
+	}
+	if !isThreadNotFound(err) {
+		return "", err
+	}
+
+	parent, err = newParent()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to recreate thread parent")
+	}
+	reply = *params
+	reply.ThreadTimestamp = parent
+	if _, err := b.postMessage(slackcl, chID, txt, &reply, meta); err != nil {
+		return "", err
+	}
+	return parent, nil
+}
+
+// threadKey is the cache key of the thread of reminders for event
+func threadKey(event *calendar.Event) string {
+	return "thread:" + event.Id
+}
+
+// reminderThread returns the timestamp of the first reminder posted for
+// event to channel chID, or an empty string if there is none
+func (b *Bot) reminderThread(ctx context.Context, event *calendar.Event, chID string) string {
+	v, err := b.Cache.Get(ctx, threadKey(event))
+	if err != nil {
+		if !IsCacheMiss(err) {
+			b.Logger.Warningf(ctx, "failed to get reminder thread of %s, posting a new one: %s", event.Id, err)
+		}
+		return ""
+	}
+	buf, ok := cacheBytes(v)
+	if !ok {
+		return ""
+	}
+	// Routing may have sent the first reminder elsewhere
+	parts := strings.SplitN(string(buf), " ", 2)
+	if len(parts) != 2 || parts[0] != chID {
+		return ""
+	}
+	return parts[1]
+}
+
+// rememberThread records ts in chID as the parent of further reminders
+// for event, until DedupWindow after the event ends
+func (b *Bot) rememberThread(ctx context.Context, event *calendar.Event, chID, ts string) {
+	ttl := b.DedupWindow
+	if _, end, _, err := eventTimes(event); err == nil && time.Until(end) > 0 {
+		ttl += time.Until(end)
+	}
+	key := threadKey(event)
+	b.Cache.Remove(ctx, key)
+	if err := b.Cache.Add(ctx, key, []byte(chID+" "+ts), ttl); err != nil {
+		b.Logger.Warningf(ctx, "failed to remember reminder thread of %s: %s", event.Id, err)
+	}
+}
+
+// cacheBytes returns the bytes stored under a key, given what Get
+// returned for it. The memory cache returns whole entries
+func cacheBytes(v interface{}) ([]byte, bool) {
+	switch v := v.(type) {
+	case []byte:
+		return v, true
+	case cacheEntry:
+		return v.Value, true
+	default:
+		return nil, false
+	}
+}
@@ -0,0 +1,152 @@
+package calendarbot
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/pkg/errors"
+	"google.golang.org/api/calendar/v3"
+)
+
+func TestPostReply(t *testing.T) {
+	tests := []struct {
+		name          string
+		deleted       bool
+		postError     string
+		parentErr     error
+		expectParent  string
+		expectThreads []string // thread_ts of each chat.postMessage
+		expectErr     bool
+	}{
+		{"parent exists", false, "", nil, "1484000000.000001", []string{"1484000000.000001"}, false},
+		{"parent deleted", true, "", nil, "1484000000.000003", []string{"1484000000.000001", "", "1484000000.000003"}, false},
+		{"recreating fails", true, "", errors.New("boom"), "", []string{"1484000000.000001"}, true},
+		{"other error", false, "channel_not_found", nil, "", []string{"1484000000.000001"}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			slackAPI := &fakeSlack{postError: test.postError}
+			if test.deleted {
+				slackAPI.deletedThreads = map[string]bool{"1484000000.000001": true}
+			}
+			b := newTestBot()
+			b.SlackTransport = slackAPI.transport
+			slackcl, err := b.slackClient(context.Background())
+			if err != nil {
+				t.Fatalf("slackClient failed: %s", err)
+			}
+
+			var recreated int
+			newParent := func() (string, error) {
+				recreated++
+				if test.parentErr != nil {
+					return "", test.parentErr
+				}
+				params := b.slackParams("")
+				return b.postMessage(slackcl, "C024BE91L", "Reminders for a", &params, nil)
+			}
+			params := b.slackParams("")
+			parent, err := b.postReply(slackcl, "C024BE91L", "1484000000.000001", "starting now", &params, nil, newParent)
+			if (err != nil) != test.expectErr {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if parent != test.expectParent {
+				t.Errorf("expected parent %q, got %q", test.expectParent, parent)
+			}
+			if expect := map[bool]int{false: 0, true: 1}[test.deleted]; recreated != expect {
+				t.Errorf("expected the parent to be recreated %d times, got %d", expect, recreated)
+			}
+
+			var threads []string
+			for _, post := range slackAPI.posts() {
+				threads = append(threads, post.Form.Get("thread_ts"))
+			}
+			if !reflect.DeepEqual(threads, test.expectThreads) {
+				t.Errorf("expected posts in threads %q, got %q", test.expectThreads, threads)
+			}
+			if params.ThreadTimestamp != "" {
+				t.Errorf("expected params to be left alone, got thread %q", params.ThreadTimestamp)
+			}
+		})
+	}
+}
+
+func TestThreadReminders(t *testing.T) {
+	tests := []struct {
+		name    string
+		enabled bool
+		memory  bool
+		deleted bool
+		expect  []string // thread_ts and text of each chat.postMessage
+	}{
+		{"disabled", false, false, false, []string{
+			" starts soon", " starts soon",
+		}},
+		{"enabled", true, false, false, []string{
+			" starts soon", "1484000000.000003 starts soon", "1484000000.000003 starts soon",
+		}},
+		{"memory cache", true, true, false, []string{
+			" starts soon", "1484000000.000003 starts soon", "1484000000.000003 starts soon",
+		}},
+		{"parent deleted", true, false, true, []string{
+			" starts soon", "1484000000.000003 starts soon", " Reminders for a", "1484000000.000006 starts soon", "1484000000.000006 starts soon",
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			slackAPI := &fakeSlack{}
+			if test.deleted {
+				slackAPI.deletedThreads = map[string]bool{"1484000000.000003": true}
+			}
+			b := newTestBot()
+			if test.memory {
+				b.Cache = NewMemoryCacheWithSweep(0, 0)
+			}
+			b.SlackTransport = slackAPI.transport
+			b.ThreadReminders = test.enabled
+
+			n := &Notification{
+				Kind:   ReminderNotification,
+				Text:   "starts soon",
+				Events: []*calendar.Event{testEvent("a", "2017-01-10T09:00:00Z", "2017-01-10T10:00:00Z")},
+			}
+			runs := 2
+			if test.enabled {
+				runs = 3
+			}
+			for i := 0; i < runs; i++ {
+				if err := b.SlackNotifier().Notify(context.Background(), n); err != nil {
+					t.Fatalf("Notify failed: %s", err)
+				}
+			}
+
+			var got []string
+			for _, post := range slackAPI.posts() {
+				got = append(got, post.Form.Get("thread_ts")+" "+post.Form.Get("text"))
+			}
+			if !reflect.DeepEqual(got, test.expect) {
+				t.Errorf("expected %q, got %q", test.expect, got)
+			}
+		})
+	}
+}
+
+func TestReminderThreadChannel(t *testing.T) {
+	event := testEvent("a", "2017-01-10T09:00:00Z", "2017-01-10T10:00:00Z")
+	b := newTestBot()
+	b.rememberThread(context.Background(), event, "C024BE91L", "1484000000.000001")
+
+	if ts := b.reminderThread(context.Background(), event, "C024BE91L"); ts != "1484000000.000001" {
+		t.Errorf("expected the thread to be found, got %q", ts)
+	}
+	if ts := b.reminderThread(context.Background(), event, "D0G9QF9C6"); ts != "" {
+		t.Errorf("expected no thread in another channel, got %q", ts)
+	}
+
+	// A new thread replaces the old one
+	b.rememberThread(context.Background(), event, "C024BE91L", "1484000000.000009")
+	if ts := b.reminderThread(context.Background(), event, "C024BE91L"); ts != "1484000000.000009" {
+		t.Errorf("expected the new thread, got %q", ts)
+	}
+}
@@ -0,0 +1,136 @@
+package calendarbot
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lestrrat/slack"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"google.golang.org/api/calendar/v3"
+)
+
+// weekBarWidth is the length of the longest bar in the week summary.
+// Busier weeks are scaled down to it
+const weekBarWidth = 20
+
+// NotifyWeekSummary posts the number of events on each of the seven
+// days starting on the day of t, as a bar per day:
+//
+//	Mon ███ 3
+//	Tue █ 1
+//
+// Days start at midnight in the location of t. Events lasting several
+// days count for each of them
+func (b *Bot) NotifyWeekSummary(ctx context.Context, t time.Time) error {
+	if b.Paused() {
+		return errPaused
+	}
+
+	s, err := b.CalendarService(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to create calendar service")
+	}
+
+	start := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	end := start.AddDate(0, 0, 7)
+
+	// The same event shows up in each calendar it was added to
+	var items []*calendar.Event
+	seen := make(map[string]bool)
+	for _, id := range b.calendarIDs() {
+		events, err := b.listEvents(ctx, b.eventsList(s, id).
+			TimeMin(start.Format(time.RFC3339)).
+			TimeMax(end.Format(time.RFC3339)).
+			SingleEvents(true))
+		if err != nil {
+			return errors.Wrapf(err, "failed to list events of %s", id)
+		}
+		for _, event := range b.fetchOmittedAttendees(ctx, s, id, events) {
+			if !seen[event.Id] {
+				seen[event.Id] = true
+				items = append(items, event)
+			}
+		}
+	}
+
+	items = b.filterEvents(items)
+	if b.SkipAllDay {
+		items = withoutAllDay(items)
+	}
+
+	counts, err := weekCounts(items, start)
+	if err != nil {
+		return err
+	}
+
+	params := b.slackParams("")
+	params.Attachments = []slack.Attachment{
+		slack.Attachment{
+			Fallback:   "Week of " + start.Format("Jan 2"),
+			MarkdownIn: []string{"text"},
+			Text:       codeFence + "\n" + weekChart(start, counts) + codeFence,
+			Title:      "Week of " + start.Format("Jan 2"),
+		},
+	}
+	if err := b.postSlack(ctx, "Week at a glance", &params); err != nil {
+		return errors.Wrap(err, "failed to post message to slack")
+	}
+	return nil
+}
+
+// weekCounts returns the number of events on each of the seven days
+// starting at `start`. All-day events count for the dates they cover
+func weekCounts(events []*calendar.Event, start time.Time) ([7]int, error) {
+	var counts [7]int
+	loc := start.Location()
+	for _, event := range events {
+		t1, t2, allDay, err := eventTimes(event)
+		if err != nil {
+			return counts, err
+		}
+		// Dates are the same everywhere, so move them to the week's
+		// location rather than converting them
+		if allDay {
+			t1 = time.Date(t1.Year(), t1.Month(), t1.Day(), 0, 0, 0, 0, loc)
+			t2 = time.Date(t2.Year(), t2.Month(), t2.Day(), 0, 0, 0, 0, loc)
+		}
+		for i := range counts {
+			dayStart := start.AddDate(0, 0, i)
+			dayEnd := start.AddDate(0, 0, i+1)
+			// Events without a duration still happen on their day
+			if t1.Before(dayEnd) && (t2.After(dayStart) || (t1.Equal(t2) && !t1.Before(dayStart))) {
+				counts[i]++
+			}
+		}
+	}
+	return counts, nil
+}
+
+// weekChart renders counts as a bar per day starting at `start`
+func weekChart(start time.Time, counts [7]int) string {
+	max := 0
+	for _, n := range counts {
+		if n > max {
+			max = n
+		}
+	}
+
+	var buf bytes.Buffer
+	for i, n := range counts {
+		width := n
+		if max > weekBarWidth {
+			// Round up, so that days with events always get a bar
+			width = (n*weekBarWidth + max - 1) / max
+		}
+		buf.WriteString(start.AddDate(0, 0, i).Format("Mon"))
+		if width > 0 {
+			buf.WriteByte(' ')
+			buf.WriteString(strings.Repeat("█", width))
+		}
+		fmt.Fprintf(&buf, " %d\n", n)
+	}
+	return buf.String()
+}
@@ -0,0 +1,132 @@
+package calendarbot
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lestrrat/slack"
+	"google.golang.org/api/calendar/v3"
+)
+
+func TestWeekCounts(t *testing.T) {
+	// Monday
+	start := mustParseTime(t, "2017-01-09T00:00:00Z")
+	tokyo := time.FixedZone("JST", 9*60*60)
+
+	tests := []struct {
+		name   string
+		start  time.Time
+		events []*calendar.Event
+		expect [7]int
+	}{
+		{"empty", start, nil, [7]int{}},
+		{"varied", start, []*calendar.Event{
+			testEvent("a", "2017-01-09T09:00:00Z", "2017-01-09T10:00:00Z"),
+			testEvent("b", "2017-01-09T11:00:00Z", "2017-01-09T12:00:00Z"),
+			testEvent("c", "2017-01-09T13:00:00Z", "2017-01-09T14:00:00Z"),
+			testEvent("d", "2017-01-10T09:00:00Z", "2017-01-10T10:00:00Z"),
+			testEvent("e", "2017-01-13T09:00:00Z", "2017-01-13T10:00:00Z"),
+			testEvent("f", "2017-01-13T15:00:00Z", "2017-01-13T16:00:00Z"),
+		}, [7]int{3, 1, 0, 0, 2, 0, 0}},
+		{"several days", start, []*calendar.Event{
+			testEvent("offsite", "2017-01-11T09:00:00Z", "2017-01-12T17:00:00Z"),
+			testAllDayEvent("holiday", "2017-01-14", "2017-01-16"),
+		}, [7]int{0, 0, 1, 1, 0, 1, 1}},
+		{"ends at midnight", start, []*calendar.Event{
+			testEvent("late", "2017-01-09T23:00:00Z", "2017-01-10T00:00:00Z"),
+			testEvent("reminder", "2017-01-10T00:00:00Z", "2017-01-10T00:00:00Z"),
+		}, [7]int{1, 1, 0, 0, 0, 0, 0}},
+		{"outside", start, []*calendar.Event{
+			testEvent("before", "2017-01-08T09:00:00Z", "2017-01-08T10:00:00Z"),
+			testEvent("after", "2017-01-16T09:00:00Z", "2017-01-16T10:00:00Z"),
+		}, [7]int{}},
+		{"location", start.In(tokyo).Add(-9 * time.Hour), []*calendar.Event{
+			// 23:00 on Monday in UTC is Tuesday in Tokyo
+			testEvent("a", "2017-01-09T23:00:00Z", "2017-01-09T23:30:00Z"),
+			testAllDayEvent("holiday", "2017-01-09", "2017-01-10"),
+		}, [7]int{1, 1, 0, 0, 0, 0, 0}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			counts, err := weekCounts(test.events, test.start)
+			if err != nil {
+				t.Fatalf("weekCounts failed: %s", err)
+			}
+			if counts != test.expect {
+				t.Errorf("expected %v, got %v", test.expect, counts)
+			}
+		})
+	}
+}
+
+func TestWeekChart(t *testing.T) {
+	start := mustParseTime(t, "2017-01-09T00:00:00Z")
+	tests := []struct {
+		name   string
+		counts [7]int
+		expect []string
+	}{
+		{"varied", [7]int{3, 1, 0, 2, 5, 0, 0}, []string{
+			"Mon ███ 3",
+			"Tue █ 1",
+			"Wed 0",
+			"Thu ██ 2",
+			"Fri █████ 5",
+			"Sat 0",
+			"Sun 0",
+		}},
+		{"scaled", [7]int{40, 20, 1, 0, 0, 0, 0}, []string{
+			"Mon " + strings.Repeat("█", 20) + " 40",
+			"Tue " + strings.Repeat("█", 10) + " 20",
+			"Wed █ 1",
+			"Thu 0",
+			"Fri 0",
+			"Sat 0",
+			"Sun 0",
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lines := strings.Split(strings.TrimSuffix(weekChart(start, test.counts), "\n"), "\n")
+			if !reflect.DeepEqual(lines, test.expect) {
+				t.Errorf("expected %q, got %q", test.expect, lines)
+			}
+		})
+	}
+}
+
+func TestNotifyWeekSummary(t *testing.T) {
+	cal := &fakeCalendar{events: []*calendar.Event{
+		testEvent("a", "2017-01-09T09:00:00Z", "2017-01-09T10:00:00Z"),
+		testEvent("b", "2017-01-09T11:00:00Z", "2017-01-09T12:00:00Z"),
+		testEvent("c", "2017-01-11T09:00:00Z", "2017-01-11T10:00:00Z"),
+	}}
+	slackAPI := &fakeSlack{}
+	b := newTestBot()
+	b.SlackTransport = slackAPI.transport
+
+	if err := b.NotifyWeekSummary(cal.context(), mustParseTime(t, "2017-01-09T08:30:00Z")); err != nil {
+		t.Fatalf("NotifyWeekSummary failed: %s", err)
+	}
+
+	query := cal.requests[0].URL.Query()
+	if min, max := query.Get("timeMin"), query.Get("timeMax"); min != "2017-01-09T00:00:00Z" || max != "2017-01-16T00:00:00Z" {
+		t.Errorf("expected the week to be listed, got %s to %s", min, max)
+	}
+
+	posts := slackAPI.posts()
+	if len(posts) != 1 {
+		t.Fatalf("expected 1 post, got %d", len(posts))
+	}
+	var attachments []slack.Attachment
+	if err := json.Unmarshal([]byte(posts[0].Form.Get("attachments")), &attachments); err != nil {
+		t.Fatalf("failed to decode attachments: %s", err)
+	}
+	expect := "```\nMon ██ 2\nTue 0\nWed █ 1\nThu 0\nFri 0\nSat 0\nSun 0\n```"
+	if len(attachments) != 1 || attachments[0].Title != "Week of Jan 9" || attachments[0].Text != expect {
+		t.Errorf("expected %q, got %#v", expect, attachments)
+	}
+}
@@ -0,0 +1,22 @@
+package calendarbot
+
+import "time"
+
+// NextWindow returns a time window that starts at the next occurrence
+// of hour:minute in now's location (which may be now itself), and ends
+// `length` later. It can be used to compute the arguments to the Notify
+// methods, e.g. to post the agenda for the next working day:
+//
+//	start, end := calendarbot.NextWindow(time.Now(), 9, 0, 9*time.Hour)
+//	bot.NotifyUpcomingEvents(ctx, start, end.Sub(start))
+//
+// If hour:minute does not exist on a given day because of a daylight
+// saving time transition, it is normalized the same way time.Date does
+func NextWindow(now time.Time, hour, minute int, length time.Duration) (time.Time, time.Time) {
+	y, m, d := now.Date()
+	start := time.Date(y, m, d, hour, minute, 0, 0, now.Location())
+	if start.Before(now) {
+		start = time.Date(y, m, d+1, hour, minute, 0, 0, now.Location())
+	}
+	return start, start.Add(length)
+}
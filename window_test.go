@@ -0,0 +1,95 @@
+package calendarbot
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNextWindow(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("time zone data not available: %s", err)
+	}
+
+	tests := []struct {
+		name   string
+		now    time.Time
+		start  time.Time
+		end    time.Time
+		length time.Duration
+	}{
+		{
+			name:   "later today",
+			now:    time.Date(2017, 1, 10, 7, 30, 0, 0, time.UTC),
+			start:  time.Date(2017, 1, 10, 9, 0, 0, 0, time.UTC),
+			end:    time.Date(2017, 1, 10, 18, 0, 0, 0, time.UTC),
+			length: 9 * time.Hour,
+		},
+		{
+			name:   "exactly now",
+			now:    time.Date(2017, 1, 10, 9, 0, 0, 0, time.UTC),
+			start:  time.Date(2017, 1, 10, 9, 0, 0, 0, time.UTC),
+			end:    time.Date(2017, 1, 10, 18, 0, 0, 0, time.UTC),
+			length: 9 * time.Hour,
+		},
+		{
+			name:   "tomorrow",
+			now:    time.Date(2017, 1, 10, 9, 0, 1, 0, time.UTC),
+			start:  time.Date(2017, 1, 11, 9, 0, 0, 0, time.UTC),
+			end:    time.Date(2017, 1, 11, 18, 0, 0, 0, time.UTC),
+			length: 9 * time.Hour,
+		},
+		{
+			name:   "end of year",
+			now:    time.Date(2016, 12, 31, 22, 0, 0, 0, time.UTC),
+			start:  time.Date(2017, 1, 1, 9, 0, 0, 0, time.UTC),
+			end:    time.Date(2017, 1, 1, 18, 0, 0, 0, time.UTC),
+			length: 9 * time.Hour,
+		},
+		{
+			name:   "window crossing midnight",
+			now:    time.Date(2017, 1, 10, 12, 0, 0, 0, time.UTC),
+			start:  time.Date(2017, 1, 10, 22, 0, 0, 0, time.UTC),
+			end:    time.Date(2017, 1, 11, 6, 0, 0, 0, time.UTC),
+			length: 8 * time.Hour,
+		},
+		{
+			// Clocks go forward at 02:00 on 2017-03-12, so the day
+			// only has 23 hours
+			name:   "start of daylight saving time",
+			now:    time.Date(2017, 3, 11, 11, 0, 0, 0, ny),
+			start:  time.Date(2017, 3, 12, 9, 0, 0, 0, ny),
+			end:    time.Date(2017, 3, 12, 18, 0, 0, 0, ny),
+			length: 9 * time.Hour,
+		},
+		{
+			// Clocks go back at 02:00 on 2017-11-05, so the day has
+			// 25 hours
+			name:   "end of daylight saving time",
+			now:    time.Date(2017, 11, 4, 9, 30, 0, 0, ny),
+			start:  time.Date(2017, 11, 5, 9, 0, 0, 0, ny),
+			end:    time.Date(2017, 11, 5, 18, 0, 0, 0, ny),
+			length: 9 * time.Hour,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hour, minute, _ := test.start.Clock()
+			start, end := NextWindow(test.now, hour, minute, test.length)
+			if !start.Equal(test.start) {
+				t.Errorf("expected start %s, got %s", test.start, start)
+			}
+			if !end.Equal(test.end) {
+				t.Errorf("expected end %s, got %s", test.end, end)
+			}
+		})
+	}
+
+	// 22 wall clock hours between 11:00 EST and 09:00 EDT the next day
+	now := time.Date(2017, 3, 11, 11, 0, 0, 0, ny)
+	start, _ := NextWindow(now, 9, 0, time.Hour)
+	if d := start.Sub(now); d != 21*time.Hour {
+		t.Errorf("expected 21h until the window across DST, got %s", d)
+	}
+}